@@ -0,0 +1,123 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// ACLList returns the ACL rules of the node at addr, as reported by ACL LIST
+func (a *Admin) ACLList(ctx context.Context, addr string) ([]string, error) {
+	c := a.nodeClient(addr)
+	defer c.Close()
+	cmd := redis.NewStringSliceCmd(ctx, "ACL", "LIST")
+	_ = c.Process(ctx, cmd)
+	rules, err := cmd.Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list ACL of node %s: %v", addr, err)
+	}
+	return rules, nil
+}
+
+// ACLDrift returns the ACL rules of the nodes, keyed by node ID, whose ACL set differs from the one shared
+// by the majority of the nodes. When several sets are shared by the same number of nodes, the first one
+// in lexical order is considered as the reference.
+func (a *Admin) ACLDrift(ctx context.Context) (map[string][]string, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string][]string)
+	sets := make(map[string]string)
+	counts := make(map[string]int)
+	for _, node := range nodes.FilterByFunc(func(n *Node) bool {
+		return !n.HasStatus(NodeStatusHandshake) && !n.HasStatus(NodeStatusNoAddr)
+	}) {
+		list, err := a.ACLList(ctx, node.IPPort())
+		if err != nil {
+			return nil, err
+		}
+		sorted := append([]string{}, list...)
+		sort.Strings(sorted)
+		set := strings.Join(sorted, "\n")
+		rules[node.ID] = list
+		sets[node.ID] = set
+		counts[set]++
+	}
+
+	var reference string
+	max := 0
+	for set, count := range counts {
+		if count > max || (count == max && set < reference) {
+			reference, max = set, count
+		}
+	}
+	drift := make(map[string][]string)
+	for id, set := range sets {
+		if set != reference {
+			drift[id] = rules[id]
+		}
+	}
+	return drift, nil
+}
+
+// aclFileNotConfigured error message of ACL SAVE on a node using no ACL file
+const aclFileNotConfigured = "not configured to use an ACL file"
+
+// SetACL applies the ACL rules on every node of the cluster with ACL SETUSER and, if save is set, persists
+// them with ACL SAVE on the nodes using an ACL file. Rules use the ACL LIST format, ex: user operator on
+// >pass +@all, the "user" prefix being optional. The per-node failures are returned in a NodesError.
+func (a *Admin) SetACL(ctx context.Context, rules []string, save bool) error {
+	setUsers := make([][]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		fields := strings.Fields(rule)
+		if len(fields) > 0 && fields[0] == "user" {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return fmt.Errorf("invalid ACL rule %q: no user", rule)
+		}
+		args := []interface{}{"ACL", "SETUSER"}
+		for _, field := range fields {
+			args = append(args, field)
+		}
+		setUsers = append(setUsers, args)
+	}
+
+	return a.ForEachNode(ctx, func(ctx context.Context, c *redis.Client, node *Node) error {
+		for _, args := range setUsers {
+			if err := c.Do(ctx, args...).Err(); err != nil {
+				return fmt.Errorf("unable to set ACL user %s: %v", args[2], err)
+			}
+		}
+		if !save {
+			return nil
+		}
+		if err := c.Do(ctx, "ACL", "SAVE").Err(); err != nil {
+			if strings.Contains(err.Error(), aclFileNotConfigured) {
+				logger.V(4).Infof("node %s uses no ACL file, ACL not saved", node.ID)
+				return nil
+			}
+			return fmt.Errorf("unable to save ACL: %v", err)
+		}
+		return nil
+	})
+}
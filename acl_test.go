@@ -0,0 +1,127 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// aclHandler returns a handler replying CLUSTER NODES and the ACL rules
+func aclHandler(view *string, rules ...string) fakeHandler {
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return *view
+		case "ACL LIST":
+			return rules
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminACLDrift(t *testing.T) {
+	var view string
+	defaultUser := "user default on nopass ~* &* +@all"
+	operator := "user operator on #5e88 ~* &* +@all"
+	a1 := newFakeRedis(t, aclHandler(&view, defaultUser, operator))
+	a2 := newFakeRedis(t, aclHandler(&view, operator, defaultUser))
+	b1 := newFakeRedis(t, aclHandler(&view, defaultUser, operator))
+	b2 := newFakeRedis(t, aclHandler(&view, defaultUser))
+	view = fakeClusterNodes(
+		fakeNodeLine("A1", a1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("A2", a2.Addr(), "slave", "A1"),
+		fakeNodeLine("B1", b1.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("B2", b2.Addr(), "slave", "B1"),
+	)
+	a := newTestAdmin(a1.Addr())
+
+	rules, err := a.ACLList(context.Background(), b1.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{defaultUser, operator}; !reflect.DeepEqual(rules, want) {
+		t.Errorf("ACLList() = %v, want %v", rules, want)
+	}
+
+	drift, err := a.ACLDrift(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string][]string{"B2": {defaultUser}}; !reflect.DeepEqual(drift, want) {
+		t.Errorf("ACLDrift() = %v, want %v", drift, want)
+	}
+}
+
+func TestAdminSetACL(t *testing.T) {
+	var view string
+	handler := func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return view
+		case "ACL SAVE":
+			return fakeError("ERR This Redis instance is not configured to use an ACL file.")
+		}
+		return fakeOK
+	}
+	master := newFakeRedis(t, handler)
+	slave := newFakeRedis(t, handler)
+	failing := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "ACL SETUSER" {
+			return fakeError("ERR Error in ACL SETUSER modifier 'bad'")
+		}
+		return fakeOK
+	})
+	view = fakeClusterNodes(
+		fakeNodeLine("A", master.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", slave.Addr(), "slave", "A"),
+	)
+	a := newTestAdmin(master.Addr())
+	rules := []string{"user operator on >secret ~* +@all", "reader on >secret ~* +@read"}
+
+	if err := a.SetACL(context.Background(), rules, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, fake := range []*fakeRedis{master, slave} {
+		calls := fake.Calls("ACL SETUSER")
+		if len(calls) != 2 || calls[0][2] != "operator" || calls[1][2] != "reader" || len(calls[1]) != 7 {
+			t.Errorf("expected both rules to be set on %s, got %v", fake.Addr(), calls)
+		}
+		if calls := fake.Calls("ACL SAVE"); len(calls) != 0 {
+			t.Errorf("expected no ACL SAVE on %s, got %v", fake.Addr(), calls)
+		}
+	}
+
+	if err := a.SetACL(context.Background(), rules, true); err != nil {
+		t.Fatalf("unexpected error with no ACL file: %v", err)
+	}
+	if calls := master.Calls("ACL SAVE"); len(calls) != 1 {
+		t.Errorf("expected the ACL to be saved, got %v", calls)
+	}
+
+	view += fakeNodeLine("C", failing.Addr(), "slave", "A") + "\n"
+	err := a.SetACL(context.Background(), rules, false)
+	if !IsNodesError(err) {
+		t.Fatalf("expected a NodesError, got %v", err)
+	}
+	if errs := err.(NodesError).Errs; len(errs) != 1 || errs[failing.Addr()] == nil {
+		t.Errorf("expected only the failing node error, got %v", errs)
+	}
+	if calls := master.Calls("ACL SETUSER"); len(calls) != 6 {
+		t.Errorf("expected the rules to be applied on the healthy nodes, got %d calls", len(calls))
+	}
+}
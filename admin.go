@@ -17,11 +17,15 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	redis "github.com/go-redis/redis/v8"
-	"k8s.io/klog/v2"
+	corev1 "k8s.io/api/core/v1"
 
 	"github.com/kubernetes-app/redisutil/utils"
 )
@@ -35,6 +39,12 @@ const (
 	ResetHard = "HARD"
 	// ResetSoft SOFT mode for RESET command
 	ResetSoft = "SOFT"
+
+	// ClusterForgetTimeout time during which a forgotten node can't be re-added through gossip
+	ClusterForgetTimeout = 60 * time.Second
+
+	// slotsBatchSize max number of slots sent in a single CLUSTER ADDSLOTS or DELSLOTS
+	slotsBatchSize = 1000
 )
 
 // AdminInterface redis cluster admin interface
@@ -46,41 +56,125 @@ type AdminInterface interface {
 	// CloseClusterClient the admin connections
 	CloseClusterClient()
 	// GetClusterInfos get node infos for all nodes
-	GetClusterInfos() (*map[string]string, error)
+	GetClusterInfos(ctx context.Context) (*map[string]string, error)
 	// GetClusterNodes get node infos for all nodes
-	GetClusterNodes() (*Nodes, error)
+	GetClusterNodes(ctx context.Context) (*Nodes, error)
 	// SetConfigIfNeed set redis config
-	SetConfigIfNeed(newConfig map[string]string) error
+	SetConfigIfNeed(ctx context.Context, newConfig map[string]string) error
 	// GetHashMaxSlot get the max slot value
 	GetHashMaxSlot() Slot
 }
 
+// AdminOptions options of the Admin connections to the redis nodes
+type AdminOptions struct {
+	// Username ACL user authenticating on the nodes (redis 6+), the default user if empty
+	Username string
+	// Password used to authenticate on the nodes
+	Password string
+	// TLSConfig TLS configuration of the connections, nil for plain text connections. For mutual TLS
+	// it holds the client Certificates and the RootCAs verifying the nodes, see LoadTLSConfig.
+	TLSConfig *tls.Config
+	// JoinTimeout max time AddNode, ScaleUpTo and Apply wait for a new node to join the cluster, 30s if 0
+	JoinTimeout time.Duration
+	// PromoteTimeout max time PromoteReplica and SafeFailover wait for the replica to catch up, then to
+	// become master, 10s if 0
+	PromoteTimeout time.Duration
+	// PromoteMaxLag replication lag in bytes under which a replica is caught up enough to be promoted, 1MiB
+	// if 0. A replica under continuous writes rarely reports no lag, CLUSTER FAILOVER waits for the rest.
+	PromoteMaxLag int64
+}
+
 // Admin wraps redis cluster admin logic
 type Admin struct {
 	hashMaxSlots Slot
+	opts         AdminOptions
 	rc           *redis.Client
 	rcc          *redis.ClusterClient
+	// dial used to check the nodes reachability
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// handshakes first time each node address has been seen in handshake
+	mu         sync.Mutex
+	handshakes map[string]time.Time
+	// imports first time each dangling importing slot has been seen, keyed by node ID and slot
+	imports map[string]time.Time
+	// quiesced min-replicas-to-write of each master quiesced by SetReadOnly, keyed by address
+	quiesced map[string]string
+	// pods of the redis nodes, see SetPods
+	pods []*corev1.Pod
 }
 
 // NewAdmin returns new AdminInterface instance
 // at the same time it connects to all Redis Nodes thanks to the addrs list
 func NewAdmin(addrs []string, password string) AdminInterface {
+	return NewAdminWithOptions(addrs, AdminOptions{Password: password})
+}
+
+// NewAdminWithOptions returns new AdminInterface instance connecting to the Redis Nodes with the options
+func NewAdminWithOptions(addrs []string, opts AdminOptions) AdminInterface {
 	return &Admin{
 		hashMaxSlots: defaultHashMaxSlots,
-		rc:           NewClient(addrs[0], password),
-		rcc:          NewClusterClient(addrs, password),
+		opts:         opts,
+		rc:           newClient(addrs[0], opts),
+		rcc:          newClusterClient(addrs, opts),
+		handshakes:   map[string]time.Time{},
+		imports:      map[string]time.Time{},
+		quiesced:     map[string]string{},
+		dial:         (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
 	}
 }
 
 func NewClient(addr, password string) *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       0,
-	})
+	return newClient(addr, AdminOptions{Password: password})
+}
+
+// NewClientWithOptions returns a client connected to the node at addr with the options, ex: to authenticate
+// as an ACL user
+func NewClientWithOptions(addr string, opts AdminOptions) *redis.Client {
+	return newClient(addr, opts)
+}
+
+// nodeReadTimeout read timeout of the clients connected to a single node, the go-redis default
+var nodeReadTimeout = 3 * time.Second
+
+// newClient returns a client connected to the node at addr with the options
+func newClient(addr string, opts AdminOptions) *redis.Client {
+	return redis.NewClient(clientOptions(addr, opts))
+}
+
+// clientOptions returns the go-redis options of a client connected to the node at addr
+func clientOptions(addr string, opts AdminOptions) *redis.Options {
+	return &redis.Options{
+		Addr:        addr,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		DB:          0,
+		TLSConfig:   opts.TLSConfig,
+		ReadTimeout: nodeReadTimeout,
+	}
+}
+
+// blockingTimeout returns the read timeout of a client sending a command that blocks the node up to timeout:
+// a second more than timeout to get the reply, or none when the command blocks forever (timeout 0)
+func blockingTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return 0
+	}
+	return timeout + time.Second
 }
 
 func NewClusterClient(addrs []string, password string) *redis.ClusterClient {
+	return newClusterClient(addrs, AdminOptions{Password: password})
+}
+
+// NewClusterClientWithOptions returns a cluster client connected to the nodes at addrs with the options, ex: to
+// authenticate as an ACL user
+func NewClusterClientWithOptions(addrs []string, opts AdminOptions) *redis.ClusterClient {
+	return newClusterClient(addrs, opts)
+}
+
+// newClusterClient returns a cluster client connected to the nodes at addrs with the options
+func newClusterClient(addrs []string, opts AdminOptions) *redis.ClusterClient {
 	opt := &redis.ClusterOptions{
 		DialTimeout:  10 * time.Second,
 		ReadTimeout:  30 * time.Second,
@@ -94,10 +188,61 @@ func NewClusterClient(addrs []string, password string) *redis.ClusterClient {
 		IdleCheckFrequency: 100 * time.Millisecond,
 	}
 	opt.Addrs = addrs
-	opt.Password = password
+	opt.Username = opts.Username
+	opt.Password = opts.Password
+	opt.TLSConfig = opts.TLSConfig
 	return redis.NewClusterClient(opt)
 }
 
+// nodeClient returns a new client connected to the node at addr, it must be closed by the caller
+func (a *Admin) nodeClient(addr string) *redis.Client {
+	return newClient(addr, a.opts)
+}
+
+// replicaClient returns a new client connected to the replica at addr issuing READONLY on each of its
+// connections, so that the reads of the slots served by its master don't get MOVED errors. It must be
+// closed by the caller.
+func (a *Admin) replicaClient(addr string) *redis.Client {
+	opt := clientOptions(addr, a.opts)
+	opt.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		return cn.ReadOnly(ctx).Err()
+	}
+	return redis.NewClient(opt)
+}
+
+// ForEachNode runs fn on every node of the cluster, masters and slaves, nodes in handshake or without
+// address excepted. fn is run on all the nodes even if some fail, the failures are returned in a NodesError.
+func (a *Admin) ForEachNode(ctx context.Context, fn func(ctx context.Context, c *redis.Client, node *Node) error) error {
+	return a.forEachNodeFunc(ctx, func(*Node) bool { return true }, fn)
+}
+
+// forEachMaster runs fn on every master of the cluster, like ForEachNode
+func (a *Admin) forEachMaster(ctx context.Context, fn func(ctx context.Context, c *redis.Client, node *Node) error) error {
+	return a.forEachNodeFunc(ctx, func(n *Node) bool { return n.GetRole() == RedisMasterRole }, fn)
+}
+
+// forEachNodeFunc runs fn on every node of the cluster matching the filter, like ForEachNode
+func (a *Admin) forEachNodeFunc(ctx context.Context, filter func(*Node) bool, fn func(ctx context.Context, c *redis.Client, node *Node) error) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	errs := make(map[string]error)
+	for _, node := range nodes.FilterByFunc(func(n *Node) bool {
+		return filter(n) && !n.HasStatus(NodeStatusHandshake) && !n.HasStatus(NodeStatusNoAddr)
+	}) {
+		c := a.nodeClient(node.IPPort())
+		if err := fn(ctx, c, node); err != nil {
+			errs[node.IPPort()] = err
+		}
+		c.Close()
+	}
+	if len(errs) > 0 {
+		return NodesError{Errs: errs}
+	}
+	return nil
+}
+
 // Close used to close all possible resources instantiate by the Admin
 func (a *Admin) CloseClient() {
 	a.rc.Close()
@@ -108,19 +253,38 @@ func (a *Admin) CloseClusterClient() {
 	a.rcc.Close()
 }
 
+// ReloadState refreshes the slot mapping of the cluster client, typically after a resharding so that the
+// next commands are routed to the new slot owners without going through MOVED redirections.
+// ClusterClient.ReloadState only schedules a reload in background, whereas ForEachShard reloads the
+// state before returning.
+func (a *Admin) ReloadState(ctx context.Context) error {
+	if err := a.rcc.ForEachShard(ctx, func(ctx context.Context, c *redis.Client) error { return nil }); err != nil {
+		return fmt.Errorf("unable to reload the cluster client state: %v", err)
+	}
+	return nil
+}
+
 // GetHashMaxSlot get the max slot value
 func (a *Admin) GetHashMaxSlot() Slot {
 	return a.hashMaxSlots
 }
 
 // GetClusterInfos return the Nodes infos for all nodes
-func (a *Admin) GetClusterInfos() (*map[string]string, error) {
-	ctx := context.Background()
-	raw, err := a.rc.ClusterInfo(ctx).Result()
+func (a *Admin) GetClusterInfos(ctx context.Context) (*map[string]string, error) {
+	return getClusterInfos(ctx, a.rc)
+}
+
+// getClusterInfos return the cluster infos as seen by the node the client is connected to, the values
+// stripped of the \r of the CLUSTER INFO line endings
+func getClusterInfos(ctx context.Context, c *redis.Client) (*map[string]string, error) {
+	raw, err := c.ClusterInfo(ctx).Result()
 	if err != nil {
 		return nil, fmt.Errorf("wrong format from CLUSTER INFO: %v", err)
 	}
 	clusterInfos := DecodeClusterInfos(&raw)
+	for key, value := range *clusterInfos {
+		(*clusterInfos)[key] = strings.TrimSpace(value)
+	}
 	return clusterInfos, err
 }
 
@@ -143,14 +307,13 @@ var parseConfigMap = map[string]int8{
 }
 
 // SetConfigIfNeed set redis config
-func (a *Admin) SetConfigIfNeed(newConfig map[string]string) error {
-	ctx := context.Background()
+func (a *Admin) SetConfigIfNeed(ctx context.Context, newConfig map[string]string) error {
 	if err := a.rcc.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
 		for key, value := range newConfig {
 			if _, ok := parseConfigMap[key]; ok {
 				value, err := utils.ParseRedisMemConf(value)
 				if err != nil {
-					klog.Errorf("redis config format err, key: %s, value: %s, err: %v", key, value, err)
+					logger.Errorf("redis config format err, key: %s, value: %s, err: %v", key, value, err)
 					continue
 				}
 			}
@@ -171,7 +334,7 @@ func SetRedisConfig(ctx context.Context, rc *redis.Client, newConfig map[string]
 		if _, ok := parseConfigMap[key]; ok {
 			value, err := utils.ParseRedisMemConf(value)
 			if err != nil {
-				klog.Errorf("redis config format err, key: %s, value: %s, err: %v", key, value, err)
+				logger.Errorf("redis config format err, key: %s, value: %s, err: %v", key, value, err)
 				continue
 			}
 		}
@@ -182,9 +345,21 @@ func SetRedisConfig(ctx context.Context, rc *redis.Client, newConfig map[string]
 	return nil
 }
 
+// getRedisConfig returns the value of the config parameter with CONFIG GET, empty if unknown to the node
+func getRedisConfig(ctx context.Context, rc *redis.Client, param string) (string, error) {
+	values, err := rc.ConfigGet(ctx, param).Result()
+	if err != nil {
+		return "", fmt.Errorf("unable to get config %s: %v", param, err)
+	}
+	if len(values) < 2 {
+		return "", nil
+	}
+	value, _ := values[1].(string)
+	return value, nil
+}
+
 // UpdateMasterConfig set redis master config
-func (a *Admin) UpdateMasterConfig(newConfig map[string]string) error {
-	ctx := context.Background()
+func (a *Admin) UpdateMasterConfig(ctx context.Context, newConfig map[string]string) error {
 	if err := a.rcc.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
 		return SetRedisConfig(ctx, master, newConfig)
 	}); err != nil {
@@ -194,8 +369,7 @@ func (a *Admin) UpdateMasterConfig(newConfig map[string]string) error {
 }
 
 // SetConfigIfNeed set redis config
-func (a *Admin) UpdateSlaveConfig(newConfig map[string]string) error {
-	ctx := context.Background()
+func (a *Admin) UpdateSlaveConfig(ctx context.Context, newConfig map[string]string) error {
 	if err := a.rcc.ForEachSlave(ctx, func(ctx context.Context, slave *redis.Client) error {
 		return SetRedisConfig(ctx, slave, newConfig)
 	}); err != nil {
@@ -204,17 +378,14 @@ func (a *Admin) UpdateSlaveConfig(newConfig map[string]string) error {
 	return nil
 }
 
-func (a *Admin) GetClusterNodes() (*Nodes, error) {
-	ctx := context.Background()
-	cmd := a.rc.ClusterNodes(ctx)
-	if err := a.rc.Process(ctx, cmd); err != nil {
-		return nil, err
-	}
-
-	var raw string
-	var err error
-	raw, err = cmd.Result()
+// GetClusterNodes return the Nodes infos as seen by the admin connection node
+func (a *Admin) GetClusterNodes(ctx context.Context) (*Nodes, error) {
+	return getClusterNodes(ctx, a.rc)
+}
 
+// getClusterNodes return the Nodes infos as seen by the node the client is connected to
+func getClusterNodes(ctx context.Context, c *redis.Client) (*Nodes, error) {
+	raw, err := c.ClusterNodes(ctx).Result()
 	if err != nil {
 		return nil, fmt.Errorf("wrong format from CLUSTER NODES: %v", err)
 	}
@@ -222,3 +393,424 @@ func (a *Admin) GetClusterNodes() (*Nodes, error) {
 	nodeInfos := DecodeNodeInfos(&raw)
 	return nodeInfos, nil
 }
+
+// FailureReports returns the number of active failure reports for the node ID, as counted by the admin connection node.
+// A high count means many peers consider the node as failing.
+func (a *Admin) FailureReports(ctx context.Context, nodeID string) (int64, error) {
+	count, err := a.rc.ClusterCountFailureReports(ctx, nodeID).Result()
+	if err != nil {
+		return 0, fmt.Errorf("unable to count failure reports for node %s: %v", nodeID, err)
+	}
+	return count, nil
+}
+
+// AttachSlaveToMaster attaches the slave to the master ID with CLUSTER REPLICATE.
+// The command is skipped when the slave already replicates the master, to avoid a needless resync;
+// the returned boolean is false in that case.
+func (a *Admin) AttachSlaveToMaster(ctx context.Context, slave *Node, masterID string) (bool, error) {
+	c := a.nodeClient(slave.IPPort())
+	defer c.Close()
+
+	nodes, err := getClusterNodes(ctx, c)
+	if err != nil {
+		return false, err
+	}
+	if current, err := nodes.GetNodeByID(slave.ID); err == nil && current.MasterReferent == masterID {
+		logger.V(4).Infof("node %s already replicates master %s", slave.ID, masterID)
+		return false, nil
+	}
+
+	if err := c.ClusterReplicate(ctx, masterID).Err(); err != nil {
+		return false, fmt.Errorf("unable to attach node %s to master %s: %v", slave.ID, masterID, err)
+	}
+	return true, nil
+}
+
+// readOnlyMinReplicasToWrite min-replicas-to-write value that no master can satisfy
+const readOnlyMinReplicasToWrite = "65536"
+
+// SetReadOnly quiesces (readonly true) or restores (readonly false) writes on the node at addr.
+//   - on a replica it issues READONLY or READWRITE, which only applies to the admin connection
+//     to that node: it allows or forbids reads of the slots served by its master.
+//   - on a master it sets min-replicas-to-write to a value that can't be satisfied so writes are
+//     rejected with NOREPLICAS, then restores the value it had before being quiesced. A master not
+//     quiesced by this Admin is restored to 0, the default.
+func (a *Admin) SetReadOnly(ctx context.Context, addr string, readonly bool) error {
+	info, err := a.nodeInfo(ctx, addr, "replication")
+	if err != nil {
+		return err
+	}
+
+	c := a.nodeClient(addr)
+	defer c.Close()
+	switch info["role"] {
+	case RedisMasterRole:
+		err = a.setMasterReadOnly(ctx, c, addr, readonly)
+	case RedisSlaveRole:
+		if readonly {
+			err = c.ReadOnly(ctx).Err()
+		} else {
+			err = c.ReadWrite(ctx).Err()
+		}
+	default:
+		return fmt.Errorf("unknown role %q for node %s", info["role"], addr)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to set readonly %v on node %s: %v", readonly, addr, err)
+	}
+	return nil
+}
+
+// setMasterReadOnly quiesces or restores the writes of the master at addr through its min-replicas-to-write
+func (a *Admin) setMasterReadOnly(ctx context.Context, c *redis.Client, addr string, readonly bool) error {
+	current, err := getRedisConfig(ctx, c, "min-replicas-to-write")
+	if err != nil {
+		return err
+	}
+	value := readOnlyMinReplicasToWrite
+	if readonly {
+		if current != readOnlyMinReplicasToWrite {
+			a.mu.Lock()
+			a.quiesced[addr] = current
+			a.mu.Unlock()
+		}
+	} else {
+		if current != readOnlyMinReplicasToWrite {
+			return nil
+		}
+		value = "0"
+		a.mu.Lock()
+		if previous, ok := a.quiesced[addr]; ok {
+			value = previous
+		}
+		a.mu.Unlock()
+	}
+	if err := c.ConfigSet(ctx, "min-replicas-to-write", value).Err(); err != nil {
+		return err
+	}
+	if !readonly {
+		a.mu.Lock()
+		delete(a.quiesced, addr)
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// ForgetNode runs CLUSTER FORGET id on every other node of the cluster, the failures are returned in a
+// NodesError. The replicas of the forgotten node are skipped, as a node refuses to forget its own master:
+// they must be reattached first (see ReattachOrphans). A node still owning slots isn't forgotten, as its
+// slots would be left uncovered: a SlotsOwnedError is returned instead. Unlike RemoveNode, the forgotten
+// node itself isn't contacted.
+func (a *Admin) ForgetNode(ctx context.Context, id string) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	if node, err := nodes.GetNodeByID(id); err == nil && len(node.Slots) > 0 {
+		return SlotsOwnedError{NodeID: id, Slots: node.Slots}
+	}
+	return a.forEachNodeFunc(ctx, func(n *Node) bool { return n.ID != id && n.MasterReferent != id }, func(ctx context.Context, c *redis.Client, node *Node) error {
+		return c.ClusterForget(ctx, id).Err()
+	})
+}
+
+// validateSlots returns an error if a slot is out of the 0-hashMaxSlots range or appears twice
+func (a *Admin) validateSlots(slots []Slot) error {
+	seen := make(map[Slot]bool, len(slots))
+	for _, slot := range slots {
+		if slot > a.hashMaxSlots {
+			return fmt.Errorf("slot %s out of range 0-%s", slot, a.hashMaxSlots)
+		}
+		if seen[slot] {
+			return fmt.Errorf("slot %s provided twice", slot)
+		}
+		seen[slot] = true
+	}
+	return nil
+}
+
+// SetSlots assigns the slots to the node at addr with CLUSTER ADDSLOTS, sent in batches of slotsBatchSize
+// slots. Nothing is sent if a slot is out of range or duplicated.
+func (a *Admin) SetSlots(ctx context.Context, addr string, slots []Slot) error {
+	if err := a.validateSlots(slots); err != nil {
+		return err
+	}
+	if start, err := a.clusterSlotsBatches(ctx, addr, "ADDSLOTS", slots); err != nil {
+		end := start + slotsBatchSize
+		if end > len(slots) {
+			end = len(slots)
+		}
+		return fmt.Errorf("unable to add slots %s to node %s: %v", formatSlots(slots[start:end]), addr, err)
+	}
+	return nil
+}
+
+// DelSlots releases the slots from the node at addr with CLUSTER DELSLOTS, sent in batches of slotsBatchSize
+// slots. Nothing is sent if a slot is out of range or duplicated. On failure a DelSlotsError reports how
+// many slots were removed and the remaining ones, so the caller can retry them.
+func (a *Admin) DelSlots(ctx context.Context, addr string, slots []Slot) error {
+	if err := a.validateSlots(slots); err != nil {
+		return err
+	}
+	if start, err := a.clusterSlotsBatches(ctx, addr, "DELSLOTS", slots); err != nil {
+		remaining := make([]Slot, len(slots)-start)
+		copy(remaining, slots[start:])
+		return DelSlotsError{Addr: addr, Removed: start, Remaining: remaining, Err: err}
+	}
+	return nil
+}
+
+// clusterSlotsBatches runs the CLUSTER subcommand taking slots (ADDSLOTS or DELSLOTS) on the node at addr,
+// in batches of slotsBatchSize slots. On failure it returns the index of the first slot of the failing batch.
+func (a *Admin) clusterSlotsBatches(ctx context.Context, addr, subcommand string, slots []Slot) (int, error) {
+	c := a.nodeClient(addr)
+	defer c.Close()
+	for start := 0; start < len(slots); start += slotsBatchSize {
+		end := start + slotsBatchSize
+		if end > len(slots) {
+			end = len(slots)
+		}
+		args := make([]interface{}, 0, 2+end-start)
+		args = append(args, "CLUSTER", subcommand)
+		for _, slot := range slots[start:end] {
+			args = append(args, int(slot))
+		}
+		if err := c.Do(ctx, args...).Err(); err != nil {
+			return start, err
+		}
+	}
+	return len(slots), nil
+}
+
+// RemoveNode removes the node ID from the cluster. The replicas of the removed node are first reattached to
+// the master needing a replica the most, as a node refuses to forget its own master. The node is then
+// forgotten by every other node within the forget timeout, the failing peers and the ones without address
+// excepted, and the failures are returned in a NodesError. Then the removed node forgets its peers so it
+// stops gossiping about them. Finally the remaining nodes are polled during the confirm duration to ensure
+// the node doesn't reappear, in which case a NodeRejoinedError is returned.
+func (a *Admin) RemoveNode(ctx context.Context, id string, confirm time.Duration) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	removed, err := nodes.GetNodeByID(id)
+	if err != nil {
+		return err
+	}
+	others := nodes.FilterByFunc(func(n *Node) bool { return n.ID != id })
+	peers := others.FilterByFunc(func(n *Node) bool {
+		return !n.HasStatus(NodeStatusHandshake) && !n.HasStatus(NodeStatusFail) && !n.HasStatus(NodeStatusNoAddr)
+	})
+
+	for _, replica := range peers.FilterByFunc(func(n *Node) bool { return n.MasterReferent == id }) {
+		master, err := others.MasterNeedingReplica()
+		if err != nil {
+			return fmt.Errorf("no master to attach replica %s of removed node %s to: %v", replica.ID, id, err)
+		}
+		if _, err := a.AttachSlaveToMaster(ctx, replica, master.ID); err != nil {
+			return err
+		}
+		logger.Infof("replica %s of removed node %s reattached to master %s", replica.ID, id, master.ID)
+		replica.MasterReferent = master.ID
+	}
+
+	start := time.Now()
+	errs := make(map[string]error)
+	for _, peer := range peers {
+		c := a.nodeClient(peer.IPPort())
+		if err := c.ClusterForget(ctx, id).Err(); err != nil {
+			errs[peer.IPPort()] = fmt.Errorf("unable to forget node %s: %v", id, err)
+		}
+		c.Close()
+	}
+	if len(errs) > 0 {
+		return NodesError{Errs: errs}
+	}
+	if elapsed := time.Since(start); elapsed > ClusterForgetTimeout {
+		return fmt.Errorf("node %s forgotten in %s, over the forget timeout, it may have been re-added", id, elapsed)
+	}
+
+	c := a.nodeClient(removed.IPPort())
+	for _, peer := range peers {
+		if err := c.ClusterForget(ctx, peer.ID).Err(); err != nil {
+			logger.V(4).Infof("removed node %s didn't forget node %s: %v", id, peer.ID, err)
+		}
+	}
+	c.Close()
+
+	interval := confirm / 4
+	if interval > time.Second {
+		interval = time.Second
+	}
+	deadline := time.Now().Add(confirm)
+	for {
+		for _, peer := range peers {
+			c := a.nodeClient(peer.IPPort())
+			view, err := getClusterNodes(ctx, c)
+			c.Close()
+			if err != nil {
+				return err
+			}
+			if _, err := view.GetNodeByID(id); err == nil {
+				return nodeRejoinedError
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// getSelfNode returns the Node the client is connected to, as seen by itself
+func getSelfNode(ctx context.Context, c *redis.Client) (*Node, error) {
+	id, err := c.Do(ctx, "CLUSTER", "MYID").Text()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get node ID: %v", err)
+	}
+	nodes, err := getClusterNodes(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return nodes.GetNodeByID(id)
+}
+
+// PreferMaster rebinds the single client, connected to the first address provided to NewAdmin, to the best
+// contact master (see Nodes.BestContactNode) when that address is a replica. When no master is reachable the
+// client stays bound to the replica and issues READONLY on each of its connections, so that the read-only
+// queries of the slots served by its master don't get MOVED errors. It must be called before any concurrent
+// use of the Admin.
+func (a *Admin) PreferMaster(ctx context.Context) error {
+	self, err := getSelfNode(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	if self.GetRole() == RedisMasterRole {
+		return nil
+	}
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+
+	masters := nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole })
+	if best, err := masters.BestContactNode(); err == nil {
+		c := a.nodeClient(best.IPPort())
+		err := c.Ping(ctx).Err()
+		if err == nil {
+			logger.V(4).Infof("node %s is a replica, binding the admin client to master %s", self.ID, best.ID)
+			a.rc.Close()
+			a.rc = c
+			return nil
+		}
+		logger.Errorf("unable to reach master %s: %v", best.ID, err)
+		c.Close()
+	}
+
+	logger.Infof("no master reachable, the admin client stays bound to replica %s in READONLY mode", self.ID)
+	opt := a.rc.Options()
+	opt.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		return cn.ReadOnly(ctx).Err()
+	}
+	a.rc.Close()
+	a.rc = redis.NewClient(opt)
+	return nil
+}
+
+// ResetNode runs CLUSTER RESET with the mode (ResetHard or ResetSoft) on the node at addr.
+// Unless force is set, a node still owning slots isn't reset, as it would lose the slots coverage:
+// a SlotsOwnedError listing the slots is returned instead.
+func (a *Admin) ResetNode(ctx context.Context, addr, mode string, force bool) error {
+	if mode != ResetHard && mode != ResetSoft {
+		return fmt.Errorf("invalid CLUSTER RESET mode %q, expected %s or %s", mode, ResetHard, ResetSoft)
+	}
+	c := a.nodeClient(addr)
+	defer c.Close()
+
+	if !force {
+		node, err := getSelfNode(ctx, c)
+		if err != nil {
+			return err
+		}
+		if len(node.Slots) > 0 {
+			return SlotsOwnedError{NodeID: node.ID, Slots: node.Slots}
+		}
+	}
+
+	if err := c.Do(ctx, "CLUSTER", "RESET", mode).Err(); err != nil {
+		return fmt.Errorf("unable to reset node %s: %v", addr, err)
+	}
+	return nil
+}
+
+// ClusterReset runs CLUSTER RESET with the mode, exactly ResetHard or ResetSoft, on the node at addr, to wipe
+// its cluster state before it joins a cluster again. Unlike ResetNode, the node slots aren't checked.
+func (a *Admin) ClusterReset(ctx context.Context, addr, mode string) error {
+	return a.ResetNode(ctx, addr, mode, true)
+}
+
+// selfNodes returns each node reachable from the cluster view, including the ones in handshake, as seen
+// by itself. The address of each returned Node is the one it has been reached at.
+func (a *Admin) selfNodes(ctx context.Context) (Nodes, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	addrs := []string{a.rc.Options().Addr}
+	for _, node := range *nodes {
+		if node.IPPort() != a.rc.Options().Addr {
+			addrs = append(addrs, node.IPPort())
+		}
+	}
+
+	selves := Nodes{}
+	for _, addr := range addrs {
+		c := a.nodeClient(addr)
+		self, err := getSelfNode(ctx, c)
+		c.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get node %s: %v", addr, err)
+		}
+		if self.IP, self.Port, err = net.SplitHostPort(addr); err != nil {
+			return nil, err
+		}
+		selves = append(selves, self)
+	}
+	return selves, nil
+}
+
+// ResolveDuplicateID HARD resets the extra nodes claiming the ID, so they regenerate their own ID.
+// The node kept is the one the cluster knows at that address, the others are reset even if they own
+// slots since those slots belong to the legitimate node. A master storing keys refuses to be reset.
+func (a *Admin) ResolveDuplicateID(ctx context.Context, id string) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	legitimate, err := nodes.GetNodeByID(id)
+	if err != nil {
+		return err
+	}
+	selves, err := a.selfNodes(ctx)
+	if err != nil {
+		return err
+	}
+	claimers, ok := selves.DuplicateIDs()[id]
+	if !ok {
+		return nil
+	}
+	for _, node := range claimers {
+		if node.IPPort() == legitimate.IPPort() {
+			continue
+		}
+		logger.Infof("resetting node %s duplicating the ID %s of node %s", node.IPPort(), id, legitimate.IPPort())
+		if err := a.ResetNode(ctx, node.IPPort(), ResetHard, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,582 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdminFailureReports(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "CLUSTER COUNT-FAILURE-REPORTS" {
+			return 3
+		}
+		return fakeError("ERR unexpected command")
+	})
+	a := newTestAdmin(fake.Addr())
+
+	count, err := a.FailureReports(context.Background(), "abcd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 failure reports, got %d", count)
+	}
+	calls := fake.Calls("CLUSTER COUNT-FAILURE-REPORTS")
+	if len(calls) != 1 || len(calls[0]) != 3 || calls[0][2] != "abcd" {
+		t.Errorf("unexpected CLUSTER COUNT-FAILURE-REPORTS calls: %v", calls)
+	}
+}
+
+func TestAdminAttachSlaveToMaster(t *testing.T) {
+	var view string
+	slave := newFakeRedis(t, clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("S", slave.Addr(), "myself,slave", "M1"),
+		fakeNodeLine("M1", "1.2.3.1:6379", "master", "-", "0-16383"),
+		fakeNodeLine("M2", "1.2.3.2:6379", "master", "-"),
+	)
+	a := newTestAdmin(slave.Addr())
+	node := &Node{ID: "S", IP: slave.IP(), Port: slave.Port()}
+
+	changed, err := a.AttachSlaveToMaster(context.Background(), node, "M1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed || len(slave.Calls("CLUSTER REPLICATE")) != 0 {
+		t.Errorf("expected no CLUSTER REPLICATE when the slave already replicates the master")
+	}
+
+	changed, err = a.AttachSlaveToMaster(context.Background(), node, "M2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calls := slave.Calls("CLUSTER REPLICATE")
+	if !changed || len(calls) != 1 || calls[0][2] != "M2" {
+		t.Errorf("expected CLUSTER REPLICATE M2, got changed: %v, calls: %v", changed, calls)
+	}
+}
+
+// roleHandler returns a handler replying the INFO replication role
+func roleHandler(role string) fakeHandler {
+	return func(args []string) interface{} {
+		if fakeCmdName(args) == "INFO" {
+			return "# Replication\r\nrole:" + role + "\r\nconnected_slaves:0\r\n"
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminSetReadOnlyReplica(t *testing.T) {
+	replica := newFakeRedis(t, roleHandler(RedisSlaveRole))
+	a := newTestAdmin(replica.Addr())
+
+	if err := a.SetReadOnly(context.Background(), replica.Addr(), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replica.Calls("READONLY")) != 1 {
+		t.Errorf("expected READONLY to be issued on the replica")
+	}
+	if err := a.SetReadOnly(context.Background(), replica.Addr(), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replica.Calls("READWRITE")) != 1 {
+		t.Errorf("expected READWRITE to be issued on the replica")
+	}
+	if len(replica.Calls("CONFIG SET")) != 0 {
+		t.Errorf("expected no CONFIG SET on a replica")
+	}
+}
+
+func TestAdminSetReadOnlyMaster(t *testing.T) {
+	minReplicas := "1"
+	role := roleHandler(RedisMasterRole)
+	master := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CONFIG GET":
+			return []interface{}{"min-replicas-to-write", minReplicas}
+		case "CONFIG SET":
+			minReplicas = args[3]
+		}
+		return role(args)
+	})
+	a := newTestAdmin(master.Addr())
+	ctx := context.Background()
+
+	if err := a.SetReadOnly(ctx, master.Addr(), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if minReplicas != readOnlyMinReplicasToWrite {
+		t.Errorf("expected min-replicas-to-write %s once quiesced, got %s", readOnlyMinReplicasToWrite, minReplicas)
+	}
+	// quiescing twice keeps the configured value
+	if err := a.SetReadOnly(ctx, master.Addr(), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := a.SetReadOnly(ctx, master.Addr(), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if minReplicas != "1" {
+		t.Errorf("expected the configured min-replicas-to-write 1 to be restored, got %s", minReplicas)
+	}
+	if err := a.SetReadOnly(ctx, master.Addr(), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := master.Calls("CONFIG SET"); len(calls) != 3 {
+		t.Errorf("expected no CONFIG SET restoring a master not quiesced, got %v", calls)
+	}
+}
+
+// forgetHandler returns a handler replying the views before and after a CLUSTER FORGET is received, or
+// a CLUSTER REPLICATE attaching the node to a remaining master
+func forgetHandler(before, after *string) fakeHandler {
+	forgotten := false
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER FORGET", "CLUSTER REPLICATE":
+			forgotten = true
+		case "CLUSTER NODES":
+			if forgotten {
+				return *after
+			}
+			return *before
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminRemoveNode(t *testing.T) {
+	var before, after, rejoined string
+	m1 := newFakeRedis(t, forgetHandler(&before, &after))
+	m2 := newFakeRedis(t, forgetHandler(&before, &after))
+	removed := newFakeRedis(t, nil)
+	after = fakeClusterNodes(
+		fakeNodeLine("M1", m1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("M2", m2.Addr(), "master", "-", "8192-16383"),
+	)
+	before = after + fakeNodeLine("Z", removed.Addr(), "master", "-") + "\n"
+	a := newTestAdmin(m1.Addr())
+
+	if err := a.RemoveNode(context.Background(), "Z", 20*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, m := range []*fakeRedis{m1, m2} {
+		if calls := m.Calls("CLUSTER FORGET"); len(calls) != 1 || calls[0][2] != "Z" {
+			t.Errorf("expected CLUSTER FORGET Z on %s, got %v", m.Addr(), calls)
+		}
+	}
+	if calls := removed.Calls("CLUSTER FORGET"); len(calls) != 2 {
+		t.Errorf("expected the removed node to forget its 2 peers, got %v", calls)
+	}
+
+	// Z is gossiped again to M2 before the end of the confirmation
+	rejoined = before
+	m2.SetHandler(forgetHandler(&before, &rejoined))
+	m1.SetHandler(forgetHandler(&before, &after))
+	if err := a.RemoveNode(context.Background(), "Z", 20*time.Millisecond); !IsNodeRejoinedError(err) {
+		t.Errorf("expected a NodeRejoinedError, got %v", err)
+	}
+}
+
+func TestAdminRemoveNodeWithReplicas(t *testing.T) {
+	var before, after string
+	m1 := newFakeRedis(t, forgetHandler(&before, &after))
+	m2 := newFakeRedis(t, forgetHandler(&before, &after))
+	removed := newFakeRedis(t, nil)
+	// R refuses to forget Z as long as it replicates it
+	master := "Z"
+	replica := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER REPLICATE":
+			master = args[2]
+		case "CLUSTER FORGET":
+			if args[2] == master {
+				return fakeError("ERR Can't forget my master!")
+			}
+		case "CLUSTER NODES":
+			if master == "Z" {
+				return before
+			}
+			return after
+		}
+		return fakeOK
+	})
+	after = fakeClusterNodes(
+		fakeNodeLine("M1", m1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("M2", m2.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("F", "127.0.0.1:1", "master,fail", "-"),
+		fakeNodeLine("R", replica.Addr(), "slave", "M1"),
+	)
+	before = fakeClusterNodes(
+		fakeNodeLine("M1", m1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("M2", m2.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("F", "127.0.0.1:1", "master,fail", "-"),
+		fakeNodeLine("Z", removed.Addr(), "master", "-"),
+		fakeNodeLine("R", replica.Addr(), "slave", "Z"),
+	)
+	a := newTestAdmin(m1.Addr())
+
+	if err := a.RemoveNode(context.Background(), "Z", 20*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := replica.Calls("CLUSTER REPLICATE"); len(calls) != 1 || calls[0][2] != "M1" {
+		t.Errorf("expected the replica of Z to be reattached to M1, got %v", calls)
+	}
+	for _, fake := range []*fakeRedis{m1, m2, replica} {
+		if calls := fake.Calls("CLUSTER FORGET"); len(calls) != 1 || calls[0][2] != "Z" {
+			t.Errorf("expected CLUSTER FORGET Z on %s, got %v", fake.Addr(), calls)
+		}
+	}
+	if calls := removed.Calls("CLUSTER FORGET"); len(calls) != 3 {
+		t.Errorf("expected the removed node to forget its 3 reachable peers, got %v", calls)
+	}
+
+	// M2 refuses to forget Z, M1 still forgets it
+	m1.SetHandler(forgetHandler(&before, &after))
+	m2.SetHandler(func(args []string) interface{} {
+		if fakeCmdName(args) == "CLUSTER FORGET" {
+			return fakeError("ERR Unknown node Z")
+		}
+		return fakeOK
+	})
+	master = "M1"
+	before = after + fakeNodeLine("Z", removed.Addr(), "master", "-") + "\n"
+	err := a.RemoveNode(context.Background(), "Z", 20*time.Millisecond)
+	nodesErr, ok := err.(NodesError)
+	if !ok || len(nodesErr.Errs) != 1 || nodesErr.Errs[m2.Addr()] == nil {
+		t.Fatalf("expected a NodesError for M2 only, got %v", err)
+	}
+	if calls := m1.Calls("CLUSTER FORGET"); len(calls) != 2 {
+		t.Errorf("expected M1 to forget Z despite the failure of M2, got %v", calls)
+	}
+}
+
+// selfHandler returns a handler replying CLUSTER MYID and CLUSTER NODES
+func selfHandler(id string, view *string) fakeHandler {
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER MYID":
+			return id
+		case "CLUSTER NODES":
+			return *view
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminResetNode(t *testing.T) {
+	var view string
+	fake := newFakeRedis(t, selfHandler("A", &view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-100"),
+		fakeNodeLine("B", "1.2.3.2:6379", "master", "-", "101-16383"),
+	)
+	a := newTestAdmin(fake.Addr())
+
+	err := a.ResetNode(context.Background(), fake.Addr(), ResetHard, false)
+	if !IsSlotsOwnedError(err) {
+		t.Fatalf("expected a SlotsOwnedError, got %v", err)
+	}
+	if e := err.(SlotsOwnedError); e.NodeID != "A" || len(e.Slots) != 101 {
+		t.Errorf("unexpected error values: %v", e)
+	}
+	if len(fake.Calls("CLUSTER RESET")) != 0 {
+		t.Errorf("expected no CLUSTER RESET on a node owning slots")
+	}
+
+	if err := a.ResetNode(context.Background(), fake.Addr(), ResetHard, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := fake.Calls("CLUSTER RESET"); len(calls) != 1 || calls[0][2] != ResetHard {
+		t.Errorf("expected a forced CLUSTER RESET HARD, got %v", calls)
+	}
+
+	if err := a.ResetNode(context.Background(), fake.Addr(), "hard", true); err == nil {
+		t.Errorf("expected an error for an invalid mode")
+	}
+}
+
+func TestAdminClusterReset(t *testing.T) {
+	fake := newFakeRedis(t, nil)
+	a := newTestAdmin(fake.Addr())
+	ctx := context.Background()
+
+	for _, mode := range []string{"soft", "HARD ", ""} {
+		if err := a.ClusterReset(ctx, fake.Addr(), mode); err == nil {
+			t.Errorf("expected an error for mode %q", mode)
+		}
+	}
+	if calls := fake.Calls("CLUSTER RESET"); len(calls) != 0 {
+		t.Fatalf("expected nothing to be sent for an invalid mode, got %v", calls)
+	}
+
+	for _, mode := range []string{ResetSoft, ResetHard} {
+		if err := a.ClusterReset(ctx, fake.Addr(), mode); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls := fake.Calls("CLUSTER RESET"); len(calls) != 2 || calls[0][2] != ResetSoft || calls[1][2] != ResetHard {
+		t.Errorf("expected CLUSTER RESET SOFT then HARD, got %v", calls)
+	}
+}
+
+func TestAdminResolveDuplicateID(t *testing.T) {
+	var view, cloneView string
+	legitimate := newFakeRedis(t, selfHandler("A", &view))
+	clone := newFakeRedis(t, selfHandler("A", &cloneView))
+	other := newFakeRedis(t, selfHandler("B", &view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", legitimate.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", other.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("H", clone.Addr(), "handshake", "-"),
+	)
+	// the clone data directory still describes the legitimate node
+	cloneView = view
+	a := newTestAdmin(legitimate.Addr())
+
+	if err := a.ResolveDuplicateID(context.Background(), "A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := clone.Calls("CLUSTER RESET"); len(calls) != 1 || calls[0][2] != ResetHard {
+		t.Errorf("expected the clone to be HARD reset, got %v", calls)
+	}
+	if len(legitimate.Calls("CLUSTER RESET"))+len(other.Calls("CLUSTER RESET")) != 0 {
+		t.Errorf("expected only the clone to be reset")
+	}
+
+	if err := a.ResolveDuplicateID(context.Background(), "B"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(other.Calls("CLUSTER RESET")) != 0 {
+		t.Errorf("expected no reset for an ID claimed once")
+	}
+}
+
+func TestAdminReloadState(t *testing.T) {
+	var slots []interface{}
+	handler := func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER SLOTS":
+			return slots
+		case "GET":
+			return "value"
+		}
+		return fakeOK
+	}
+	m1 := newFakeRedis(t, handler)
+	m2 := newFakeRedis(t, handler)
+	slots = []interface{}{fakeSlotRange(0, 16383, m1.Addr())}
+	a := newTestAdmin(m1.Addr())
+	ctx := context.Background()
+
+	if err := a.rcc.Get(ctx, "key").Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// all the slots moved to m2
+	slots = []interface{}{fakeSlotRange(0, 16383, m2.Addr())}
+	reloads := len(m1.Calls("CLUSTER SLOTS"))
+	if err := a.ReloadState(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m1.Calls("CLUSTER SLOTS"))+len(m2.Calls("CLUSTER SLOTS")) <= reloads {
+		t.Errorf("expected the cluster client state to be reloaded")
+	}
+	if err := a.rcc.Get(ctx, "key").Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m1.Calls("GET")) != 1 || len(m2.Calls("GET")) != 1 {
+		t.Errorf("expected the GET to be routed to the new owner, got %d on m1 and %d on m2", len(m1.Calls("GET")), len(m2.Calls("GET")))
+	}
+}
+
+func TestAdminPreferMaster(t *testing.T) {
+	var view string
+	replicaHandler := func(id string) fakeHandler {
+		return func(args []string) interface{} {
+			switch fakeCmdName(args) {
+			case "CLUSTER MYID":
+				return id
+			case "CLUSTER NODES":
+				return view
+			case "CLUSTER INFO":
+				return "cluster_state:ok\r\n"
+			}
+			return fakeOK
+		}
+	}
+	replica := newFakeRedis(t, replicaHandler("R"))
+	master := newFakeRedis(t, replicaHandler("M"))
+	view = fakeClusterNodes(
+		fakeNodeLine("R", replica.Addr(), "myself,slave", "M"),
+		fakeNodeLine("M", master.Addr(), "master", "-", "0-16383"),
+	)
+	a := newTestAdmin(replica.Addr())
+
+	if err := a.PreferMaster(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := a.GetClusterInfos(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(master.Calls("CLUSTER INFO")) != 1 || len(replica.Calls("CLUSTER INFO")) != 0 {
+		t.Errorf("expected the admin client to be bound to the master")
+	}
+}
+
+func TestAdminPreferMasterUnreachable(t *testing.T) {
+	var view string
+	replica := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER MYID":
+			return "R"
+		case "CLUSTER NODES":
+			return view
+		case "CLUSTER INFO":
+			return "cluster_state:fail\r\n"
+		}
+		return fakeOK
+	})
+	view = fakeClusterNodes(
+		fakeNodeLine("R", replica.Addr(), "myself,slave", "M"),
+		fakeNodeLine("M", "127.0.0.1:1", "master", "-", "0-16383"),
+	)
+	a := newTestAdmin(replica.Addr())
+
+	if err := a.PreferMaster(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := a.GetClusterInfos(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replica.Calls("READONLY")) == 0 || len(replica.Calls("CLUSTER INFO")) != 1 {
+		t.Errorf("expected the admin client to stay bound to the replica in READONLY mode")
+	}
+}
+
+func TestAdminForgetNode(t *testing.T) {
+	var view string
+	m1 := newFakeRedis(t, clusterNodesHandler(&view))
+	m2 := newFakeRedis(t, clusterNodesHandler(&view))
+	replica := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "CLUSTER FORGET" {
+			return fakeError("ERR Can't forget my master!")
+		}
+		return fakeOK
+	})
+	view = fakeClusterNodes(
+		fakeNodeLine("M1", m1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("M2", m2.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("X", "10.0.0.9:6379", "master,fail", "-"),
+		fakeNodeLine("R", replica.Addr(), "slave", "X"),
+	)
+	a := newTestAdmin(m1.Addr())
+
+	if err := a.ForgetNode(context.Background(), "X"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := replica.Calls("CLUSTER FORGET"); len(calls) != 0 {
+		t.Errorf("expected the replica of X to be skipped, got %v", calls)
+	}
+	for _, fake := range []*fakeRedis{m1, m2} {
+		if calls := fake.Calls("CLUSTER FORGET"); len(calls) != 1 || calls[0][2] != "X" {
+			t.Errorf("expected CLUSTER FORGET X, got %v", calls)
+		}
+	}
+
+	if err := a.ForgetNode(context.Background(), "M2"); !IsSlotsOwnedError(err) {
+		t.Errorf("expected a SlotsOwnedError for a master owning slots, got %v", err)
+	}
+	if len(m1.Calls("CLUSTER FORGET")) != 1 {
+		t.Errorf("expected no CLUSTER FORGET for a master owning slots")
+	}
+}
+
+func TestAdminSetSlots(t *testing.T) {
+	fake := newFakeRedis(t, nil)
+	a := newTestAdmin(fake.Addr())
+	ctx := context.Background()
+
+	if err := a.SetSlots(ctx, fake.Addr(), []Slot{1, 2, 16384}); err == nil {
+		t.Errorf("expected an error for a slot out of range")
+	}
+	if err := a.SetSlots(ctx, fake.Addr(), []Slot{1, 2, 1}); err == nil {
+		t.Errorf("expected an error for a duplicated slot")
+	}
+	if len(fake.Calls("CLUSTER ADDSLOTS")) != 0 {
+		t.Fatalf("expected nothing to be sent for invalid slots")
+	}
+
+	if err := a.SetSlots(ctx, fake.Addr(), BuildSlotSlice(0, 2499)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calls := fake.Calls("CLUSTER ADDSLOTS")
+	if len(calls) != 3 || len(calls[0]) != 2+slotsBatchSize || len(calls[2]) != 2+500 || calls[2][len(calls[2])-1] != "2499" {
+		t.Errorf("expected the slots to be sent in 3 batches, got %d", len(calls))
+	}
+}
+
+func TestAdminDelSlots(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		// the second batch contains slot 1500, not assigned to the node
+		if fakeCmdName(args) == "CLUSTER DELSLOTS" && args[2] == "1000" {
+			return fakeError("ERR Slot 1500 is already unassigned")
+		}
+		return fakeOK
+	})
+	a := newTestAdmin(fake.Addr())
+	ctx := context.Background()
+
+	if err := a.DelSlots(ctx, fake.Addr(), []Slot{16384}); err == nil || IsDelSlotsError(err) {
+		t.Errorf("expected a validation error for a slot out of range, got %v", err)
+	}
+
+	err := a.DelSlots(ctx, fake.Addr(), BuildSlotSlice(0, 2499))
+	if !IsDelSlotsError(err) {
+		t.Fatalf("expected a DelSlotsError, got %v", err)
+	}
+	delErr := err.(DelSlotsError)
+	if delErr.Removed != slotsBatchSize || len(delErr.Remaining) != 1500 || delErr.Remaining[0] != 1000 {
+		t.Errorf("expected %d slots removed and 1500 remaining from 1000, got %d and %d", slotsBatchSize, delErr.Removed, len(delErr.Remaining))
+	}
+	if calls := fake.Calls("CLUSTER DELSLOTS"); len(calls) != 2 {
+		t.Errorf("expected to stop after the failing batch, got %d batches", len(calls))
+	}
+
+	if err := a.DelSlots(ctx, fake.Addr(), delErr.Remaining[slotsBatchSize:]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAdminACLUser(t *testing.T) {
+	fake := newFakeRedis(t, nil)
+	a := NewAdminWithOptions([]string{fake.Addr()}, AdminOptions{Username: "operator", Password: "secret"}).(*Admin)
+	defer a.CloseClient()
+	defer a.CloseClusterClient()
+
+	if err := a.rc.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := fake.Calls("AUTH"); len(calls) != 1 || len(calls[0]) != 3 || calls[0][1] != "operator" || calls[0][2] != "secret" {
+		t.Errorf("expected AUTH operator secret, got %v", calls)
+	}
+	cc := NewClusterClientWithOptions([]string{fake.Addr()}, a.opts)
+	defer cc.Close()
+	if user := cc.Options().Username; user != "operator" {
+		t.Errorf("expected the cluster client to authenticate as operator, got %q", user)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// topologyConflicts returns the reasons why the desired topology can't be reached from the current one
+func topologyConflicts(current, desired Nodes, diff NodesDiff) []string {
+	conflicts := []string{}
+	for _, change := range diff.Changed {
+		if change.Before.IPPort() != change.After.IPPort() {
+			conflicts = append(conflicts, fmt.Sprintf("node %s address changed from %s to %s", change.ID, change.Before.IPPort(), change.After.IPPort()))
+		}
+		// a slave only becomes a master through a failover, which Apply doesn't run
+		if before, after := change.Before.GetRole(), change.After.GetRole(); before != after {
+			conflicts = append(conflicts, fmt.Sprintf("node %s role changed from %s to %s", change.ID, before, after))
+		}
+	}
+	owners := make(map[Slot]string)
+	sorted := append(Nodes{}, desired...)
+	sort.Sort(sorted)
+	for _, node := range sorted {
+		if node.GetRole() == RedisSlaveRole {
+			if len(node.Slots) > 0 {
+				conflicts = append(conflicts, fmt.Sprintf("slave %s owns slots", node.ID))
+			}
+			if master, err := desired.GetNodeByID(node.MasterReferent); err != nil || master.GetRole() != RedisMasterRole {
+				conflicts = append(conflicts, fmt.Sprintf("slave %s replicates %s which isn't a desired master", node.ID, node.MasterReferent))
+			}
+		}
+		for _, slot := range node.Slots {
+			if owner, ok := owners[slot]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("slot %s owned by both %s and %s", slot, owner, node.ID))
+			}
+			owners[slot] = node.ID
+		}
+	}
+	uncovered := []Slot{}
+	for slot := range current.slotOwners() {
+		if _, ok := owners[slot]; !ok {
+			uncovered = append(uncovered, slot)
+		}
+	}
+	if len(uncovered) > 0 {
+		conflicts = append(conflicts, fmt.Sprintf("slots %s would be left without owner", formatSlots(uncovered)))
+	}
+	return conflicts
+}
+
+// Apply converges the cluster to the desired topology and returns the log of the actions taken. The
+// desired nodes are compared with the current ones (see Nodes.Diff), then the new nodes are met and
+// waited for up to AdminOptions.JoinTimeout, the slots moved to their desired owner, the slaves attached
+// to their desired master, and finally the nodes absent from the desired topology are removed. It is a
+// no-op when the cluster already matches. Nothing is changed when the desired topology can't be reached
+// safely, ex: a node changing role, a TopologyConflictError is returned.
+func (a *Admin) Apply(ctx context.Context, desired Nodes) ([]string, error) {
+	current, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	actions := []string{}
+	diff := current.Diff(desired)
+	if diff.IsEmpty() {
+		return actions, nil
+	}
+	if conflicts := topologyConflicts(*current, desired, diff); len(conflicts) > 0 {
+		return actions, TopologyConflictError{Conflicts: conflicts}
+	}
+	logAction := func(format string, args ...interface{}) {
+		action := fmt.Sprintf(format, args...)
+		logger.Infof("apply: %s", action)
+		actions = append(actions, action)
+	}
+
+	for _, node := range diff.Added {
+		host, port := node.HostPortArgs()
+		if err := a.rc.ClusterMeet(ctx, host, port).Err(); err != nil {
+			return actions, fmt.Errorf("unable to meet node %s: %v", node.ID, err)
+		}
+		logAction("meet %s at %s", node.ID, node.IPPort())
+	}
+	for _, node := range diff.Added {
+		if _, err := a.waitNodeJoined(ctx, node.ID, byID(node.ID), a.nodeJoinTimeout()); err != nil {
+			return actions, err
+		}
+	}
+
+	currentOwners := current.slotOwners()
+	migrations := []SlotMigration{}
+	for slot, owner := range desired.slotOwners() {
+		if from, ok := currentOwners[slot]; ok && from != owner {
+			migrations = append(migrations, SlotMigration{Slot: slot, From: from, To: owner})
+		} else if !ok {
+			migrations = append(migrations, SlotMigration{Slot: slot, To: owner})
+		}
+	}
+	for _, migration := range OrderMigrations(migrations) {
+		dst, _ := desired.GetNodeByID(migration.To)
+		if migration.From == "" {
+			c := a.nodeClient(dst.IPPort())
+			err := c.ClusterAddSlots(ctx, int(migration.Slot)).Err()
+			c.Close()
+			if err != nil {
+				return actions, fmt.Errorf("unable to add slot %s to node %s: %v", migration.Slot, dst.ID, err)
+			}
+			logAction("add slot %s to %s", migration.Slot, dst.ID)
+			continue
+		}
+		src, _ := current.GetNodeByID(migration.From)
+		if err := a.MigrateSlot(ctx, migration.Slot, src, dst, migrateTimeout); err != nil {
+			return actions, err
+		}
+		logAction("migrate slot %s from %s to %s", migration.Slot, src.ID, dst.ID)
+	}
+
+	slaves := desired.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisSlaveRole })
+	sort.Sort(slaves)
+	for _, slave := range slaves {
+		if node, err := current.GetNodeByID(slave.ID); err == nil && node.MasterReferent == slave.MasterReferent {
+			continue
+		}
+		if _, err := a.AttachSlaveToMaster(ctx, slave, slave.MasterReferent); err != nil {
+			return actions, err
+		}
+		logAction("replicate %s to %s", slave.ID, slave.MasterReferent)
+	}
+
+	for _, node := range diff.Removed {
+		if err := a.RemoveNode(ctx, node.ID, 0); err != nil {
+			return actions, err
+		}
+		logAction("forget %s", node.ID)
+	}
+	return actions, nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminApply(t *testing.T) {
+	var before, after string
+	fakes := map[string]*fakeRedis{}
+	for _, id := range []string{"A", "B", "C", "N"} {
+		fakes[id] = newFakeRedis(t, meetHandler(id, &before, &after, 16384))
+	}
+	before = fakeClusterNodes(
+		fakeNodeLine("A", fakes["A"].Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", fakes["B"].Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", fakes["C"].Addr(), "slave", "A"),
+	)
+	after = before + fakeNodeLine("N", fakes["N"].Addr(), "master", "-") + "\n"
+	a := newTestAdmin(fakes["A"].Addr())
+
+	node := func(id, master string, slots ...Slot) *Node {
+		n := &Node{ID: id, IP: fakes[id].IP(), Port: fakes[id].Port(), Role: RedisMasterRole, Slots: slots}
+		if master != "" {
+			n.Role, n.MasterReferent = RedisSlaveRole, master
+		}
+		return n
+	}
+	// slot 8191 moves to B, C replicates B, and N joins as a replica of A
+	desired := Nodes{
+		node("A", "", BuildSlotSlice(0, 8190)...),
+		node("B", "", BuildSlotSlice(8191, 16383)...),
+		node("C", "B"),
+		node("N", "A"),
+	}
+
+	actions, err := a.Apply(context.Background(), desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"meet N at " + fakes["N"].Addr(),
+		"migrate slot 8191 from A to B",
+		"replicate C to B",
+		"replicate N to A",
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("Apply() = %v, want %v", actions, want)
+	}
+	if calls := fakes["C"].Calls("CLUSTER REPLICATE"); len(calls) != 1 || calls[0][2] != "B" {
+		t.Errorf("expected C to replicate B, got %v", calls)
+	}
+
+	// once converged, applying again is a no-op
+	converged := newFakeRedis(t, clusterNodesHandler(&after))
+	after = fakeClusterNodes(
+		fakeNodeLine("A", converged.Addr(), "myself,master", "-", "0-8190"),
+		fakeNodeLine("B", fakes["B"].Addr(), "master", "-", "8191-16383"),
+		fakeNodeLine("C", fakes["C"].Addr(), "slave", "B"),
+		fakeNodeLine("N", fakes["N"].Addr(), "slave", "A"),
+	)
+	desired[0].IP, desired[0].Port = converged.IP(), converged.Port()
+	a = newTestAdmin(converged.Addr())
+	if actions, err := a.Apply(context.Background(), desired); err != nil || len(actions) != 0 {
+		t.Errorf("expected no action on a converged cluster, got %v, %v", actions, err)
+	}
+	if calls := len(converged.Calls("CLUSTER NODES")); calls != 1 {
+		t.Errorf("expected only the topology to be read, got %d calls", calls)
+	}
+}
+
+func TestAdminApplyRoleChange(t *testing.T) {
+	var view string
+	fake := newFakeRedis(t, clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", "10.0.0.2:6379", "slave", "A"),
+	)
+	a := newTestAdmin(fake.Addr())
+
+	// B would have to be promoted to receive half of the slots of A
+	desired := Nodes{
+		{ID: "A", IP: fake.IP(), Port: fake.Port(), Role: RedisMasterRole, Slots: BuildSlotSlice(0, 8191)},
+		{ID: "B", IP: "10.0.0.2", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(8192, 16383)},
+	}
+	actions, err := a.Apply(context.Background(), desired)
+	if !IsTopologyConflictError(err) || len(actions) != 0 {
+		t.Fatalf("expected a TopologyConflictError before any action, got %v, %v", actions, err)
+	}
+	if want := []string{"node B role changed from slave to master"}; !reflect.DeepEqual(err.(TopologyConflictError).Conflicts, want) {
+		t.Errorf("Conflicts = %v, want %v", err.(TopologyConflictError).Conflicts, want)
+	}
+	if calls := fake.Calls("CLUSTER SETSLOT"); len(calls) != 0 {
+		t.Errorf("expected no migration, got %v", calls)
+	}
+}
+
+func TestAdminApplyJoinTimeout(t *testing.T) {
+	var view string
+	seed := newFakeRedis(t, meetHandler("A", &view, &view, 16384))
+	// the new node never shows up in the cluster view
+	view = fakeClusterNodes(fakeNodeLine("A", seed.Addr(), "myself,master", "-", "0-16383"))
+	a := NewAdminWithOptions([]string{seed.Addr()}, AdminOptions{JoinTimeout: 200 * time.Millisecond}).(*Admin)
+
+	desired := Nodes{
+		{ID: "A", IP: seed.IP(), Port: seed.Port(), Role: RedisMasterRole, Slots: BuildSlotSlice(0, 16383)},
+		{ID: "N", IP: "127.0.0.1", Port: "7000", Role: RedisSlaveRole, MasterReferent: "A"},
+	}
+	if _, err := a.Apply(context.Background(), desired); err == nil || !strings.Contains(err.Error(), "didn't join the cluster after 200ms") {
+		t.Errorf("expected the join to time out after the JoinTimeout option, got %v", err)
+	}
+}
+
+func TestAdminApplyConflicts(t *testing.T) {
+	var view string
+	fake := newFakeRedis(t, clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "8192-16383"),
+	)
+	a := newTestAdmin(fake.Addr())
+
+	desired := Nodes{
+		{ID: "A", IP: fake.IP(), Port: fake.Port(), Role: RedisMasterRole, Slots: BuildSlotSlice(0, 8191)},
+		{ID: "B", IP: "10.0.0.2", Port: "6379", Role: RedisSlaveRole, MasterReferent: "Z"},
+	}
+	_, err := a.Apply(context.Background(), desired)
+	if !IsTopologyConflictError(err) {
+		t.Fatalf("expected a TopologyConflictError, got %v", err)
+	}
+	want := []string{
+		"node B role changed from master to slave",
+		"slave B replicates Z which isn't a desired master",
+		"slots 8192-16383 would be left without owner",
+	}
+	if got := err.(TopologyConflictError).Conflicts; !reflect.DeepEqual(got, want) {
+		t.Errorf("Conflicts = %v, want %v", got, want)
+	}
+	if calls := len(fake.Calls("CLUSTER NODES")); calls != 1 {
+		t.Errorf("expected no change on conflicts, got %d calls", calls)
+	}
+}
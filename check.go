@@ -0,0 +1,152 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// checkBalanceThreshold percentage of the average slots count a master can deviate from before the distribution
+// is reported unbalanced, the default threshold of redis-cli --cluster rebalance
+const checkBalanceThreshold = 2
+
+// checkSlots renders the slots in the redis-cli --cluster check form, ex: [0-5460],[5462]
+func checkSlots(slots []Slot) string {
+	ranges := encodeSlotRanges(slots)
+	for i, r := range ranges {
+		ranges[i] = "[" + r + "]"
+	}
+	return strings.Join(ranges, ",")
+}
+
+// checkNode renders the node ID and address, followed by its fail status if any
+func checkNode(node *Node) string {
+	if len(node.FailStatus) == 0 {
+		return node.ID + " " + node.IPPort()
+	}
+	return fmt.Sprintf("%s %s (%s)", node.ID, node.IPPort(), strings.Join(node.FailStatus, ","))
+}
+
+// sortedSlotMap returns the slots of the map in ascending order
+func sortedSlotMap(slots map[Slot]string) []Slot {
+	sorted := make([]Slot, 0, len(slots))
+	for slot := range slots {
+		sorted = append(sorted, slot)
+	}
+	sort.Sort(SlotSlice(sorted))
+	return sorted
+}
+
+// renderCheck renders the nodes in the redis-cli --cluster check form: the masters ordered by ID with their
+// slots and their replicas indented, then the open slots, the slots coverage and the slots distribution checks
+func renderCheck(nodes Nodes, hashMaxSlots Slot) string {
+	var b strings.Builder
+	sorted := make(Nodes, len(nodes))
+	copy(sorted, nodes)
+	sort.Sort(sorted)
+	groups := sorted.GroupByMaster()
+
+	masters := sorted.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole })
+	for _, master := range masters {
+		fmt.Fprintf(&b, "M: %s\n", checkNode(master))
+		fmt.Fprintf(&b, "   slots:%s (%d slots) master\n", checkSlots(master.Slots), len(master.Slots))
+		fmt.Fprintf(&b, "   %d additional replica(s)\n", len(groups[master.ID]))
+		for _, replica := range groups[master.ID] {
+			fmt.Fprintf(&b, "   S: %s\n", checkNode(replica))
+			fmt.Fprintf(&b, "      replicates %s\n", master.ID)
+		}
+	}
+	for _, orphan := range sorted.OrphanedReplicas() {
+		if _, err := masters.GetNodeByID(orphan.MasterReferent); err == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "S: %s\n", checkNode(orphan))
+		fmt.Fprintf(&b, "   replicates %s\n", orphan.MasterReferent)
+	}
+
+	b.WriteString(">>> Check for open slots...\n")
+	open := map[Slot]string{}
+	for _, node := range sorted {
+		for _, state := range []string{"importing", "migrating"} {
+			slots := node.ImportingSlots
+			if state == "migrating" {
+				slots = node.MigratingSlots
+			}
+			if len(slots) == 0 {
+				continue
+			}
+			stateSlots := []string{}
+			for _, slot := range sortedSlotMap(slots) {
+				stateSlots = append(stateSlots, slot.String())
+				open[slot] = node.ID
+			}
+			fmt.Fprintf(&b, "[WARNING] Node %s has slots in %s state %s.\n", node.IPPort(), state, strings.Join(stateSlots, ","))
+		}
+	}
+	if len(open) > 0 {
+		openSlots := []string{}
+		for _, slot := range sortedSlotMap(open) {
+			openSlots = append(openSlots, slot.String())
+		}
+		fmt.Fprintf(&b, "[WARNING] The following slots are open: %s.\n", strings.Join(openSlots, ","))
+	}
+
+	b.WriteString(">>> Check slots coverage...\n")
+	total := int(hashMaxSlots) + 1
+	owners := sorted.slotOwners()
+	if len(owners) == total {
+		fmt.Fprintf(&b, "[OK] All %d slots covered.\n", total)
+	} else {
+		uncovered := []Slot{}
+		for slot := Slot(0); slot <= hashMaxSlots; slot++ {
+			if _, ok := owners[slot]; !ok {
+				uncovered = append(uncovered, slot)
+			}
+		}
+		fmt.Fprintf(&b, "[ERR] Not all %d slots are covered by nodes, uncovered slots: %s.\n", total, checkSlots(uncovered))
+	}
+
+	b.WriteString(">>> Check slots distribution...\n")
+	balanced := true
+	if len(masters) > 0 {
+		average := float64(total) / float64(len(masters))
+		for _, master := range masters {
+			if math.Abs(float64(len(master.Slots))-average)/average*100 > checkBalanceThreshold {
+				fmt.Fprintf(&b, "[WARNING] Node %s has %d slots, expected about %.0f.\n", master.IPPort(), len(master.Slots), average)
+				balanced = false
+			}
+		}
+	}
+	if balanced {
+		fmt.Fprintf(&b, "[OK] Slots evenly distributed among %d masters.\n", len(masters))
+	}
+	return b.String()
+}
+
+// Check returns the cluster topology, as seen by the single client, in the redis-cli --cluster check form,
+// along with warnings about open slots, uncovered slots and unbalanced slots distribution. The output is
+// deterministic.
+func (a *Admin) Check(ctx context.Context) (string, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return "", err
+	}
+	return renderCheck(*nodes, a.hashMaxSlots), nil
+}
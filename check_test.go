@@ -0,0 +1,83 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+func TestAdminCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		nodes  string
+		golden string
+	}{
+		{
+			name: "healthy",
+			nodes: fakeClusterNodes(
+				fakeNodeLine("C", "10.0.0.3:6379", "master", "-", "10923-16383"),
+				fakeNodeLine("A", "10.0.0.1:6379", "myself,master", "-", "0-5460"),
+				fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "5461-10922"),
+				fakeNodeLine("F", "10.0.0.6:6379", "slave", "C"),
+				fakeNodeLine("D", "10.0.0.4:6379", "slave", "A"),
+				fakeNodeLine("E", "10.0.0.5:6379", "slave", "B"),
+			),
+			golden: "check_healthy.golden",
+		},
+		{
+			name: "degraded",
+			nodes: fakeClusterNodes(
+				fakeNodeLine("A", "10.0.0.1:6379", "myself,master", "-", "0-5460", "6000", "[5461-<-B]"),
+				fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "5461-5999", "6001-10922", "[5461->-A]"),
+				fakeNodeLine("C", "10.0.0.3:6379", "master,fail", "-"),
+				fakeNodeLine("D", "10.0.0.4:6379", "slave", "A"),
+				fakeNodeLine("E", "10.0.0.5:6379", "slave", "Z"),
+			),
+			golden: "check_degraded.golden",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := tt.nodes
+			fake := newFakeRedis(t, clusterNodesHandler(&nodes))
+			a := newTestAdmin(fake.Addr())
+
+			got, err := a.Check(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			path := filepath.Join("testdata", tt.golden)
+			if *updateGolden {
+				if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatalf("unable to update %s: %v", path, err)
+				}
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unable to read %s: %v", path, err)
+			}
+			if got != string(want) {
+				t.Errorf("Check() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kubernetes-app/redisutil/utils"
+)
+
+// ClientInfo represents a client connection, as reported by CLIENT LIST
+type ClientInfo struct {
+	ID    int64
+	Addr  string
+	Name  string
+	Flags string
+	Cmd   string
+	// Class output buffer class of the client: normal, replica or pubsub
+	Class string
+	// OutputMemory memory used by the client output buffer (omem)
+	OutputMemory int64
+	// HardLimit output buffer hard limit of the client class, 0 if unlimited
+	HardLimit int64
+}
+
+// DecodeClientList decodes the CLIENT LIST output, ex: id=3 addr=10.0.0.1:5018 name= flags=N omem=0 cmd=get
+func DecodeClientList(raw string) ([]ClientInfo, error) {
+	clients := []ClientInfo{}
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := map[string]string{}
+		for _, kv := range strings.Fields(line) {
+			if values := strings.SplitN(kv, "=", 2); len(values) == 2 {
+				fields[values[0]] = values[1]
+			}
+		}
+		client := ClientInfo{Addr: fields["addr"], Name: fields["name"], Flags: fields["flags"], Cmd: fields["cmd"]}
+		var err error
+		if client.ID, err = strconv.ParseInt(fields["id"], 10, 64); err != nil {
+			return nil, fmt.Errorf("wrong format for CLIENT LIST id: %v", err)
+		}
+		if client.OutputMemory, err = strconv.ParseInt(fields["omem"], 10, 64); err != nil {
+			return nil, fmt.Errorf("wrong format for CLIENT LIST omem: %v", err)
+		}
+		switch {
+		case strings.Contains(client.Flags, "S"):
+			client.Class = "replica"
+		case strings.Contains(client.Flags, "P"):
+			client.Class = "pubsub"
+		default:
+			client.Class = "normal"
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// decodeOutputBufferHardLimits decodes the hard limit of each class from the client-output-buffer-limit
+// config, ex: normal 0 0 0 slave 268435456 67108864 60 pubsub 33554432 8388608 60
+func decodeOutputBufferHardLimits(config string) (map[string]int64, error) {
+	fields := strings.Fields(config)
+	if len(fields)%4 != 0 {
+		return nil, fmt.Errorf("wrong format for client-output-buffer-limit: %s", config)
+	}
+	limits := map[string]int64{}
+	for i := 0; i < len(fields); i += 4 {
+		raw, err := utils.ParseRedisMemConf(fields[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("wrong format for client-output-buffer-limit %s: %v", fields[i], err)
+		}
+		hard, _ := strconv.ParseInt(raw, 10, 64)
+		class := fields[i]
+		if class == "slave" {
+			class = "replica"
+		}
+		limits[class] = hard
+	}
+	return limits, nil
+}
+
+// ClientsNearBufferLimit returns the clients of the node at addr whose output buffer memory (omem) is above
+// ratio of the hard limit of their class, configured by client-output-buffer-limit. These clients are likely
+// to be disconnected soon. Clients of unlimited classes are never returned.
+func (a *Admin) ClientsNearBufferLimit(ctx context.Context, addr string, ratio float64) ([]ClientInfo, error) {
+	c := a.nodeClient(addr)
+	defer c.Close()
+
+	config, err := getRedisConfig(ctx, c, "client-output-buffer-limit")
+	if err != nil {
+		return nil, err
+	}
+	limits, err := decodeOutputBufferHardLimits(config)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := c.ClientList(ctx).Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list clients of node %s: %v", addr, err)
+	}
+	clients, err := DecodeClientList(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	near := []ClientInfo{}
+	for _, client := range clients {
+		client.HardLimit = limits[client.Class]
+		if client.HardLimit > 0 && float64(client.OutputMemory) >= ratio*float64(client.HardLimit) {
+			near = append(near, client)
+		}
+	}
+	return near, nil
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAdminClientsNearBufferLimit(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CONFIG GET":
+			return []string{"client-output-buffer-limit", "normal 0 0 0 slave 268435456 67108864 60 pubsub 32mb 8mb 60"}
+		case "CLIENT LIST":
+			return "id=3 addr=10.0.0.5:50188 laddr=10.0.0.1:6379 fd=8 name=app age=10 idle=0 flags=N db=0 omem=900000000 cmd=get\n" +
+				"id=4 addr=10.0.0.2:41000 laddr=10.0.0.1:6379 fd=9 name= age=500 idle=0 flags=S db=0 omem=250000000 cmd=replconf\n" +
+				"id=5 addr=10.0.0.6:52000 laddr=10.0.0.1:6379 fd=10 name=sub age=20 idle=1 flags=P db=0 omem=1048576 cmd=subscribe\n"
+		}
+		return fakeError("ERR unexpected command")
+	})
+	a := newTestAdmin(fake.Addr())
+
+	near, err := a.ClientsNearBufferLimit(context.Background(), fake.Addr(), 0.9)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(near) != 1 || near[0].ID != 4 || near[0].Class != "replica" || near[0].HardLimit != 268435456 {
+		t.Errorf("expected only the replica client to be near its limit, got %+v", near)
+	}
+
+	if near, err = a.ClientsNearBufferLimit(context.Background(), fake.Addr(), 0.01); err != nil || len(near) != 2 {
+		t.Errorf("expected the replica and pubsub clients over 1%% of their limit, got %+v, %v", near, err)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+
+	"github.com/kubernetes-app/redisutil/utils"
+)
+
+// ConfigSnapshot represents the full config of every master at a point in time
+type ConfigSnapshot struct {
+	Time time.Time `json:"time"`
+	// Nodes config parameters of each master, keyed by master ID
+	Nodes map[string]map[string]string `json:"nodes"`
+	// Addrs address of each master, keyed by master ID
+	Addrs map[string]string `json:"addrs"`
+}
+
+// SnapshotConfig captures the full config (CONFIG GET *) of every master
+func (a *Admin) SnapshotConfig(ctx context.Context) (ConfigSnapshot, error) {
+	snap := ConfigSnapshot{Time: time.Now(), Nodes: map[string]map[string]string{}, Addrs: map[string]string{}}
+	err := a.forEachMaster(ctx, func(ctx context.Context, c *redis.Client, node *Node) error {
+		values, err := c.ConfigGet(ctx, "*").Result()
+		if err != nil {
+			return fmt.Errorf("unable to get config: %v", err)
+		}
+		config := make(map[string]string, len(values)/2)
+		for i := 0; i+1 < len(values); i += 2 {
+			key, _ := values[i].(string)
+			value, _ := values[i+1].(string)
+			config[key] = value
+		}
+		snap.Nodes[node.ID] = config
+		snap.Addrs[node.ID] = node.IPPort()
+		return nil
+	})
+	return snap, err
+}
+
+// RestoreConfig reapplies with CONFIG SET the keys of the snapshot on every master it contains.
+// Masters missing from the snapshot or from the cluster are reported in the returned NodesError,
+// as well as the per-master failures. A master missing from the cluster is keyed by its address
+// in the snapshot.
+func (a *Admin) RestoreConfig(ctx context.Context, snap ConfigSnapshot, keys []string) error {
+	restored := map[string]bool{}
+	err := a.forEachMaster(ctx, func(ctx context.Context, c *redis.Client, node *Node) error {
+		config, ok := snap.Nodes[node.ID]
+		if !ok {
+			return fmt.Errorf("master %s not found in the snapshot", node.ID)
+		}
+		restored[node.ID] = true
+		for _, key := range keys {
+			value, ok := config[key]
+			if !ok {
+				return fmt.Errorf("config %s not found in the snapshot", key)
+			}
+			if err := c.ConfigSet(ctx, key, value).Err(); err != nil {
+				return fmt.Errorf("unable to set config %s: %v", key, err)
+			}
+		}
+		return nil
+	})
+	if err != nil && !IsNodesError(err) {
+		return err
+	}
+	errs := map[string]error{}
+	if err != nil {
+		errs = err.(NodesError).Errs
+	}
+	for id := range snap.Nodes {
+		if !restored[id] {
+			errs[snap.Addrs[id]] = fmt.Errorf("master %s of the snapshot not found in the cluster", id)
+		}
+	}
+	if len(errs) > 0 {
+		return NodesError{Errs: errs}
+	}
+	return nil
+}
+
+// SetConfigVerified applies the config one master at a time, reading back each parameter with CONFIG GET
+// right after setting it. The roll-out is aborted on the first node not reflecting a parameter, with a
+// ConfigNotAppliedError, so a silently ignored parameter doesn't reach the whole cluster.
+func (a *Admin) SetConfigVerified(ctx context.Context, newConfig map[string]string) error {
+	keys := make([]string, 0, len(newConfig))
+	expected := make(map[string]string, len(newConfig))
+	for key, value := range newConfig {
+		if _, ok := parseConfigMap[key]; ok {
+			parsed, err := utils.ParseRedisMemConf(value)
+			if err != nil {
+				return fmt.Errorf("wrong format for config %s: %v", key, err)
+			}
+			value = parsed
+		}
+		keys = append(keys, key)
+		expected[key] = value
+	}
+	sort.Strings(keys)
+
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	masters := nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole })
+	sort.Sort(masters)
+	for _, master := range masters {
+		c := a.nodeClient(master.IPPort())
+		err := setConfigVerified(ctx, c, master, keys, expected)
+		c.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setConfigVerified sets then reads back each config key on the node the client is connected to
+func setConfigVerified(ctx context.Context, c *redis.Client, node *Node, keys []string, expected map[string]string) error {
+	for _, key := range keys {
+		if err := c.ConfigSet(ctx, key, expected[key]).Err(); err != nil {
+			return fmt.Errorf("unable to set config %s on node %s: %v", key, node.ID, err)
+		}
+		observed, err := getRedisConfig(ctx, c, key)
+		if err != nil {
+			return err
+		}
+		if observed != expected[key] {
+			return ConfigNotAppliedError{NodeID: node.ID, Key: key, Expected: expected[key], Observed: observed}
+		}
+	}
+	return nil
+}
+
+// allowReadsWhenDownConfig config parameter allowing the nodes to serve reads while the cluster state is fail
+const allowReadsWhenDownConfig = "cluster-allow-reads-when-down"
+
+// GetAllowReadsWhenDown returns whether the masters serve reads while the cluster is down, from their
+// cluster-allow-reads-when-down config. An error is returned if the masters disagree or a master doesn't
+// support the parameter (before redis 6).
+func (a *Admin) GetAllowReadsWhenDown(ctx context.Context) (bool, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return false, err
+	}
+	masters := nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole })
+	sort.Sort(masters)
+	values := map[string]string{}
+	for _, master := range masters {
+		c := a.nodeClient(master.IPPort())
+		value, err := getRedisConfig(ctx, c, allowReadsWhenDownConfig)
+		c.Close()
+		if err != nil {
+			return false, fmt.Errorf("unable to get %s of master %s: %v", allowReadsWhenDownConfig, master.ID, err)
+		}
+		if value != "yes" && value != "no" {
+			return false, fmt.Errorf("master %s doesn't support %s, got %q", master.ID, allowReadsWhenDownConfig, value)
+		}
+		values[master.ID] = value
+	}
+	allowed := ""
+	for _, master := range masters {
+		if allowed == "" {
+			allowed = values[master.ID]
+		} else if values[master.ID] != allowed {
+			return false, fmt.Errorf("masters disagree on %s: %v", allowReadsWhenDownConfig, values)
+		}
+	}
+	return allowed == "yes", nil
+}
+
+// SetAllowReadsWhenDown sets cluster-allow-reads-when-down on every master, one at a time, verifying it
+// is applied (see SetConfigVerified)
+func (a *Admin) SetAllowReadsWhenDown(ctx context.Context, allow bool) error {
+	value := "no"
+	if allow {
+		value = "yes"
+	}
+	return a.SetConfigVerified(ctx, map[string]string{allowReadsWhenDownConfig: value})
+}
+
+// nodeTimeoutConfig config parameter holding the milliseconds a node must be unreachable to be considered failing
+const nodeTimeoutConfig = "cluster-node-timeout"
+
+// SetNodeTimeout sets cluster-node-timeout to ms on every node, masters and slaves, reading it back right
+// after. Nodes not reflecting it are reported in the returned NodesError with a ConfigNotAppliedError.
+func (a *Admin) SetNodeTimeout(ctx context.Context, ms int) error {
+	if ms <= 0 {
+		return fmt.Errorf("invalid %s %d, must be positive", nodeTimeoutConfig, ms)
+	}
+	value := strconv.Itoa(ms)
+	return a.ForEachNode(ctx, func(ctx context.Context, c *redis.Client, node *Node) error {
+		return setConfigVerified(ctx, c, node, []string{nodeTimeoutConfig}, map[string]string{nodeTimeoutConfig: value})
+	})
+}
+
+// NodeTimeoutDrift returns the cluster-node-timeout of the nodes, keyed by node ID, whose value differs from
+// the one shared by most nodes. On a tie the lowest value is considered the reference. Nodes disagreeing on
+// the timeout detect failures at different paces, which may lead to conflicting failovers.
+func (a *Admin) NodeTimeoutDrift(ctx context.Context) (map[string]int, error) {
+	timeouts := map[string]int{}
+	err := a.ForEachNode(ctx, func(ctx context.Context, c *redis.Client, node *Node) error {
+		value, err := getRedisConfig(ctx, c, nodeTimeoutConfig)
+		if err != nil {
+			return err
+		}
+		timeout, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("wrong format for config %s: %q", nodeTimeoutConfig, value)
+		}
+		timeouts[node.ID] = timeout
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[int]int{}
+	for _, timeout := range timeouts {
+		counts[timeout]++
+	}
+	reference, best := 0, 0
+	for timeout, count := range counts {
+		if count > best || (count == best && timeout < reference) {
+			reference, best = timeout, count
+		}
+	}
+	drift := map[string]int{}
+	for id, timeout := range timeouts {
+		if timeout != reference {
+			drift[id] = timeout
+		}
+	}
+	return drift, nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// configHandler returns a handler replying CLUSTER NODES and serving the config with CONFIG GET and CONFIG SET
+func configHandler(view *string, config map[string]string) fakeHandler {
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return *view
+		case "CONFIG GET":
+			values := []string{}
+			for key, value := range config {
+				if args[2] == "*" || args[2] == key {
+					values = append(values, key, value)
+				}
+			}
+			return values
+		case "CONFIG SET":
+			config[args[2]] = args[3]
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminSnapshotRestoreConfig(t *testing.T) {
+	var view string
+	configA := map[string]string{"maxmemory": "1073741824", "maxmemory-policy": "allkeys-lru", "timeout": "0"}
+	configB := map[string]string{"maxmemory": "2147483648", "maxmemory-policy": "noeviction", "timeout": "300"}
+	m1 := newFakeRedis(t, configHandler(&view, configA))
+	m2 := newFakeRedis(t, configHandler(&view, configB))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", m1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", m2.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", "10.0.0.3:6379", "slave", "A"),
+	)
+	a := newTestAdmin(m1.Addr())
+
+	snap, err := a.SnapshotConfig(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]map[string]string{
+		"A": {"maxmemory": "1073741824", "maxmemory-policy": "allkeys-lru", "timeout": "0"},
+		"B": {"maxmemory": "2147483648", "maxmemory-policy": "noeviction", "timeout": "300"},
+	}
+	if !reflect.DeepEqual(snap.Nodes, want) {
+		t.Fatalf("SnapshotConfig() = %v, want %v", snap.Nodes, want)
+	}
+
+	// a bad config change, partially rolled back
+	configA["maxmemory-policy"], configA["timeout"] = "volatile-lru", "60"
+	configB["maxmemory-policy"], configB["timeout"] = "volatile-lru", "60"
+	if err := a.RestoreConfig(context.Background(), snap, []string{"maxmemory-policy"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if configA["maxmemory-policy"] != "allkeys-lru" || configB["maxmemory-policy"] != "noeviction" {
+		t.Errorf("expected maxmemory-policy to be restored, got %s and %s", configA["maxmemory-policy"], configB["maxmemory-policy"])
+	}
+	if configA["timeout"] != "60" || configB["timeout"] != "60" {
+		t.Errorf("expected timeout not to be restored")
+	}
+	if len(m1.Calls("CONFIG SET")) != 1 || len(m2.Calls("CONFIG SET")) != 1 {
+		t.Errorf("expected a single CONFIG SET per master")
+	}
+
+	if !reflect.DeepEqual(snap.Addrs, map[string]string{"A": m1.Addr(), "B": m2.Addr()}) {
+		t.Errorf("unexpected masters addresses in the snapshot: %v", snap.Addrs)
+	}
+	delete(snap.Nodes, "B")
+	snap.Nodes["X"], snap.Addrs["X"] = configA, "10.0.0.9:6379"
+	err = a.RestoreConfig(context.Background(), snap, []string{"timeout"})
+	nodesErr, ok := err.(NodesError)
+	if !ok || len(nodesErr.Errs) != 2 || nodesErr.Errs[m2.Addr()] == nil || nodesErr.Errs["10.0.0.9:6379"] == nil {
+		t.Errorf("expected a NodesError keyed by address for the masters missing from the snapshot and from the cluster, got %v", err)
+	}
+}
+
+func TestAdminSetConfigVerified(t *testing.T) {
+	var view string
+	configA := map[string]string{"maxmemory": "0"}
+	configB := map[string]string{"maxmemory": "0"}
+	configC := map[string]string{"maxmemory": "0"}
+	m1 := newFakeRedis(t, configHandler(&view, configA))
+	// B silently ignores the new maxmemory-policy
+	m2 := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "CONFIG SET" && args[2] == "maxmemory-policy" {
+			return fakeOK
+		}
+		return configHandler(&view, configB)(args)
+	})
+	m3 := newFakeRedis(t, configHandler(&view, configC))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", m1.Addr(), "myself,master", "-", "0-5460"),
+		fakeNodeLine("B", m2.Addr(), "master", "-", "5461-10922"),
+		fakeNodeLine("C", m3.Addr(), "master", "-", "10923-16383"),
+	)
+	a := newTestAdmin(m1.Addr())
+
+	if err := a.SetConfigVerified(context.Background(), map[string]string{"maxmemory": "1gb"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, config := range []map[string]string{configA, configB, configC} {
+		if config["maxmemory"] != "1073741824" {
+			t.Errorf("expected maxmemory to be set, got %s", config["maxmemory"])
+		}
+	}
+
+	err := a.SetConfigVerified(context.Background(), map[string]string{"maxmemory-policy": "allkeys-lru"})
+	if !IsConfigNotAppliedError(err) {
+		t.Fatalf("expected a ConfigNotAppliedError, got %v", err)
+	}
+	if e := err.(ConfigNotAppliedError); e.NodeID != "B" || e.Key != "maxmemory-policy" || e.Observed != "" {
+		t.Errorf("unexpected error values: %+v", e)
+	}
+	if configA["maxmemory-policy"] != "allkeys-lru" {
+		t.Errorf("expected the config to be applied on A before B")
+	}
+	if _, ok := configC["maxmemory-policy"]; ok {
+		t.Errorf("expected the roll-out to be aborted before C")
+	}
+}
+
+func TestAdminAllowReadsWhenDown(t *testing.T) {
+	var view string
+	configA := map[string]string{"cluster-allow-reads-when-down": "no"}
+	configB := map[string]string{"cluster-allow-reads-when-down": "no"}
+	m1 := newFakeRedis(t, configHandler(&view, configA))
+	m2 := newFakeRedis(t, configHandler(&view, configB))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", m1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", m2.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", "10.0.0.3:6379", "slave", "A"),
+	)
+	a := newTestAdmin(m1.Addr())
+	ctx := context.Background()
+
+	allowed, err := a.GetAllowReadsWhenDown(ctx)
+	if err != nil || allowed {
+		t.Fatalf("expected reads to be forbidden when down, got %v, %v", allowed, err)
+	}
+
+	if err := a.SetAllowReadsWhenDown(ctx, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	allowed, err = a.GetAllowReadsWhenDown(ctx)
+	if err != nil || !allowed {
+		t.Fatalf("expected reads to be allowed when down, got %v, %v", allowed, err)
+	}
+
+	configB["cluster-allow-reads-when-down"] = "no"
+	if _, err := a.GetAllowReadsWhenDown(ctx); err == nil {
+		t.Errorf("expected an error when the masters disagree")
+	}
+}
+
+func TestAdminNodeTimeoutDrift(t *testing.T) {
+	var view string
+	configs := []map[string]string{
+		{"cluster-node-timeout": "15000"},
+		{"cluster-node-timeout": "15000"},
+		{"cluster-node-timeout": "5000"},
+		{"cluster-node-timeout": "15000"},
+	}
+	fakes := []*fakeRedis{}
+	for _, config := range configs {
+		fakes = append(fakes, newFakeRedis(t, configHandler(&view, config)))
+	}
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fakes[0].Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", fakes[1].Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", fakes[2].Addr(), "slave", "A"),
+		fakeNodeLine("D", fakes[3].Addr(), "slave", "B"),
+	)
+	a := newTestAdmin(fakes[0].Addr())
+	ctx := context.Background()
+
+	drift, err := a.NodeTimeoutDrift(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]int{"C": 5000}; !reflect.DeepEqual(drift, want) {
+		t.Errorf("NodeTimeoutDrift() = %v, want %v", drift, want)
+	}
+
+	if err := a.SetNodeTimeout(ctx, 0); err == nil {
+		t.Errorf("expected an error for a non positive timeout")
+	}
+	if err := a.SetNodeTimeout(ctx, 10000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if drift, err := a.NodeTimeoutDrift(ctx); err != nil || len(drift) != 0 {
+		t.Errorf("expected no drift once the timeout is set everywhere, got %v, %v", drift, err)
+	}
+
+	configs[3]["cluster-node-timeout"] = "bad"
+	if _, err := a.NodeTimeoutDrift(ctx); !IsNodesError(err) {
+		t.Errorf("expected a NodesError for a malformed timeout, got %v", err)
+	}
+}
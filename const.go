@@ -38,8 +38,13 @@ const (
 	NodeStatusNoAddr = "noaddr"
 	// NodeStatusNoFlags no flags at all
 	NodeStatusNoFlags = "noflags"
+	// NodeStatusNoFailover Replica that will never try to failover its master (cluster-replica-no-failover)
+	NodeStatusNoFailover = "nofailover"
 )
 
+// NodeFlagMyself CLUSTER NODES flag of the node answering the command
+const NodeFlagMyself = "myself"
+
 const (
 	// DefaultRedisPort define the default Redis Port
 	DefaultRedisPort = "6379"
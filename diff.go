@@ -0,0 +1,255 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NodeChange represents a Node present in both snapshots whose topology changed
+type NodeChange struct {
+	ID     string
+	Before *Node
+	After  *Node
+}
+
+// NodesDiff represents the topology differences between two Nodes snapshots
+type NodesDiff struct {
+	Added   Nodes
+	Removed Nodes
+	Changed []NodeChange
+}
+
+// IsEmpty returns true if the two snapshots have the same topology
+func (d NodesDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff compares the current Nodes (before) with the provided Nodes (after).
+// Only topology fields are compared: address, role, master, failure status and slots.
+// Volatile fields like ping/pong timestamps are ignored. Results are sorted by ID.
+func (n Nodes) Diff(after Nodes) NodesDiff {
+	diff := NodesDiff{Added: Nodes{}, Removed: Nodes{}, Changed: []NodeChange{}}
+	for _, node := range n {
+		other, err := after.GetNodeByID(node.ID)
+		if err != nil {
+			diff.Removed = append(diff.Removed, node)
+			continue
+		}
+		if !sameTopology(node, other) {
+			diff.Changed = append(diff.Changed, NodeChange{ID: node.ID, Before: node, After: other})
+		}
+	}
+	for _, node := range after {
+		if _, err := n.GetNodeByID(node.ID); err != nil {
+			diff.Added = append(diff.Added, node)
+		}
+	}
+	sort.Sort(diff.Added)
+	sort.Sort(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].ID < diff.Changed[j].ID })
+
+	return diff
+}
+
+// sameTopology returns true if both nodes share the same topology fields
+func sameTopology(n1, n2 *Node) bool {
+	if n1.IPPort() != n2.IPPort() || n1.GetRole() != n2.GetRole() || n1.MasterReferent != n2.MasterReferent {
+		return false
+	}
+	if strings.Join(n1.FailStatus, ",") != strings.Join(n2.FailStatus, ",") {
+		return false
+	}
+	added, removed := slotsDelta(n1.Slots, n2.Slots)
+	return len(added) == 0 && len(removed) == 0
+}
+
+// slotsDelta returns the slots present only in after (added) and only in before (removed)
+func slotsDelta(before, after []Slot) (added, removed []Slot) {
+	beforeSet := make(map[Slot]struct{}, len(before))
+	for _, s := range before {
+		beforeSet[s] = struct{}{}
+	}
+	afterSet := make(map[Slot]struct{}, len(after))
+	for _, s := range after {
+		afterSet[s] = struct{}{}
+		if _, ok := beforeSet[s]; !ok {
+			added = append(added, s)
+		}
+	}
+	for _, s := range before {
+		if _, ok := afterSet[s]; !ok {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+// formatSlots returns a compact representation of slots, ex: "0-100,200"
+func formatSlots(slots []Slot) string {
+	return strings.Join(encodeSlotRanges(slots), ",")
+}
+
+// String human readable representation of a NodeChange
+func (c NodeChange) String() string {
+	changes := []string{}
+	if c.Before.IPPort() != c.After.IPPort() {
+		changes = append(changes, fmt.Sprintf("addr %s→%s", c.Before.IPPort(), c.After.IPPort()))
+	}
+	if c.Before.GetRole() != c.After.GetRole() {
+		changes = append(changes, fmt.Sprintf("role %s→%s", c.Before.GetRole(), c.After.GetRole()))
+	}
+	if c.Before.MasterReferent != c.After.MasterReferent {
+		changes = append(changes, fmt.Sprintf("master %s→%s", orNone(c.Before.MasterReferent), orNone(c.After.MasterReferent)))
+	}
+	before, after := strings.Join(c.Before.FailStatus, ","), strings.Join(c.After.FailStatus, ",")
+	if before != after {
+		changes = append(changes, fmt.Sprintf("status %s→%s", orNone(before), orNone(after)))
+	}
+	added, removed := slotsDelta(c.Before.Slots, c.After.Slots)
+	if len(added) > 0 {
+		changes = append(changes, fmt.Sprintf("slots %s added", formatSlots(added)))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, fmt.Sprintf("slots %s removed", formatSlots(removed)))
+	}
+	return fmt.Sprintf("node %s: %s", c.ID, strings.Join(changes, "; "))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// DiffTopologies returns a human readable summary of the topology changes between two snapshots.
+// Entries are sorted by node ID so the output is deterministic,
+// ex: "node abcd: role master→slave; slots 0-100 removed; new node efgh added"
+func DiffTopologies(before, after Nodes) string {
+	diff := before.Diff(after)
+	entries := map[string]string{}
+	for _, node := range diff.Added {
+		entries[node.ID] = fmt.Sprintf("new node %s added", node.ID)
+	}
+	for _, node := range diff.Removed {
+		entries[node.ID] = fmt.Sprintf("node %s removed", node.ID)
+	}
+	for _, change := range diff.Changed {
+		entries[change.ID] = change.String()
+	}
+
+	ids := make([]string, 0, len(entries))
+	for id := range entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	lines := make([]string, 0, len(ids))
+	for _, id := range ids {
+		lines = append(lines, entries[id])
+	}
+	return strings.Join(lines, "; ")
+}
+
+// Fingerprint returns a stable hash of the nodes topology: IDs, addresses, roles, masters,
+// failure status and slot ranges. Volatile fields like ping/pong timestamps are excluded and
+// the result doesn't depend on the nodes order, so it can be compared between polls.
+func (n Nodes) Fingerprint() string {
+	sorted := make(Nodes, len(n))
+	copy(sorted, n)
+	sort.Sort(sorted)
+
+	h := sha256.New()
+	for _, node := range sorted {
+		status := append([]string{}, node.FailStatus...)
+		sort.Strings(status)
+		fmt.Fprintf(h, "%s %s %s %s %s %s\n", node.ID, node.IPPort(), node.GetRole(), orNone(node.MasterReferent),
+			strings.Join(status, ","), formatSlots(node.Slots))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SnapshotNode is the normalized topology of a Node, as stored in a TopologySnapshot
+type SnapshotNode struct {
+	ID             string      `json:"id"`
+	IP             string      `json:"ip"`
+	Port           string      `json:"port"`
+	Role           string      `json:"role"`
+	MasterReferent string      `json:"master,omitempty"`
+	FailStatus     []string    `json:"failStatus,omitempty"`
+	Slots          []SlotRange `json:"slots,omitempty"`
+}
+
+// TopologySnapshot is a timestamped, serializable copy of the cluster topology. Nodes are sorted by ID
+// and only carry the topology fields used by Diff, so snapshots can be stored, ex: in a ConfigMap,
+// and compared across time.
+type TopologySnapshot struct {
+	Timestamp   time.Time      `json:"timestamp"`
+	Fingerprint string         `json:"fingerprint"`
+	Nodes       []SnapshotNode `json:"nodes"`
+}
+
+// Snapshot returns the current topology of the nodes as a TopologySnapshot
+func (n Nodes) Snapshot() TopologySnapshot {
+	sorted := make(Nodes, len(n))
+	copy(sorted, n)
+	sort.Sort(sorted)
+
+	snapshot := TopologySnapshot{Timestamp: time.Now(), Fingerprint: n.Fingerprint(), Nodes: []SnapshotNode{}}
+	for _, node := range sorted {
+		status := append([]string{}, node.FailStatus...)
+		sort.Strings(status)
+		slots := make([]Slot, len(node.Slots))
+		copy(slots, node.Slots)
+		snapshot.Nodes = append(snapshot.Nodes, SnapshotNode{
+			ID:             node.ID,
+			IP:             node.IP,
+			Port:           node.Port,
+			Role:           node.GetRole(),
+			MasterReferent: node.MasterReferent,
+			FailStatus:     status,
+			Slots:          SlotRangesFromSlots(slots),
+		})
+	}
+	return snapshot
+}
+
+// nodes rebuilds the Nodes stored in the snapshot
+func (s TopologySnapshot) nodes() Nodes {
+	nodes := Nodes{}
+	for _, sn := range s.Nodes {
+		node := &Node{ID: sn.ID, IP: sn.IP, Port: sn.Port, Role: sn.Role, MasterReferent: sn.MasterReferent, FailStatus: sn.FailStatus, Slots: []Slot{}}
+		for _, r := range sn.Slots {
+			node.Slots = append(node.Slots, BuildSlotSlice(r.Min, r.Max)...)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// Diff returns a human readable summary of the topology changes from the current snapshot to other,
+// in the DiffTopologies format. It is empty if both snapshots share the same fingerprint.
+func (s TopologySnapshot) Diff(other TopologySnapshot) string {
+	if s.Fingerprint != "" && s.Fingerprint == other.Fingerprint {
+		return ""
+	}
+	return DiffTopologies(s.nodes(), other.nodes())
+}
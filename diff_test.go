@@ -0,0 +1,135 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNodesDiff(t *testing.T) {
+	before := Nodes{
+		{ID: "A", IP: "1.2.3.1", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 10), PingSent: 1},
+		{ID: "B", IP: "1.2.3.2", Port: "6379", Role: RedisSlaveRole, MasterReferent: "A"},
+		{ID: "C", IP: "1.2.3.3", Port: "6379", Role: RedisMasterRole},
+	}
+	after := Nodes{
+		{ID: "D", IP: "1.2.3.4", Port: "6379", Role: RedisMasterRole},
+		{ID: "B", IP: "1.2.3.2", Port: "6379", Role: RedisSlaveRole, MasterReferent: "A", PingSent: 42},
+		{ID: "A", IP: "1.2.3.1", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 5), PingSent: 2},
+	}
+
+	diff := before.Diff(after)
+	if len(diff.Added) != 1 || diff.Added[0].ID != "D" {
+		t.Errorf("expected D to be added, got %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].ID != "C" {
+		t.Errorf("expected C to be removed, got %v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].ID != "A" {
+		t.Errorf("expected only A to be changed, got %v", diff.Changed)
+	}
+	if !before.Diff(before).IsEmpty() {
+		t.Errorf("expected no diff between identical snapshots")
+	}
+}
+
+func TestDiffTopologies(t *testing.T) {
+	before := Nodes{
+		{ID: "efgh", IP: "1.2.3.2", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(101, 200)},
+		{ID: "abcd", IP: "1.2.3.1", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 100)},
+		{ID: "ijkl", IP: "1.2.3.3", Port: "6379", Role: RedisSlaveRole, MasterReferent: "abcd"},
+	}
+	// ijkl is promoted in place of abcd, and slot 100 moves to efgh
+	after := Nodes{
+		{ID: "abcd", IP: "1.2.3.1", Port: "6379", Role: RedisSlaveRole, MasterReferent: "ijkl"},
+		{ID: "efgh", IP: "1.2.3.2", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(100, 200)},
+		{ID: "ijkl", IP: "1.2.3.3", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 99)},
+		{ID: "mnop", IP: "1.2.3.4", Port: "6379", Role: RedisMasterRole},
+	}
+
+	want := "node abcd: role master→slave; master -→ijkl; slots 0-100 removed; " +
+		"node efgh: slots 100 added; " +
+		"node ijkl: role slave→master; master abcd→-; slots 0-99 added; " +
+		"new node mnop added"
+	if got := DiffTopologies(before, after); got != want {
+		t.Errorf("DiffTopologies() =\n%s\nwant\n%s", got, want)
+	}
+	if got := DiffTopologies(after, after); got != "" {
+		t.Errorf("DiffTopologies() on identical snapshots = %q, want empty", got)
+	}
+}
+
+func TestNodesFingerprint(t *testing.T) {
+	topology := func() Nodes {
+		return Nodes{
+			{ID: "A", IP: "1.2.3.1", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 8191), PingSent: 1},
+			{ID: "B", IP: "1.2.3.2", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(8192, 16383)},
+			{ID: "C", IP: "1.2.3.3", Port: "6379", Role: RedisSlaveRole, MasterReferent: "A"},
+		}
+	}
+	reference := topology().Fingerprint()
+
+	reordered := topology()
+	reordered[0], reordered[2] = reordered[2], reordered[0]
+	reordered[1].PongRecv = 42
+	if got := reordered.Fingerprint(); got != reference {
+		t.Errorf("expected the fingerprint to be stable across reordering and ping/pong updates")
+	}
+
+	moved := topology()
+	moved[0].Slots = BuildSlotSlice(0, 8190)
+	moved[1].Slots = BuildSlotSlice(8191, 16383)
+	if got := moved.Fingerprint(); got == reference {
+		t.Errorf("expected the fingerprint to change when a slot moves")
+	}
+}
+
+func TestTopologySnapshotDiff(t *testing.T) {
+	before := Nodes{
+		{ID: "A", IP: "1.2.3.1", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 8191)},
+		{ID: "B", IP: "1.2.3.2", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(8192, 16383)},
+		{ID: "C", IP: "1.2.3.3", Port: "6379", Role: RedisSlaveRole, MasterReferent: "A"},
+	}
+	// C is failed over in place of A
+	after := Nodes{
+		{ID: "A", IP: "1.2.3.1", Port: "6379", Role: RedisSlaveRole, MasterReferent: "C"},
+		{ID: "B", IP: "1.2.3.2", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(8192, 16383)},
+		{ID: "C", IP: "1.2.3.3", Port: "6379", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 8191)},
+	}
+
+	// snapshots are stored serialized, make sure the diff survives a round trip
+	var stored TopologySnapshot
+	raw, err := json.Marshal(before.Snapshot())
+	if err != nil {
+		t.Fatalf("unable to marshal the snapshot: %v", err)
+	}
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		t.Fatalf("unable to unmarshal the snapshot: %v", err)
+	}
+	if stored.Fingerprint != before.Fingerprint() || stored.Timestamp.IsZero() {
+		t.Errorf("expected the snapshot to carry the fingerprint and a timestamp, got %+v", stored)
+	}
+
+	want := "node A: role master→slave; master -→C; slots 0-8191 removed; " +
+		"node C: role slave→master; master A→-; slots 0-8191 added"
+	if got := stored.Diff(after.Snapshot()); got != want {
+		t.Errorf("Diff() =\n%s\nwant\n%s", got, want)
+	}
+	if got := stored.Diff(before.Snapshot()); got != "" {
+		t.Errorf("Diff() on identical topologies = %q, want empty", got)
+	}
+}
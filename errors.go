@@ -15,7 +15,11 @@ limitations under the License.
 */
 package redis
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 // Error used to represent an error
 type Error string
@@ -83,3 +87,257 @@ func IsInconsistentError(err error) bool {
 	e, ok := err.(ClusterInfosError)
 	return ok && e.Inconsistent()
 }
+
+// SlotConsensusError error type returned when the masters disagree about slots owners
+type SlotConsensusError struct {
+	// Conflicts contains for each conflicting slot its owner ID as seen by each master ID
+	Conflicts map[Slot]map[string]string
+}
+
+// Error error string
+func (e SlotConsensusError) Error() string {
+	slots := make([]Slot, 0, len(e.Conflicts))
+	for slot := range e.Conflicts {
+		slots = append(slots, slot)
+	}
+	sort.Sort(SlotSlice(slots))
+
+	// group consecutive slots sharing the same views
+	groups := []string{}
+	var first, last Slot
+	views := ""
+	flush := func() {
+		if views != "" {
+			groups = append(groups, fmt.Sprintf("slots %s: %s", SlotRange{Min: first, Max: last}, views))
+		}
+	}
+	for _, slot := range slots {
+		v := formatSlotViews(e.Conflicts[slot])
+		if views == v && slot == last+1 {
+			last = slot
+			continue
+		}
+		flush()
+		first, last, views = slot, slot, v
+	}
+	flush()
+	return "masters disagree on slots ownership: " + strings.Join(groups, "; ")
+}
+
+// formatSlotViews returns the owner of a slot as seen by each master, sorted by master ID
+func formatSlotViews(views map[string]string) string {
+	ids := make([]string, 0, len(views))
+	for id := range views {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, fmt.Sprintf("%s sees %s", id, orNone(views[id])))
+	}
+	return strings.Join(s, ", ")
+}
+
+// IsSlotConsensusError returns true if the error is due to masters disagreeing on slots ownership
+func IsSlotConsensusError(err error) bool {
+	_, ok := err.(SlotConsensusError)
+	return ok
+}
+
+// nodeRejoinedError returns when a forgotten node reappears in the cluster
+const nodeRejoinedError = Error("node rejoined the cluster after being forgotten")
+
+// IsNodeRejoinedError returns true if the current error is a NodeRejoinedError
+func IsNodeRejoinedError(err error) bool {
+	return err == nodeRejoinedError
+}
+
+// replicaLaggingError returns when a replica didn't catch up with its master in time
+const replicaLaggingError = Error("replica is lagging behind its master")
+
+// IsReplicaLaggingError returns true if the current error is a ReplicaLaggingError
+func IsReplicaLaggingError(err error) bool {
+	return err == replicaLaggingError
+}
+
+// ClusterSizeError error type returned when the cluster size differs from the expected one
+type ClusterSizeError struct {
+	Expected int
+	Observed int
+}
+
+// Error error string
+func (e ClusterSizeError) Error() string {
+	return fmt.Sprintf("cluster size is %d, expected %d", e.Observed, e.Expected)
+}
+
+// IsClusterSizeError returns true if the error is due to an unexpected cluster size
+func IsClusterSizeError(err error) bool {
+	_, ok := err.(ClusterSizeError)
+	return ok
+}
+
+// SlotsOwnedError error type returned when an operation is refused because the node still owns slots
+type SlotsOwnedError struct {
+	NodeID string
+	Slots  []Slot
+}
+
+// Error error string
+func (e SlotsOwnedError) Error() string {
+	return fmt.Sprintf("node %s still owns slots %s", e.NodeID, SlotSlice(e.Slots))
+}
+
+// IsSlotsOwnedError returns true if the error is due to a node still owning slots
+func IsSlotsOwnedError(err error) bool {
+	_, ok := err.(SlotsOwnedError)
+	return ok
+}
+
+// DelSlotsError error type returned when CLUSTER DELSLOTS failed after some batches of slots were removed
+type DelSlotsError struct {
+	Addr string
+	// Removed number of slots successfully removed before the failure
+	Removed int
+	// Remaining slots not removed yet, to retry
+	Remaining []Slot
+	Err       error
+}
+
+// Error error string
+func (e DelSlotsError) Error() string {
+	return fmt.Sprintf("unable to delete slots %s from node %s after %d slot(s) removed: %v", formatSlots(e.Remaining), e.Addr, e.Removed, e.Err)
+}
+
+// IsDelSlotsError returns true if the error is due to a partial CLUSTER DELSLOTS
+func IsDelSlotsError(err error) bool {
+	_, ok := err.(DelSlotsError)
+	return ok
+}
+
+// TopologyDisagreementError error type returned when the nodes views disagree about the role or the failure
+// state of some nodes, a split-brain symptom
+type TopologyDisagreementError struct {
+	Disagreements []string
+}
+
+// Error error string
+func (e TopologyDisagreementError) Error() string {
+	return fmt.Sprintf("nodes views disagree: %s", strings.Join(e.Disagreements, "; "))
+}
+
+// IsTopologyDisagreementError returns true if the error is due to nodes views disagreeing
+func IsTopologyDisagreementError(err error) bool {
+	_, ok := err.(TopologyDisagreementError)
+	return ok
+}
+
+// NodesError error type aggregating the errors of an operation run on several nodes, keyed by node address
+type NodesError struct {
+	Errs map[string]error
+}
+
+// Error error string
+func (e NodesError) Error() string {
+	addrs := make([]string, 0, len(e.Errs))
+	for addr := range e.Errs {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	errs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		errs = append(errs, fmt.Sprintf("%s: '%s'", addr, e.Errs[addr]))
+	}
+	return fmt.Sprintf("failed on %d node(s): %s", len(errs), strings.Join(errs, ", "))
+}
+
+// IsNodesError returns true if the error aggregates the errors of several nodes
+func IsNodesError(err error) bool {
+	_, ok := err.(NodesError)
+	return ok
+}
+
+// AnnouncedEndpointError error type returned when the announced endpoints of a node differ from its cluster view
+type AnnouncedEndpointError struct {
+	NodeID     string
+	Mismatches []string
+}
+
+// Error error string
+func (e AnnouncedEndpointError) Error() string {
+	return fmt.Sprintf("node %s announced endpoints mismatch: %s", e.NodeID, strings.Join(e.Mismatches, ", "))
+}
+
+// IsAnnouncedEndpointError returns true if the error is due to announced endpoints mismatching
+func IsAnnouncedEndpointError(err error) bool {
+	_, ok := err.(AnnouncedEndpointError)
+	return ok
+}
+
+// ClusterVerificationError error type listing the violations found while verifying a cluster
+type ClusterVerificationError struct {
+	Violations []string
+}
+
+// Error error string
+func (e ClusterVerificationError) Error() string {
+	return fmt.Sprintf("cluster verification failed: %s", strings.Join(e.Violations, "; "))
+}
+
+// IsClusterVerificationError returns true if the error is due to a cluster failing its verification
+func IsClusterVerificationError(err error) bool {
+	_, ok := err.(ClusterVerificationError)
+	return ok
+}
+
+// TopologyConflictError error type returned when a desired topology can't be applied safely
+type TopologyConflictError struct {
+	Conflicts []string
+}
+
+// Error error string
+func (e TopologyConflictError) Error() string {
+	return fmt.Sprintf("unable to apply the desired topology: %s", strings.Join(e.Conflicts, "; "))
+}
+
+// IsTopologyConflictError returns true if the error is due to a desired topology that can't be applied
+func IsTopologyConflictError(err error) bool {
+	_, ok := err.(TopologyConflictError)
+	return ok
+}
+
+// ConfigNotAppliedError error type returned when a node doesn't reflect a config it has been set
+type ConfigNotAppliedError struct {
+	NodeID   string
+	Key      string
+	Expected string
+	Observed string
+}
+
+// Error error string
+func (e ConfigNotAppliedError) Error() string {
+	return fmt.Sprintf("config %s of node %s is %q after setting %q", e.Key, e.NodeID, e.Observed, e.Expected)
+}
+
+// IsConfigNotAppliedError returns true if the error is due to a config not reflected by a node
+func IsConfigNotAppliedError(err error) bool {
+	_, ok := err.(ConfigNotAppliedError)
+	return ok
+}
+
+// ReplicaInconsistencyError error type returned when keys of a replica differ from its master
+type ReplicaInconsistencyError struct {
+	ReplicaID string
+	Keys      []string
+}
+
+// Error error string
+func (e ReplicaInconsistencyError) Error() string {
+	return fmt.Sprintf("replica %s differs from its master on keys %s", e.ReplicaID, strings.Join(e.Keys, ", "))
+}
+
+// IsReplicaInconsistencyError returns true if the error is due to a replica dataset differing from its master
+func IsReplicaInconsistencyError(err error) bool {
+	_, ok := err.(ReplicaInconsistencyError)
+	return ok
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStatus is replied as a RESP simple string, ex: +OK
+type fakeStatus string
+
+// fakeError is replied as a RESP error, ex: -ERR something
+type fakeError string
+
+const fakeOK = fakeStatus("OK")
+
+// fakeHandler returns the reply for a command. Supported reply types are
+// nil, string (bulk), fakeStatus, fakeError, int, int64, []string and []interface{}
+type fakeHandler func(args []string) interface{}
+
+// fakeRedis is a minimal RESP server used to exercise the Admin against scripted replies
+type fakeRedis struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	handler fakeHandler
+	calls   [][]string
+}
+
+// newFakeRedis starts a fake redis server on a random local port, stopped at the end of the test
+func newFakeRedis(t *testing.T, handler fakeHandler) *fakeRedis {
+	return newFakeRedisOn(t, "127.0.0.1", handler)
+}
+
+// newFakeRedisOn starts a fake redis server on a random port of the loopback ip, for tests telling the
+// nodes apart by IP
+func newFakeRedisOn(t *testing.T, ip string, handler fakeHandler) *fakeRedis {
+	ln, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Fatalf("unable to start fake redis: %v", err)
+	}
+	f := &fakeRedis{ln: ln, handler: handler}
+	t.Cleanup(func() { ln.Close() })
+	go f.serve()
+	return f
+}
+
+// Addr returns the ip:port the fake server listens on
+func (f *fakeRedis) Addr() string {
+	return f.ln.Addr().String()
+}
+
+// IP returns the ip the fake server listens on
+func (f *fakeRedis) IP() string {
+	host, _, _ := net.SplitHostPort(f.Addr())
+	return host
+}
+
+// Port returns the port the fake server listens on
+func (f *fakeRedis) Port() string {
+	_, port, _ := net.SplitHostPort(f.Addr())
+	return port
+}
+
+// SetHandler replaces the handler used for the next commands
+func (f *fakeRedis) SetHandler(handler fakeHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handler = handler
+}
+
+// Calls returns the received commands matching the name, as returned by fakeCmdName
+func (f *fakeRedis) Calls(name string) [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := [][]string{}
+	for _, args := range f.calls {
+		if fakeCmdName(args) == name {
+			calls = append(calls, args)
+		}
+	}
+	return calls
+}
+
+// fakeCmdName returns the upper case command name, including the sub command for container commands
+func fakeCmdName(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "CLUSTER", "CONFIG", "CLIENT", "ACL", "MEMORY", "DEBUG", "OBJECT", "LATENCY":
+		if len(args) > 1 {
+			name += " " + strings.ToUpper(args[1])
+		}
+	}
+	return name
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.serveConn(conn)
+	}
+}
+
+func (f *fakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readFakeCommand(r)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.calls = append(f.calls, args)
+		handler := f.handler
+		f.mu.Unlock()
+
+		var reply interface{} = fakeOK
+		if handler != nil {
+			reply = handler(args)
+		}
+		writeFakeReply(w, reply)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func readFakeCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimRight(header, "\r\n")[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func writeFakeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case fakeStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case fakeError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, s := range v {
+			writeFakeReply(w, s)
+		}
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeFakeReply(w, e)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unsupported fake reply %T\r\n", v)
+	}
+}
+
+// newTestAdmin returns an Admin connected to the provided fake servers addresses
+func newTestAdmin(addrs ...string) *Admin {
+	return NewAdmin(addrs, "").(*Admin)
+}
+
+// fakeNodeLine returns a CLUSTER NODES line describing a node served at addr
+func fakeNodeLine(id, addr, flags, master string, slots ...string) string {
+	_, port, _ := net.SplitHostPort(addr)
+	line := fmt.Sprintf("%s %s@1%s %s %s 0 0 1 connected", id, addr, port, flags, master)
+	if len(slots) > 0 {
+		line += " " + strings.Join(slots, " ")
+	}
+	return line
+}
+
+// fakeClusterNodes returns a CLUSTER NODES output built from lines
+func fakeClusterNodes(lines ...string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// fakeSlotRange returns a CLUSTER SLOTS entry of the slot range served by the master at addrs[0] and its slaves
+func fakeSlotRange(start, end int, addrs ...string) []interface{} {
+	entry := []interface{}{start, end}
+	for _, addr := range addrs {
+		host, port, _ := net.SplitHostPort(addr)
+		p, _ := strconv.Atoi(port)
+		entry = append(entry, []interface{}{host, p})
+	}
+	return entry
+}
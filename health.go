@@ -0,0 +1,209 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// StuckHandshakes returns the nodes that have been in handshake for longer than threshold.
+// The first time a node is seen in handshake is tracked across calls by node address, as the
+// node ID isn't stable until the handshake completes: it has to be polled regularly.
+// A node stuck in handshake usually means a MEET never completed, often due to a NetworkPolicy.
+func (a *Admin) StuckHandshakes(ctx context.Context, threshold time.Duration) (Nodes, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	seen := map[string]time.Time{}
+	stuck := Nodes{}
+	for _, node := range *nodes {
+		if !node.HasStatus(NodeStatusHandshake) {
+			continue
+		}
+		first, ok := a.handshakes[node.IPPort()]
+		if !ok {
+			first = now
+		}
+		seen[node.IPPort()] = first
+		if now.Sub(first) >= threshold {
+			stuck = append(stuck, node)
+		}
+	}
+	// forget nodes that completed their handshake or disappeared
+	a.handshakes = seen
+
+	return stuck, nil
+}
+
+// CheckBusConnectivity dials the cluster bus port (IP:BusPort) of each node and returns the dial error of
+// each node keyed by node ID, nil when the bus port is reachable. An unreachable bus port, usually due to a
+// NetworkPolicy allowing only the client port, is the main cause of nodes stuck in handshake.
+func (a *Admin) CheckBusConnectivity(ctx context.Context) (map[string]error, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]error, len(*nodes))
+	for _, node := range *nodes {
+		conn, err := a.dial(ctx, "tcp", net.JoinHostPort(node.IP, node.BusPort))
+		if err != nil {
+			results[node.ID] = err
+			continue
+		}
+		conn.Close()
+		results[node.ID] = nil
+	}
+	return results, nil
+}
+
+// GetAnnouncedEndpoints returns the cluster-announce-ip, cluster-announce-port and cluster-announce-bus-port
+// of the node at addr, and checks them against the node own CLUSTER NODES entry. Values not announced (empty
+// or 0) aren't checked. The mismatches are returned in an AnnouncedEndpointError along with the values.
+func (a *Admin) GetAnnouncedEndpoints(ctx context.Context, addr string) (ip, port, busPort string, err error) {
+	c := a.nodeClient(addr)
+	defer c.Close()
+
+	if ip, err = getRedisConfig(ctx, c, "cluster-announce-ip"); err != nil {
+		return
+	}
+	if port, err = getRedisConfig(ctx, c, "cluster-announce-port"); err != nil {
+		return
+	}
+	if busPort, err = getRedisConfig(ctx, c, "cluster-announce-bus-port"); err != nil {
+		return
+	}
+	self, err := getSelfNode(ctx, c)
+	if err != nil {
+		return
+	}
+
+	mismatches := []string{}
+	for _, check := range []struct{ param, announced, observed string }{
+		{"cluster-announce-ip", ip, self.IP},
+		{"cluster-announce-port", port, self.Port},
+		{"cluster-announce-bus-port", busPort, self.BusPort},
+	} {
+		if check.announced != "" && check.announced != "0" && check.announced != check.observed {
+			mismatches = append(mismatches, fmt.Sprintf("%s %s != %s", check.param, check.announced, check.observed))
+		}
+	}
+	if len(mismatches) > 0 {
+		err = AnnouncedEndpointError{NodeID: self.ID, Mismatches: mismatches}
+	}
+	return
+}
+
+// RunningGap returns the number of pods, the number of them whose redis process participates in the cluster
+// (known by the cluster with a connected link, out of handshake), and the gap between both. A positive gap
+// means pods are up but their redis isn't clustered yet. Pods are matched to the nodes by IP.
+func (a *Admin) RunningGap(ctx context.Context, pods []*corev1.Pod) (nbPods, redisRunning int32, gap int32, err error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	running := map[string]bool{}
+	for _, node := range *nodes {
+		if node.LinkState == RedisLinkStateConnected && !node.HasStatus(NodeStatusHandshake) && !node.HasStatus(NodeStatusNoAddr) {
+			running[node.IP] = true
+		}
+	}
+	for _, pod := range pods {
+		if pod.Status.PodIP != "" && running[pod.Status.PodIP] {
+			redisRunning++
+		}
+	}
+	nbPods = int32(len(pods))
+	return nbPods, redisRunning, nbPods - redisRunning, nil
+}
+
+// UnreachableLatency latency reported by PingLatency for the nodes not answering PING
+const UnreachableLatency time.Duration = -1
+
+// PingLatency measures concurrently the PING round-trip time to each node, keyed by node ID.
+// Unreachable nodes report UnreachableLatency.
+func (a *Admin) PingLatency(ctx context.Context) (map[string]time.Duration, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	latencies := make(map[string]time.Duration, len(*nodes))
+	for _, node := range *nodes {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			c := a.nodeClient(node.IPPort())
+			defer c.Close()
+			// connect first so the dial time isn't measured
+			latency := UnreachableLatency
+			if err := c.Ping(ctx).Err(); err == nil {
+				start := time.Now()
+				if err := c.Ping(ctx).Err(); err == nil {
+					latency = time.Since(start)
+				}
+			}
+			mu.Lock()
+			latencies[node.ID] = latency
+			mu.Unlock()
+		}(node)
+	}
+	wg.Wait()
+	return latencies, nil
+}
+
+// FindIsolatedNodes returns the nodes at expectedAddrs knowing only themselves (cluster_known_nodes:1), or fewer
+// than half of the expected nodes, typically after a CLUSTER RESET. They have to be met again. Each returned
+// Node has the address it has been reached at; unreachable addresses are skipped.
+func (a *Admin) FindIsolatedNodes(ctx context.Context, expectedAddrs []string) (Nodes, error) {
+	isolated := Nodes{}
+	for _, addr := range expectedAddrs {
+		c := a.nodeClient(addr)
+		infos, err := getClusterInfos(ctx, c)
+		var self *Node
+		if err == nil {
+			self, err = getSelfNode(ctx, c)
+		}
+		c.Close()
+		if err != nil {
+			logger.V(4).Infof("unable to check node %s isolation: %v", addr, err)
+			continue
+		}
+		known, err := strconv.Atoi((*infos)["cluster_known_nodes"])
+		if err != nil {
+			return nil, fmt.Errorf("wrong format for cluster_known_nodes of node %s: %v", addr, err)
+		}
+		if known == 1 || known*2 < len(expectedAddrs) {
+			if self.IP, self.Port, err = net.SplitHostPort(addr); err != nil {
+				return nil, err
+			}
+			isolated = append(isolated, self)
+		}
+	}
+	return isolated, nil
+}
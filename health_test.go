@@ -0,0 +1,228 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAdminStuckHandshakes(t *testing.T) {
+	var view string
+	fake := newFakeRedis(t, clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", "1.2.3.2:6379", "handshake", "-"),
+		fakeNodeLine("C", "1.2.3.3:6379", "handshake", "-"),
+	)
+	a := newTestAdmin(fake.Addr())
+	threshold := 50 * time.Millisecond
+
+	stuck, err := a.StuckHandshakes(context.Background(), threshold)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stuck) != 0 {
+		t.Errorf("expected no stuck node on first poll, got %v", stuck)
+	}
+
+	time.Sleep(2 * threshold)
+	// C completed its handshake, B remains in handshake with a new random ID
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B2", "1.2.3.2:6379", "handshake", "-"),
+		fakeNodeLine("C", "1.2.3.3:6379", "master", "-"),
+	)
+	stuck, err = a.StuckHandshakes(context.Background(), threshold)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stuck) != 1 || stuck[0].IPPort() != "1.2.3.2:6379" {
+		t.Errorf("expected node 1.2.3.2:6379 to be stuck in handshake, got %v", stuck)
+	}
+}
+
+func TestAdminCheckBusConnectivity(t *testing.T) {
+	var view string
+	fake := newFakeRedis(t, clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		"A 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-8191",
+		"B 10.0.0.2:6379@16379 master - 0 0 2 connected 8192-16383",
+	)
+	a := newTestAdmin(fake.Addr())
+	dialed := []string{}
+	a.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if addr == "10.0.0.2:16379" {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+
+	results, err := a.CheckBusConnectivity(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 || results["A"] != nil || results["B"] == nil {
+		t.Errorf("expected A to be reachable and B unreachable, got %v", results)
+	}
+	if len(dialed) != 2 || dialed[0] != "10.0.0.1:16379" {
+		t.Errorf("expected the bus ports to be dialed, got %v", dialed)
+	}
+}
+
+func TestAdminGetAnnouncedEndpoints(t *testing.T) {
+	var view string
+	config := map[string]string{}
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CONFIG GET":
+			return []string{args[2], config[args[2]]}
+		case "CLUSTER MYID":
+			return "A"
+		case "CLUSTER NODES":
+			return view
+		}
+		return fakeOK
+	})
+	view = fakeClusterNodes(fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-16383"))
+	a := newTestAdmin(fake.Addr())
+
+	config["cluster-announce-port"] = "0"
+	if _, _, _, err := a.GetAnnouncedEndpoints(context.Background(), fake.Addr()); err != nil {
+		t.Errorf("unexpected error without announced endpoints: %v", err)
+	}
+
+	config["cluster-announce-ip"] = fake.IP()
+	config["cluster-announce-port"] = fake.Port()
+	config["cluster-announce-bus-port"] = "1" + fake.Port()
+	ip, port, busPort, err := a.GetAnnouncedEndpoints(context.Background(), fake.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ip != fake.IP() || port != fake.Port() || busPort != "1"+fake.Port() {
+		t.Errorf("unexpected announced endpoints %s %s %s", ip, port, busPort)
+	}
+
+	config["cluster-announce-port"] = "7000"
+	config["cluster-announce-bus-port"] = "17000"
+	_, port, _, err = a.GetAnnouncedEndpoints(context.Background(), fake.Addr())
+	if !IsAnnouncedEndpointError(err) {
+		t.Fatalf("expected an AnnouncedEndpointError, got %v", err)
+	}
+	if e := err.(AnnouncedEndpointError); e.NodeID != "A" || len(e.Mismatches) != 2 || port != "7000" {
+		t.Errorf("unexpected mismatches %v for announced port %s", e.Mismatches, port)
+	}
+}
+
+func TestAdminRunningGap(t *testing.T) {
+	var view string
+	fake := newFakeRedis(t, clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", "10.0.0.2:6379", "slave", "A"),
+		fakeNodeLine("C", "10.0.0.3:6379", "handshake", "-"),
+	)
+	a := newTestAdmin(fake.Addr())
+	pod := func(ip string) *corev1.Pod {
+		return &corev1.Pod{Status: corev1.PodStatus{PodIP: ip}}
+	}
+
+	pods, running, gap, err := a.RunningGap(context.Background(), []*corev1.Pod{pod(fake.IP()), pod("10.0.0.2"), pod("10.0.0.3")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pods != 3 || running != 2 || gap != 1 {
+		t.Errorf("RunningGap() = %d, %d, %d, want 3, 2, 1", pods, running, gap)
+	}
+}
+
+func TestAdminPingLatency(t *testing.T) {
+	var view string
+	slow := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "PING" {
+			time.Sleep(50 * time.Millisecond)
+			return fakeStatus("PONG")
+		}
+		return view
+	})
+	fast := newFakeRedis(t, func(args []string) interface{} { return fakeStatus("PONG") })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+	view = fakeClusterNodes(
+		fakeNodeLine("A", slow.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", fast.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", closed, "slave", "A"),
+	)
+	a := newTestAdmin(slow.Addr())
+
+	latencies, err := a.PingLatency(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l := latencies["A"]; l < 50*time.Millisecond {
+		t.Errorf("expected A latency over 50ms, got %s", l)
+	}
+	if l := latencies["B"]; l < 0 || l >= 50*time.Millisecond {
+		t.Errorf("expected B latency under 50ms, got %s", l)
+	}
+	if l := latencies["C"]; l != UnreachableLatency {
+		t.Errorf("expected C to be unreachable, got %s", l)
+	}
+}
+
+// knownNodesHandler returns a handler replying CLUSTER MYID, a CLUSTER NODES of the node alone and the
+// CLUSTER INFO with the number of known nodes
+func knownNodesHandler(id string, known int) fakeHandler {
+	view := fakeClusterNodes(fakeNodeLine(id, "127.0.0.1:6379", "myself,master", "-"))
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER MYID":
+			return id
+		case "CLUSTER NODES":
+			return view
+		case "CLUSTER INFO":
+			return clusterInfo("ok", 16384, 0, known, 3)
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminFindIsolatedNodes(t *testing.T) {
+	a1 := newFakeRedis(t, knownNodesHandler("A", 3))
+	b1 := newFakeRedis(t, knownNodesHandler("B", 3))
+	c1 := newFakeRedis(t, knownNodesHandler("C", 1))
+	a := newTestAdmin(a1.Addr())
+
+	isolated, err := a.FindIsolatedNodes(context.Background(), []string{a1.Addr(), b1.Addr(), c1.Addr(), "127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(isolated) != 1 || isolated[0].ID != "C" || isolated[0].IPPort() != c1.Addr() {
+		t.Errorf("expected only C to be isolated, got %v", isolated)
+	}
+}
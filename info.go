@@ -0,0 +1,386 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefragStats represents the active defragmentation related fields of INFO memory
+type DefragStats struct {
+	ActiveDefragRunning   int64
+	AllocatorFragRatio    float64
+	MemFragmentationRatio float64
+}
+
+// parseInfo decodes the INFO command output into a field/value map, section headers are ignored
+func parseInfo(raw string) map[string]string {
+	info := make(map[string]string)
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		values := strings.SplitN(line, ":", 2)
+		if len(values) < 2 {
+			continue
+		}
+		info[values[0]] = values[1]
+	}
+	return info
+}
+
+// infoInt returns the integer value of an INFO field
+func infoInt(info map[string]string, field string) (int64, error) {
+	value, ok := info[field]
+	if !ok {
+		return 0, fmt.Errorf("field %s not found in INFO", field)
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong format for INFO field %s: %v", field, err)
+	}
+	return i, nil
+}
+
+// infoFloat returns the float value of an INFO field
+func infoFloat(info map[string]string, field string) (float64, error) {
+	value, ok := info[field]
+	if !ok {
+		return 0, fmt.Errorf("field %s not found in INFO", field)
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong format for INFO field %s: %v", field, err)
+	}
+	return f, nil
+}
+
+// nodeInfo returns the decoded INFO sections of the node at addr, the default sections if none is provided
+func (a *Admin) nodeInfo(ctx context.Context, addr string, sections ...string) (map[string]string, error) {
+	c := a.nodeClient(addr)
+	defer c.Close()
+	raw, err := c.Info(ctx, sections...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get INFO %s from %s: %v", strings.Join(sections, " "), addr, err)
+	}
+	return parseInfo(raw), nil
+}
+
+// DecodeDefragStats decodes the active defragmentation stats from an INFO memory output
+func DecodeDefragStats(info map[string]string) (DefragStats, error) {
+	stats := DefragStats{}
+	var err error
+	if stats.ActiveDefragRunning, err = infoInt(info, "active_defrag_running"); err != nil {
+		return stats, err
+	}
+	if stats.AllocatorFragRatio, err = infoFloat(info, "allocator_frag_ratio"); err != nil {
+		return stats, err
+	}
+	if stats.MemFragmentationRatio, err = infoFloat(info, "mem_fragmentation_ratio"); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// DefragStats returns the active defragmentation stats of the node at addr
+func (a *Admin) DefragStats(ctx context.Context, addr string) (DefragStats, error) {
+	info, err := a.nodeInfo(ctx, addr, "memory")
+	if err != nil {
+		return DefragStats{}, err
+	}
+	return DecodeDefragStats(info)
+}
+
+// KeyspaceDB represents the keyspace stats of a database, from INFO keyspace
+type KeyspaceDB struct {
+	Keys    int64
+	Expires int64
+	AvgTTL  int64
+}
+
+// DecodeKeyspace decodes the INFO keyspace section, ex: db0:keys=1,expires=0,avg_ttl=0, into a map of
+// database index to keyspace stats
+func DecodeKeyspace(info map[string]string) (map[int]KeyspaceDB, error) {
+	keyspace := make(map[int]KeyspaceDB)
+	for field, value := range info {
+		if !strings.HasPrefix(field, "db") {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(field, "db"))
+		if err != nil {
+			continue
+		}
+		db := KeyspaceDB{}
+		for _, kv := range strings.Split(value, ",") {
+			values := strings.SplitN(kv, "=", 2)
+			if len(values) < 2 {
+				return nil, fmt.Errorf("wrong format for INFO keyspace %s: %s", field, value)
+			}
+			i, err := strconv.ParseInt(values[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("wrong format for INFO keyspace %s: %v", field, err)
+			}
+			switch values[0] {
+			case "keys":
+				db.Keys = i
+			case "expires":
+				db.Expires = i
+			case "avg_ttl":
+				db.AvgTTL = i
+			}
+		}
+		keyspace[index] = db
+	}
+	return keyspace, nil
+}
+
+// KeyspaceStats returns the keyspace stats of each database of the node at addr
+func (a *Admin) KeyspaceStats(ctx context.Context, addr string) (map[int]KeyspaceDB, error) {
+	info, err := a.nodeInfo(ctx, addr, "keyspace")
+	if err != nil {
+		return nil, err
+	}
+	return DecodeKeyspace(info)
+}
+
+// queryable returns true if the node can be sent commands: it has an address and isn't failing or in handshake
+func queryable(n *Node) bool {
+	return !n.HasStatus(NodeStatusFail) && !n.HasStatus(NodeStatusHandshake) && !n.HasStatus(NodeStatusNoAddr)
+}
+
+// PopulateMemory fills the UsedMemory and MaxMemory of the nodes from their INFO memory. The failing nodes,
+// and the ones in handshake or without address, are skipped. The nodes are all queried even if some fail,
+// the failures are returned in a NodesError keyed by address.
+func (a *Admin) PopulateMemory(ctx context.Context, nodes Nodes) error {
+	errs := make(map[string]error)
+	for _, node := range nodes.FilterByFunc(queryable) {
+		info, err := a.nodeInfo(ctx, node.IPPort(), "memory")
+		if err == nil {
+			node.UsedMemory, err = infoInt(info, "used_memory")
+		}
+		if err == nil {
+			node.MaxMemory, err = infoInt(info, "maxmemory")
+		}
+		if err != nil {
+			errs[node.IPPort()] = err
+		}
+	}
+	if len(errs) > 0 {
+		return NodesError{Errs: errs}
+	}
+	return nil
+}
+
+// MemoryPressure returns the used_memory/maxmemory ratio of each node keyed by node ID.
+// Nodes without maxmemory (unbounded) report 0. The nodes skipped or failing in PopulateMemory are left
+// out, the pressure of the other ones is returned along with the NodesError.
+func (a *Admin) MemoryPressure(ctx context.Context) (map[string]float64, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	err = a.PopulateMemory(ctx, *nodes)
+	if err != nil && !IsNodesError(err) {
+		return nil, err
+	}
+	failed := map[string]error{}
+	if err != nil {
+		failed = err.(NodesError).Errs
+	}
+	pressure := make(map[string]float64, len(*nodes))
+	for _, node := range nodes.FilterByFunc(queryable) {
+		if _, ok := failed[node.IPPort()]; ok {
+			continue
+		}
+		pressure[node.ID] = 0
+		if node.MaxMemory > 0 {
+			pressure[node.ID] = float64(node.UsedMemory) / float64(node.MaxMemory)
+		}
+	}
+	return pressure, err
+}
+
+// PopulateServerStartTimes fills the ServerStartTime of the nodes from the uptime in their INFO server
+func (a *Admin) PopulateServerStartTimes(ctx context.Context, nodes Nodes) error {
+	for _, node := range nodes {
+		info, err := a.nodeInfo(ctx, node.IPPort(), "server")
+		if err != nil {
+			return err
+		}
+		uptime, err := infoInt(info, "uptime_in_seconds")
+		if err != nil {
+			return err
+		}
+		node.ServerStartTime = time.Now().Add(-time.Duration(uptime) * time.Second)
+	}
+	return nil
+}
+
+// NodeLoadStats represents the load related fields of INFO stats and cpu
+type NodeLoadStats struct {
+	InstantaneousOpsPerSec int64
+	UsedCPUSys             float64
+	UsedCPUUser            float64
+	TotalCommandsProcessed int64
+}
+
+// DecodeNodeLoadStats decodes the load stats from an INFO output including the stats and cpu sections
+func DecodeNodeLoadStats(info map[string]string) (NodeLoadStats, error) {
+	stats := NodeLoadStats{}
+	var err error
+	if stats.InstantaneousOpsPerSec, err = infoInt(info, "instantaneous_ops_per_sec"); err != nil {
+		return stats, err
+	}
+	if stats.UsedCPUSys, err = infoFloat(info, "used_cpu_sys"); err != nil {
+		return stats, err
+	}
+	if stats.UsedCPUUser, err = infoFloat(info, "used_cpu_user"); err != nil {
+		return stats, err
+	}
+	if stats.TotalCommandsProcessed, err = infoInt(info, "total_commands_processed"); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// NodeLoad returns the load stats of every master, keyed by master ID
+func (a *Admin) NodeLoad(ctx context.Context) (map[string]NodeLoadStats, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	loads := map[string]NodeLoadStats{}
+	for _, master := range nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole }) {
+		info, err := a.nodeInfo(ctx, master.IPPort())
+		if err != nil {
+			return nil, err
+		}
+		if loads[master.ID], err = DecodeNodeLoadStats(info); err != nil {
+			return nil, fmt.Errorf("unable to decode load of master %s: %v", master.ID, err)
+		}
+	}
+	return loads, nil
+}
+
+// CommandStat represents the stats of a command, from INFO commandstats
+type CommandStat struct {
+	Calls         int64
+	Usec          int64
+	UsecPerCall   float64
+	RejectedCalls int64
+	FailedCalls   int64
+}
+
+// DecodeCommandStats decodes the INFO commandstats section, ex: cmdstat_get:calls=2,usec=10,usec_per_call=5.00,
+// into a map of lower case command name to command stats. The rejected and failed calls are only reported
+// since redis 6.2.
+func DecodeCommandStats(info map[string]string) (map[string]CommandStat, error) {
+	stats := make(map[string]CommandStat)
+	for field, value := range info {
+		if !strings.HasPrefix(field, "cmdstat_") {
+			continue
+		}
+		stat := CommandStat{}
+		for _, kv := range strings.Split(value, ",") {
+			values := strings.SplitN(kv, "=", 2)
+			if len(values) < 2 {
+				return nil, fmt.Errorf("wrong format for INFO commandstats %s: %s", field, value)
+			}
+			var err error
+			switch values[0] {
+			case "calls":
+				stat.Calls, err = strconv.ParseInt(values[1], 10, 64)
+			case "usec":
+				stat.Usec, err = strconv.ParseInt(values[1], 10, 64)
+			case "usec_per_call":
+				stat.UsecPerCall, err = strconv.ParseFloat(values[1], 64)
+			case "rejected_calls":
+				stat.RejectedCalls, err = strconv.ParseInt(values[1], 10, 64)
+			case "failed_calls":
+				stat.FailedCalls, err = strconv.ParseInt(values[1], 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("wrong format for INFO commandstats %s: %v", field, err)
+			}
+		}
+		stats[strings.ToLower(strings.TrimPrefix(field, "cmdstat_"))] = stat
+	}
+	return stats, nil
+}
+
+// CommandStats returns the stats of each command run on the node at addr, keyed by command name
+func (a *Admin) CommandStats(ctx context.Context, addr string) (map[string]CommandStat, error) {
+	info, err := a.nodeInfo(ctx, addr, "commandstats")
+	if err != nil {
+		return nil, err
+	}
+	return DecodeCommandStats(info)
+}
+
+// PersistenceLatency represents the persistence fields of INFO stats and persistence impacting latency
+type PersistenceLatency struct {
+	// LatestForkUsec duration of the latest fork, the main thread is blocked meanwhile
+	LatestForkUsec  int64
+	RDBLastSaveTime time.Time
+	// RDBLastBgsaveStatus ok or err
+	RDBLastBgsaveStatus string
+	// RDBLastCOWSize bytes copied on write during the last RDB save
+	RDBLastCOWSize int64
+}
+
+// DecodePersistenceLatency decodes the persistence latency fields from an INFO output including the stats and
+// persistence sections
+func DecodePersistenceLatency(info map[string]string) (PersistenceLatency, error) {
+	latency := PersistenceLatency{}
+	var err error
+	if latency.LatestForkUsec, err = infoInt(info, "latest_fork_usec"); err != nil {
+		return latency, err
+	}
+	lastSave, err := infoInt(info, "rdb_last_save_time")
+	if err != nil {
+		return latency, err
+	}
+	latency.RDBLastSaveTime = time.Unix(lastSave, 0)
+	var ok bool
+	if latency.RDBLastBgsaveStatus, ok = info["rdb_last_bgsave_status"]; !ok {
+		return latency, fmt.Errorf("field rdb_last_bgsave_status not found in INFO")
+	}
+	if latency.RDBLastCOWSize, err = infoInt(info, "rdb_last_cow_size"); err != nil {
+		return latency, err
+	}
+	return latency, nil
+}
+
+// PersistenceLatency returns the fork and RDB save stats of the node at addr
+func (a *Admin) PersistenceLatency(ctx context.Context, addr string) (PersistenceLatency, error) {
+	info, err := a.nodeInfo(ctx, addr, "stats")
+	if err != nil {
+		return PersistenceLatency{}, err
+	}
+	persistence, err := a.nodeInfo(ctx, addr, "persistence")
+	if err != nil {
+		return PersistenceLatency{}, err
+	}
+	for field, value := range persistence {
+		info[field] = value
+	}
+	return DecodePersistenceLatency(info)
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const infoMemory = "# Memory\r\n" +
+	"used_memory:1048576\r\n" +
+	"used_memory_human:1.00M\r\n" +
+	"used_memory_rss:2621440\r\n" +
+	"used_memory_peak:2097152\r\n" +
+	"maxmemory:2097152\r\n" +
+	"maxmemory_human:2.00M\r\n" +
+	"maxmemory_policy:noeviction\r\n" +
+	"allocator_allocated:1100000\r\n" +
+	"allocator_active:1430000\r\n" +
+	"allocator_frag_ratio:1.30\r\n" +
+	"allocator_frag_bytes:330000\r\n" +
+	"mem_fragmentation_ratio:2.50\r\n" +
+	"mem_fragmentation_bytes:1572864\r\n" +
+	"mem_allocator:jemalloc-5.1.0\r\n" +
+	"active_defrag_running:0\r\n" +
+	"lazyfree_pending_objects:0\r\n"
+
+func TestParseInfo(t *testing.T) {
+	info := parseInfo("# Server\r\nredis_version:6.2.1\r\n\r\n# Clients\r\nconnected_clients:3\r\n")
+	want := map[string]string{"redis_version": "6.2.1", "connected_clients": "3"}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("parseInfo() = %v, want %v", info, want)
+	}
+}
+
+func TestDecodeDefragStats(t *testing.T) {
+	stats, err := DecodeDefragStats(parseInfo(infoMemory))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := DefragStats{ActiveDefragRunning: 0, AllocatorFragRatio: 1.3, MemFragmentationRatio: 2.5}
+	if stats != want {
+		t.Errorf("DecodeDefragStats() = %+v, want %+v", stats, want)
+	}
+
+	if _, err := DecodeDefragStats(parseInfo(strings.Replace(infoMemory, "allocator_frag_ratio:1.30", "allocator_frag_ratio:bad", 1))); err == nil {
+		t.Errorf("expected an error for a malformed ratio")
+	}
+}
+
+func TestAdminDefragStats(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "INFO" && len(args) > 1 && args[1] == "memory" {
+			return strings.Replace(infoMemory, "active_defrag_running:0", "active_defrag_running:25", 1)
+		}
+		return fakeError("ERR unexpected command")
+	})
+	a := newTestAdmin(fake.Addr())
+
+	stats, err := a.DefragStats(context.Background(), fake.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.ActiveDefragRunning != 25 {
+		t.Errorf("expected active_defrag_running 25, got %d", stats.ActiveDefragRunning)
+	}
+}
+
+func TestAdminKeyspaceStats(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "INFO" && len(args) > 1 && args[1] == "keyspace" {
+			return "# Keyspace\r\ndb0:keys=1500,expires=300,avg_ttl=86400000\r\ndb3:keys=2,expires=0,avg_ttl=0\r\n"
+		}
+		return fakeError("ERR unexpected command")
+	})
+	a := newTestAdmin(fake.Addr())
+
+	keyspace, err := a.KeyspaceStats(context.Background(), fake.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[int]KeyspaceDB{
+		0: {Keys: 1500, Expires: 300, AvgTTL: 86400000},
+		3: {Keys: 2, Expires: 0, AvgTTL: 0},
+	}
+	if !reflect.DeepEqual(keyspace, want) {
+		t.Errorf("KeyspaceStats() = %v, want %v", keyspace, want)
+	}
+
+	if _, err := DecodeKeyspace(parseInfo("db0:keys=a,expires=0,avg_ttl=0")); err == nil {
+		t.Errorf("expected an error for a malformed keyspace")
+	}
+}
+
+// memoryHandler returns a handler replying CLUSTER NODES and an INFO memory with the provided values
+func memoryHandler(view *string, used, max int64) fakeHandler {
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return *view
+		case "INFO":
+			return fmt.Sprintf("# Memory\r\nused_memory:%d\r\nmaxmemory:%d\r\nmaxmemory_policy:allkeys-lru\r\n", used, max)
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminMemoryPressure(t *testing.T) {
+	var view string
+	half := newFakeRedis(t, memoryHandler(&view, 512, 1024))
+	full := newFakeRedis(t, memoryHandler(&view, 950, 1000))
+	unbounded := newFakeRedis(t, memoryHandler(&view, 4096, 0))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", half.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", full.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", unbounded.Addr(), "slave", "A"),
+	)
+	a := newTestAdmin(half.Addr())
+
+	pressure, err := a.MemoryPressure(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]float64{"A": 0.5, "B": 0.95, "C": 0}
+	if !reflect.DeepEqual(pressure, want) {
+		t.Errorf("MemoryPressure() = %v, want %v", pressure, want)
+	}
+
+	// a failed node is skipped, an unreachable one reported without hiding the others
+	downAddr := "127.0.0.1:1"
+	view = fakeClusterNodes(
+		fakeNodeLine("A", half.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", full.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", downAddr, "slave", "A"),
+		fakeNodeLine("D", "10.0.0.4:6379", "slave,fail", "B"),
+	)
+	pressure, err = a.MemoryPressure(context.Background())
+	if !IsNodesError(err) || len(err.(NodesError).Errs) != 1 || err.(NodesError).Errs[downAddr] == nil {
+		t.Fatalf("expected a NodesError for the unreachable node only, got %v", err)
+	}
+	if want := map[string]float64{"A": 0.5, "B": 0.95}; !reflect.DeepEqual(pressure, want) {
+		t.Errorf("MemoryPressure() = %v, want %v", pressure, want)
+	}
+}
+
+func TestAdminPopulateServerStartTimes(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		return "# Server\r\nredis_version:6.2.1\r\nuptime_in_seconds:3600\r\nuptime_in_days:0\r\n"
+	})
+	a := newTestAdmin(fake.Addr())
+	nodes := Nodes{{ID: "A", IP: fake.IP(), Port: fake.Port()}}
+
+	if err := a.PopulateServerStartTimes(context.Background(), nodes); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if started := time.Since(nodes[0].ServerStartTime); started < time.Hour || started > time.Hour+time.Minute {
+		t.Errorf("expected the node to be started an hour ago, got %s", started)
+	}
+}
+
+// infoLoad INFO stats and cpu sections of a loaded node
+const infoLoad = "# Stats\r\n" +
+	"total_connections_received:1563\r\n" +
+	"total_commands_processed:48273615\r\n" +
+	"instantaneous_ops_per_sec:8421\r\n" +
+	"total_net_input_bytes:3721980134\r\n" +
+	"total_net_output_bytes:912373622\r\n" +
+	"instantaneous_input_kbps:512.34\r\n" +
+	"instantaneous_output_kbps:128.90\r\n" +
+	"rejected_connections:0\r\n" +
+	"expired_keys:1203\r\n" +
+	"evicted_keys:0\r\n" +
+	"keyspace_hits:2371234\r\n" +
+	"keyspace_misses:12034\r\n" +
+	"\r\n" +
+	"# CPU\r\n" +
+	"used_cpu_sys:1843.271330\r\n" +
+	"used_cpu_user:2291.873421\r\n" +
+	"used_cpu_sys_children:0.004211\r\n" +
+	"used_cpu_user_children:0.001932\r\n"
+
+func TestAdminNodeLoad(t *testing.T) {
+	var view string
+	loaded := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "CLUSTER NODES" {
+			return view
+		}
+		return infoLoad
+	})
+	view = fakeClusterNodes(
+		fakeNodeLine("A", loaded.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", "10.0.0.2:6379", "slave", "A"),
+	)
+	a := newTestAdmin(loaded.Addr())
+
+	loads, err := a.NodeLoad(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]NodeLoadStats{"A": {
+		InstantaneousOpsPerSec: 8421,
+		UsedCPUSys:             1843.27133,
+		UsedCPUUser:            2291.873421,
+		TotalCommandsProcessed: 48273615,
+	}}
+	if !reflect.DeepEqual(loads, want) {
+		t.Errorf("NodeLoad() = %+v, want %+v", loads, want)
+	}
+}
+
+func TestAdminCommandStats(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "INFO" && len(args) > 1 && args[1] == "commandstats" {
+			return "# Commandstats\r\n" +
+				"cmdstat_get:calls=21534,usec=43068,usec_per_call=2.00,rejected_calls=0,failed_calls=0\r\n" +
+				"cmdstat_set:calls=8012,usec=32048,usec_per_call=4.00,rejected_calls=12,failed_calls=3\r\n" +
+				"cmdstat_cluster|nodes:calls=5,usec=250,usec_per_call=50.00\r\n"
+		}
+		return fakeError("ERR unexpected command")
+	})
+	a := newTestAdmin(fake.Addr())
+
+	stats, err := a.CommandStats(context.Background(), fake.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]CommandStat{
+		"get":           {Calls: 21534, Usec: 43068, UsecPerCall: 2},
+		"set":           {Calls: 8012, Usec: 32048, UsecPerCall: 4, RejectedCalls: 12, FailedCalls: 3},
+		"cluster|nodes": {Calls: 5, Usec: 250, UsecPerCall: 50},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("CommandStats() = %+v, want %+v", stats, want)
+	}
+
+	stats, err = DecodeCommandStats(parseInfo("cmdstat_HGETALL:calls=7,usec=70,usec_per_call=10.00"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := stats["hgetall"]; !ok || len(stats) != 1 {
+		t.Errorf("expected the command name in lower case, got %+v", stats)
+	}
+
+	if _, err := DecodeCommandStats(parseInfo("cmdstat_get:calls=a,usec=1,usec_per_call=1.00")); err == nil {
+		t.Errorf("expected an error for malformed command stats")
+	}
+}
+
+func TestAdminPersistenceLatency(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) != "INFO" || len(args) < 2 {
+			return fakeError("ERR unexpected command")
+		}
+		switch args[1] {
+		case "stats":
+			return "# Stats\r\ntotal_connections_received:1563\r\nlatest_fork_usec:48213\r\nmigrate_cached_sockets:0\r\n"
+		case "persistence":
+			return "# Persistence\r\n" +
+				"loading:0\r\n" +
+				"rdb_changes_since_last_save:1024\r\n" +
+				"rdb_bgsave_in_progress:0\r\n" +
+				"rdb_last_save_time:1617183600\r\n" +
+				"rdb_last_bgsave_status:err\r\n" +
+				"rdb_last_bgsave_time_sec:3\r\n" +
+				"rdb_current_bgsave_time_sec:-1\r\n" +
+				"rdb_last_cow_size:6291456\r\n" +
+				"aof_enabled:0\r\n"
+		}
+		return ""
+	})
+	a := newTestAdmin(fake.Addr())
+
+	latency, err := a.PersistenceLatency(context.Background(), fake.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := PersistenceLatency{
+		LatestForkUsec:      48213,
+		RDBLastSaveTime:     time.Unix(1617183600, 0),
+		RDBLastBgsaveStatus: "err",
+		RDBLastCOWSize:      6291456,
+	}
+	if latency != want {
+		t.Errorf("PersistenceLatency() = %+v, want %+v", latency, want)
+	}
+
+	if _, err := DecodePersistenceLatency(parseInfo("latest_fork_usec:1\r\nrdb_last_save_time:1\r\n")); err == nil {
+		t.Errorf("expected an error for missing persistence fields")
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// countKeysPipelineSize max number of COUNTKEYSINSLOT commands sent in one pipeline
+const countKeysPipelineSize = 1000
+
+// countKeysInSlots returns the number of keys of each slot, counted on the node the client is connected to
+func countKeysInSlots(ctx context.Context, c *redis.Client, slots []Slot) (map[Slot]int64, error) {
+	counts := make(map[Slot]int64, len(slots))
+	for start := 0; start < len(slots); start += countKeysPipelineSize {
+		end := start + countKeysPipelineSize
+		if end > len(slots) {
+			end = len(slots)
+		}
+		batch := slots[start:end]
+		cmds := make([]*redis.IntCmd, len(batch))
+		if _, err := c.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for i, slot := range batch {
+				cmds[i] = p.ClusterCountKeysInSlot(ctx, int(slot))
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		for i, slot := range batch {
+			counts[slot] = cmds[i].Val()
+		}
+	}
+	return counts, nil
+}
+
+// CountKeysInSlot returns the number of keys of the slot stored by the node at addr, with COUNTKEYSINSLOT.
+// An error is returned without contacting the node if the slot is out of range.
+func (a *Admin) CountKeysInSlot(ctx context.Context, addr string, slot Slot) (int64, error) {
+	if slot > a.hashMaxSlots {
+		return 0, fmt.Errorf("slot %s out of range 0-%s", slot, a.hashMaxSlots)
+	}
+	c := a.nodeClient(addr)
+	defer c.Close()
+	count, err := c.ClusterCountKeysInSlot(ctx, int(slot)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("unable to count keys in slot %s of node %s: %v", slot, addr, err)
+	}
+	return count, nil
+}
+
+// GetKeysInSlot returns up to count keys of the slot stored by the node at addr, with GETKEYSINSLOT. Calling it
+// again while migrating the returned keys walks the whole slot by batches of count keys.
+func (a *Admin) GetKeysInSlot(ctx context.Context, addr string, slot Slot, count int) ([]string, error) {
+	if slot > a.hashMaxSlots {
+		return nil, fmt.Errorf("slot %s out of range 0-%s", slot, a.hashMaxSlots)
+	}
+	if count < 1 {
+		return nil, fmt.Errorf("invalid keys count %d, must be positive", count)
+	}
+	c := a.nodeClient(addr)
+	defer c.Close()
+	keys, err := c.ClusterGetKeysInSlot(ctx, int(slot), count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get keys in slot %s of node %s: %v", slot, addr, err)
+	}
+	return keys, nil
+}
+
+// SlotKeyHistogram returns the number of keys of every assigned slot, counted on its master.
+// Unassigned slots are not present in the returned map.
+func (a *Admin) SlotKeyHistogram(ctx context.Context) (map[Slot]int64, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+
+	histogram := make(map[Slot]int64)
+	for _, master := range nodes.FilterByFunc(IsMasterWithSlot) {
+		c := a.nodeClient(master.IPPort())
+		counts, err := countKeysInSlots(ctx, c, master.Slots)
+		c.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to count keys in slots of master %s: %v", master.ID, err)
+		}
+		for slot, count := range counts {
+			histogram[slot] = count
+		}
+	}
+	return histogram, nil
+}
+
+// ExpiringKeys SCANs up to sample keys of the node at addr and returns how many have a TTL and how many
+// are persistent, along with the nearest expiry among them (0 if none expires). Many keys expiring
+// around the same time announce an expiry storm and its latency spikes.
+func (a *Admin) ExpiringKeys(ctx context.Context, addr string, sample int64) (withTTL, withoutTTL int64, nearest time.Duration, err error) {
+	c := a.nodeClient(addr)
+	defer c.Close()
+
+	keys := []string{}
+	var cursor uint64
+	for int64(len(keys)) < sample {
+		batch, next, err := c.Scan(ctx, cursor, "", sample-int64(len(keys))).Result()
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("unable to scan keys of node %s: %v", addr, err)
+		}
+		keys = append(keys, batch...)
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if int64(len(keys)) > sample {
+		keys = keys[:sample]
+	}
+
+	cmds := make([]*redis.DurationCmd, len(keys))
+	if _, err := c.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = p.PTTL(ctx, key)
+		}
+		return nil
+	}); err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to get TTL of keys of node %s: %v", addr, err)
+	}
+	for _, cmd := range cmds {
+		ttl := cmd.Val()
+		switch {
+		case ttl == -1:
+			withoutTTL++
+		case ttl >= 0:
+			withTTL++
+			if nearest == 0 || ttl < nearest {
+				nearest = ttl
+			}
+		}
+	}
+	return withTTL, withoutTTL, nearest, nil
+}
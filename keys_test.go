@@ -0,0 +1,135 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// keysHandler returns a handler replying CLUSTER NODES and the per slot key counts
+func keysHandler(view *string, counts map[Slot]int64) fakeHandler {
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return *view
+		case "CLUSTER COUNTKEYSINSLOT":
+			slot, _ := strconv.Atoi(args[2])
+			return counts[Slot(slot)]
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminSlotKeyHistogram(t *testing.T) {
+	var view string
+	m1 := newFakeRedis(t, keysHandler(&view, map[Slot]int64{0: 10, 1: 0, 2: 5}))
+	m2 := newFakeRedis(t, keysHandler(&view, map[Slot]int64{3: 7, 4: 1}))
+	view = fakeClusterNodes(
+		fakeNodeLine("M1", m1.Addr(), "myself,master", "-", "0-2"),
+		fakeNodeLine("M2", m2.Addr(), "master", "-", "3-4"),
+	)
+	a := newTestAdmin(m1.Addr())
+
+	histogram, err := a.SlotKeyHistogram(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[Slot]int64{0: 10, 1: 0, 2: 5, 3: 7, 4: 1}
+	if !reflect.DeepEqual(histogram, want) {
+		t.Errorf("SlotKeyHistogram() = %v, want %v", histogram, want)
+	}
+	if len(m1.Calls("CLUSTER COUNTKEYSINSLOT")) != 3 || len(m2.Calls("CLUSTER COUNTKEYSINSLOT")) != 2 {
+		t.Errorf("expected only owned slots to be counted on each master")
+	}
+}
+
+func TestAdminCountKeysInSlot(t *testing.T) {
+	var view string
+	fake := newFakeRedis(t, keysHandler(&view, map[Slot]int64{42: 1234}))
+	a := newTestAdmin(fake.Addr())
+
+	count, err := a.CountKeysInSlot(context.Background(), fake.Addr(), 42)
+	if err != nil || count != 1234 {
+		t.Errorf("expected 1234 keys in slot 42, got %d, %v", count, err)
+	}
+	if _, err := a.CountKeysInSlot(context.Background(), fake.Addr(), 16384); err == nil {
+		t.Errorf("expected an error for a slot out of range")
+	}
+	if calls := fake.Calls("CLUSTER COUNTKEYSINSLOT"); len(calls) != 1 {
+		t.Errorf("expected a single COUNTKEYSINSLOT, got %v", calls)
+	}
+}
+
+func TestAdminGetKeysInSlot(t *testing.T) {
+	fake := newFakeRedis(t, slotKeysHandler(10, 100))
+	a := newTestAdmin(fake.Addr())
+	ctx := context.Background()
+
+	keys, err := a.GetKeysInSlot(ctx, fake.Addr(), 42, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 10 {
+		t.Errorf("expected the keys served by the node, got %v", keys)
+	}
+	if calls := fake.Calls("CLUSTER GETKEYSINSLOT"); len(calls) != 1 || calls[0][2] != "42" || calls[0][3] != "5" {
+		t.Errorf("expected CLUSTER GETKEYSINSLOT 42 5, got %v", calls)
+	}
+
+	if _, err := a.GetKeysInSlot(ctx, fake.Addr(), 16384, 5); err == nil {
+		t.Errorf("expected an error for a slot out of range")
+	}
+	if _, err := a.GetKeysInSlot(ctx, fake.Addr(), 42, 0); err == nil {
+		t.Errorf("expected an error for a null count")
+	}
+}
+
+func TestAdminExpiringKeys(t *testing.T) {
+	ttls := map[string]int64{"session:1": 30000, "session:2": 5000, "user:1": -1, "user:2": -1, "user:3": -1, "gone": -2}
+	pages := [][]string{{"session:1", "user:1", "gone"}, {"session:2", "user:2", "user:3"}}
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "SCAN":
+			page, _ := strconv.Atoi(args[1])
+			next := "0"
+			if page+1 < len(pages) {
+				next = strconv.Itoa(page + 1)
+			}
+			return []interface{}{next, pages[page]}
+		case "PTTL":
+			return ttls[args[1]]
+		}
+		return fakeError("ERR unexpected command")
+	})
+	a := newTestAdmin(fake.Addr())
+
+	withTTL, withoutTTL, nearest, err := a.ExpiringKeys(context.Background(), fake.Addr(), 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if withTTL != 2 || withoutTTL != 3 || nearest != 5*time.Second {
+		t.Errorf("ExpiringKeys() = %d, %d, %s, want 2, 3, 5s", withTTL, withoutTTL, nearest)
+	}
+
+	withTTL, withoutTTL, _, err = a.ExpiringKeys(context.Background(), fake.Addr(), 2)
+	if err != nil || withTTL+withoutTTL != 2 {
+		t.Errorf("expected the sample to be bounded to 2 keys, got %d, %d, %v", withTTL, withoutTTL, err)
+	}
+}
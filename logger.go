@@ -0,0 +1,74 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// Logger logs the messages of the package, see SetLogger. The default Logger writes them with klog.
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	// V returns the logger of the messages of the verbosity level, discarding them if the level isn't enabled
+	V(level int) InfoLogger
+}
+
+// InfoLogger logs the messages of a verbosity level
+type InfoLogger interface {
+	Infof(format string, args ...interface{})
+}
+
+// logger Logger used by the package
+var logger Logger = klogLogger{}
+
+// SetLogger replaces the Logger of the package, ex: to route its messages to a structured logger. A nil
+// Logger restores the default klog one. It isn't safe for concurrent use and should be called before
+// using the package.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = klogLogger{}
+	}
+	logger = l
+}
+
+// klogLogger Logger writing with klog, reporting the caller of the package Logger as the message origin
+type klogLogger struct{}
+
+func (klogLogger) Infof(format string, args ...interface{}) {
+	klog.InfoDepth(1, fmt.Sprintf(format, args...))
+}
+
+func (klogLogger) Errorf(format string, args ...interface{}) {
+	klog.ErrorDepth(1, fmt.Sprintf(format, args...))
+}
+
+func (klogLogger) V(level int) InfoLogger {
+	return klogInfoLogger{enabled: klog.V(klog.Level(level)).Enabled()}
+}
+
+// klogInfoLogger InfoLogger writing with klog when its verbosity level is enabled
+type klogInfoLogger struct {
+	enabled bool
+}
+
+func (l klogInfoLogger) Infof(format string, args ...interface{}) {
+	if l.enabled {
+		klog.InfoDepth(1, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recordLogger Logger recording the messages, prefixed by their level
+type recordLogger struct {
+	verbosity int
+	messages  []string
+}
+
+func (l *recordLogger) Infof(format string, args ...interface{}) {
+	l.messages = append(l.messages, "info: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Errorf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "error: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) V(level int) InfoLogger {
+	if level > l.verbosity {
+		return discardLogger{}
+	}
+	return l
+}
+
+// discardLogger InfoLogger discarding the messages
+type discardLogger struct{}
+
+func (discardLogger) Infof(format string, args ...interface{}) {}
+
+func TestSetLogger(t *testing.T) {
+	recorder := &recordLogger{verbosity: 2}
+	SetLogger(recorder)
+	defer SetLogger(nil)
+
+	input := "abcd 1.2.3.4 myself,master - 0 0 1 connected 0-100\n" +
+		"short line\n"
+	DecodeNodeInfos(&input)
+	if len(recorder.messages) != 1 || !strings.HasPrefix(recorder.messages[0], "error: Error while decoding node info for node 'abcd'") {
+		t.Errorf("expected only the decoding error to be logged at the enabled verbosity, got %v", recorder.messages)
+	}
+
+	SetLogger(nil)
+	if _, ok := logger.(klogLogger); !ok {
+		t.Errorf("expected a nil Logger to restore the klog one, got %T", logger)
+	}
+}
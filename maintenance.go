@@ -0,0 +1,43 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// PauseClients suspends the clients of every master for the duration with CLIENT PAUSE, only the write
+// commands if writeOnly is set (Redis 6.2+), all the commands otherwise. It gives a consistent view of
+// the cluster while applying changes; the per-master failures are returned in a NodesError.
+func (a *Admin) PauseClients(ctx context.Context, duration time.Duration, writeOnly bool) error {
+	mode := "ALL"
+	if writeOnly {
+		mode = "WRITE"
+	}
+	return a.forEachMaster(ctx, func(ctx context.Context, c *redis.Client, node *Node) error {
+		return c.Do(ctx, "CLIENT", "PAUSE", duration.Milliseconds(), mode).Err()
+	})
+}
+
+// UnpauseClients resumes the clients of every master paused by PauseClients with CLIENT UNPAUSE
+func (a *Admin) UnpauseClients(ctx context.Context) error {
+	return a.forEachMaster(ctx, func(ctx context.Context, c *redis.Client, node *Node) error {
+		return c.Do(ctx, "CLIENT", "UNPAUSE").Err()
+	})
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAdminPauseClients(t *testing.T) {
+	var view string
+	m1 := newFakeRedis(t, clusterNodesHandler(&view))
+	m2 := newFakeRedis(t, clusterNodesHandler(&view))
+	slave := newFakeRedis(t, clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", m1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", m2.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", slave.Addr(), "slave", "A"),
+	)
+	a := newTestAdmin(m1.Addr())
+
+	if err := a.PauseClients(context.Background(), 1500*time.Millisecond, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := a.PauseClients(context.Background(), time.Second, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, fake := range []*fakeRedis{m1, m2} {
+		calls := fake.Calls("CLIENT PAUSE")
+		want := [][]string{{"CLIENT", "PAUSE", "1500", "WRITE"}, {"CLIENT", "PAUSE", "1000", "ALL"}}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("CLIENT PAUSE calls on %s = %v, want %v", fake.Addr(), calls, want)
+		}
+	}
+	if len(slave.Calls("CLIENT PAUSE")) != 0 {
+		t.Errorf("expected the slave clients not to be paused")
+	}
+
+	if err := a.UnpauseClients(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m1.Calls("CLIENT UNPAUSE")) != 1 || len(m2.Calls("CLIENT UNPAUSE")) != 1 {
+		t.Errorf("expected CLIENT UNPAUSE on every master")
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// writeMetric writes a gauge in the Prometheus exposition format
+func writeMetric(b *strings.Builder, name, help string, samples ...string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, sample := range samples {
+		fmt.Fprintf(b, "%s%s\n", name, sample)
+	}
+}
+
+// renderMetrics returns the Prometheus exposition format metrics of the cluster infos and nodes, sorted by node ID
+func renderMetrics(infos map[string]string, nodes Nodes) string {
+	sorted := make(Nodes, len(nodes))
+	copy(sorted, nodes)
+	sort.Sort(sorted)
+
+	b := &strings.Builder{}
+	state := 0
+	if infos["cluster_state"] == "ok" {
+		state = 1
+	}
+	writeMetric(b, "redis_cluster_state", "Whether the cluster state is ok (1) or not (0).", fmt.Sprintf(" %d", state))
+	slots := infos["cluster_slots_assigned"]
+	if slots == "" {
+		slots = "0"
+	}
+	writeMetric(b, "redis_cluster_slots_assigned", "Number of slots assigned to a master.", " "+slots)
+
+	masters := sorted.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole })
+	writeMetric(b, "redis_cluster_masters", "Number of master nodes.", fmt.Sprintf(" %d", len(masters)))
+
+	replicas := []string{}
+	for _, master := range masters {
+		count := sorted.CountByFunc(func(n *Node) bool { return n.MasterReferent == master.ID })
+		replicas = append(replicas, fmt.Sprintf("{master=%q} %d", master.ID, count))
+	}
+	writeMetric(b, "redis_cluster_replicas_per_master", "Number of replicas of each master.", replicas...)
+
+	connected := []string{}
+	for _, node := range sorted {
+		value := 0
+		if node.LinkState == RedisLinkStateConnected {
+			value = 1
+		}
+		connected = append(connected, fmt.Sprintf("{node=%q,addr=%q,role=%q} %d", node.ID, node.IPPort(), node.GetRole(), value))
+	}
+	writeMetric(b, "redis_cluster_node_connected", "Whether the node link is connected (1) or not (0).", connected...)
+
+	return b.String()
+}
+
+// MetricsText returns the cluster metrics in the Prometheus exposition format: cluster state, slots assigned,
+// number of masters, replicas per master and the link status of each node. It can back a /metrics endpoint
+// without depending on a Prometheus client library.
+func (a *Admin) MetricsText(ctx context.Context) (string, error) {
+	infos, err := getClusterInfos(ctx, a.rc)
+	if err != nil {
+		return "", err
+	}
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return "", err
+	}
+	return renderMetrics(*infos, *nodes), nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"testing"
+)
+
+const metricsGolden = `# HELP redis_cluster_state Whether the cluster state is ok (1) or not (0).
+# TYPE redis_cluster_state gauge
+redis_cluster_state 1
+# HELP redis_cluster_slots_assigned Number of slots assigned to a master.
+# TYPE redis_cluster_slots_assigned gauge
+redis_cluster_slots_assigned 16384
+# HELP redis_cluster_masters Number of master nodes.
+# TYPE redis_cluster_masters gauge
+redis_cluster_masters 2
+# HELP redis_cluster_replicas_per_master Number of replicas of each master.
+# TYPE redis_cluster_replicas_per_master gauge
+redis_cluster_replicas_per_master{master="A"} 2
+redis_cluster_replicas_per_master{master="B"} 0
+# HELP redis_cluster_node_connected Whether the node link is connected (1) or not (0).
+# TYPE redis_cluster_node_connected gauge
+redis_cluster_node_connected{node="A",addr="10.0.0.1:6379",role="master"} 1
+redis_cluster_node_connected{node="B",addr="10.0.0.2:6379",role="master"} 1
+redis_cluster_node_connected{node="C",addr="10.0.0.3:6379",role="slave"} 1
+redis_cluster_node_connected{node="D",addr="10.0.0.4:6379",role="slave"} 0
+`
+
+func TestAdminMetricsText(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER INFO":
+			return clusterInfo("ok", 16384, 0, 4, 2)
+		case "CLUSTER NODES":
+			return fakeClusterNodes(
+				"D 10.0.0.4:6379@16379 slave A 0 0 1 disconnected",
+				"B 10.0.0.2:6379@16379 master - 0 0 2 connected 8192-16383",
+				"A 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-8191",
+				"C 10.0.0.3:6379@16379 slave A 0 0 1 connected",
+			)
+		}
+		return fakeError("ERR unexpected command")
+	})
+	a := newTestAdmin(fake.Addr())
+
+	metrics, err := a.MetricsText(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if metrics != metricsGolden {
+		t.Errorf("MetricsText() =\n%s\nwant\n%s", metrics, metricsGolden)
+	}
+}
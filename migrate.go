@@ -0,0 +1,431 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+const (
+	// migrateKeysBatchSize max number of keys moved by a single MIGRATE command
+	migrateKeysBatchSize = 100
+	// migrateTimeout timeout of a single MIGRATE command
+	migrateTimeout = 10 * time.Second
+	// memoryUsageSamples number of keys whose MEMORY USAGE is sampled to estimate a batch size
+	memoryUsageSamples = 5
+	// migrateRetries number of times a MIGRATE failing with a transient error is retried
+	migrateRetries = 3
+	// migrateRollbackTimeout timeout of the rollback of a failed or canceled slot migration
+	migrateRollbackTimeout = 30 * time.Second
+)
+
+// MigrateKeysOptions tunes the migration of the keys of a slot
+type MigrateKeysOptions struct {
+	// BatchSize max number of keys moved by a single MIGRATE, 100 if 0
+	BatchSize int
+	// MaxBatchBytes approximate size budget of a single MIGRATE, no budget if 0. The batch size is reduced
+	// according to the average key size, sampled with MEMORY USAGE, so that slots full of big values
+	// don't produce MIGRATE payloads timing out.
+	MaxBatchBytes int64
+	// Timeout timeout of a single MIGRATE, 10s if 0
+	Timeout time.Duration
+	// Progress if set, is called after each MIGRATE with the number of keys moved so far
+	Progress func(moved int)
+}
+
+// MigrateSlot moves the slot and its keys from the src master to the dst master: the slot is set
+// importing on dst and migrating on src, its keys are moved by batches with MIGRATE, each one given
+// timeout (10s if 0), then the slot is assigned to dst on both nodes. Keys already existing on dst
+// are replaced, and MIGRATE failing with a transient error is retried. MIGRATE authenticates on dst
+// with the Admin credentials.
+// The context is checked between every MIGRATE. Once canceled, or if the migration fails, it is rolled
+// back (see rollbackSlotMigration) so the slot stays on src with all its keys, and the context error,
+// or the migration one, is returned.
+func (a *Admin) MigrateSlot(ctx context.Context, slot Slot, src, dst *Node, timeout time.Duration) error {
+	srcClient := a.nodeClient(src.IPPort())
+	defer srcClient.Close()
+	dstClient := a.nodeClient(dst.IPPort())
+	defer dstClient.Close()
+
+	if err := dstClient.Do(ctx, "CLUSTER", "SETSLOT", int(slot), "IMPORTING", src.ID).Err(); err != nil {
+		return fmt.Errorf("unable to set slot %s importing on node %s: %v", slot, dst.ID, err)
+	}
+	if err := srcClient.Do(ctx, "CLUSTER", "SETSLOT", int(slot), "MIGRATING", dst.ID).Err(); err != nil {
+		rollbackSlotMigration(slot, srcClient, dstClient, src, dst, a.opts)
+		return fmt.Errorf("unable to set slot %s migrating on node %s: %v", slot, src.ID, err)
+	}
+
+	if _, err := migrateKeys(ctx, srcClient, a.opts, slot, src, dst, &MigrateKeysOptions{Timeout: timeout}); err != nil {
+		rollbackSlotMigration(slot, srcClient, dstClient, src, dst, a.opts)
+		if ctx.Err() != nil {
+			logger.Infof("migration of slot %s from node %s to node %s canceled", slot, src.ID, dst.ID)
+			return ctx.Err()
+		}
+		return err
+	}
+
+	if err := dstClient.Do(ctx, "CLUSTER", "SETSLOT", int(slot), "NODE", dst.ID).Err(); err != nil {
+		return fmt.Errorf("unable to assign slot %s to node %s on node %s: %v", slot, dst.ID, dst.ID, err)
+	}
+	if err := srcClient.Do(ctx, "CLUSTER", "SETSLOT", int(slot), "NODE", dst.ID).Err(); err != nil {
+		return fmt.Errorf("unable to assign slot %s to node %s on node %s: %v", slot, dst.ID, src.ID, err)
+	}
+	return nil
+}
+
+// rollbackSlotMigration leaves the slot to src, with all its keys, after its migration to dst was canceled
+// or failed: the slot is set STABLE on src, which still owns it, the keys already migrated are moved back
+// from dst, then the slot is set STABLE on dst. It runs within migrateRollbackTimeout, whatever the state of
+// the migration context. If a step fails, the remaining markers are left for FixOpenSlots to close the slot.
+func rollbackSlotMigration(slot Slot, srcClient, dstClient *redis.Client, src, dst *Node, auth AdminOptions) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateRollbackTimeout)
+	defer cancel()
+	// src refuses the keys while migrating the slot, redirecting them to dst
+	if err := srcClient.Do(ctx, "CLUSTER", "SETSLOT", int(slot), "STABLE").Err(); err != nil {
+		logger.Errorf("unable to set slot %s stable on node %s, leaving it open for FixOpenSlots: %v", slot, src.ID, err)
+		return
+	}
+	moved, err := migrateKeys(ctx, dstClient, auth, slot, dst, src, nil)
+	if err != nil {
+		logger.Errorf("unable to move back the keys of slot %s from node %s to node %s, leaving it open for FixOpenSlots: %v", slot, dst.ID, src.ID, err)
+		return
+	}
+	if err := dstClient.Do(ctx, "CLUSTER", "SETSLOT", int(slot), "STABLE").Err(); err != nil {
+		logger.Errorf("unable to set slot %s stable on node %s, leaving it open for FixOpenSlots: %v", slot, dst.ID, err)
+		return
+	}
+	logger.Infof("migration of slot %s from node %s to node %s rolled back, %d key(s) moved back", slot, src.ID, dst.ID, moved)
+}
+
+// MigrateKeys moves the keys of the slot from the src master to the dst master with MIGRATE, and returns
+// the number of keys moved. The slot must already be set importing on dst and migrating on src. It stops
+// between two MIGRATE once the context is canceled, leaving the slot markers to the caller.
+func (a *Admin) MigrateKeys(ctx context.Context, slot Slot, src, dst *Node, opts *MigrateKeysOptions) (int, error) {
+	c := a.nodeClient(src.IPPort())
+	defer c.Close()
+	return migrateKeys(ctx, c, a.opts, slot, src, dst, opts)
+}
+
+// migrateKeys moves the keys of the slot by batches with MIGRATE, using the client connected to src. MIGRATE
+// authenticates on dst with the credentials of auth.
+func migrateKeys(ctx context.Context, c *redis.Client, auth AdminOptions, slot Slot, src, dst *Node, opts *MigrateKeysOptions) (int, error) {
+	if opts == nil {
+		opts = &MigrateKeysOptions{}
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = migrateKeysBatchSize
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = migrateTimeout
+	}
+	host, port := dst.HostPortArgs()
+
+	moved := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return moved, err
+		}
+		keys, err := c.ClusterGetKeysInSlot(ctx, int(slot), batchSize).Result()
+		if err != nil {
+			return moved, fmt.Errorf("unable to get keys in slot %s from node %s: %v", slot, src.ID, err)
+		}
+		if len(keys) == 0 {
+			return moved, nil
+		}
+		size, err := budgetBatchSize(ctx, c, keys, opts.MaxBatchBytes)
+		if err != nil {
+			return moved, err
+		}
+		for start := 0; start < len(keys); start += size {
+			if err := ctx.Err(); err != nil {
+				return moved, err
+			}
+			end := start + size
+			if end > len(keys) {
+				end = len(keys)
+			}
+			if err := migrateBatch(ctx, c, auth, host, port, timeout, keys[start:end]); err != nil {
+				return moved, fmt.Errorf("unable to migrate keys of slot %s from node %s to node %s: %v", slot, src.ID, dst.ID, err)
+			}
+			moved += end - start
+			if opts.Progress != nil {
+				opts.Progress(moved)
+			}
+		}
+		logger.V(4).Infof("migrated %d keys of slot %s from node %s to node %s by batches of %d", len(keys), slot, src.ID, dst.ID, size)
+	}
+}
+
+// migrateBatch moves the keys to host:port with a single MIGRATE. It is sent again with REPLACE if some keys
+// already exist on the destination, and up to migrateRetries times on a transient error. The client waits for
+// the reply beyond the MIGRATE timeout, so a slow MIGRATE isn't sent again while still running.
+func migrateBatch(ctx context.Context, c *redis.Client, auth AdminOptions, host, port string, timeout time.Duration, keys []string) error {
+	c = c.WithTimeout(blockingTimeout(timeout))
+	replace := false
+	for attempt := 0; ; attempt++ {
+		args := []interface{}{"MIGRATE", host, port, "", 0, timeout.Milliseconds()}
+		if replace {
+			args = append(args, "REPLACE")
+		}
+		args = append(args, migrateAuthArgs(auth)...)
+		args = append(args, "KEYS")
+		for _, key := range keys {
+			args = append(args, key)
+		}
+		err := c.Do(ctx, args...).Err()
+		switch {
+		case err == nil:
+			return nil
+		case strings.HasPrefix(err.Error(), "BUSYKEY") && !replace:
+			logger.Infof("keys %v already exist on %s:%s, replacing them: %v", keys, host, port, err)
+			replace = true
+		case isTransientMigrateError(err) && attempt < migrateRetries:
+			logger.V(4).Infof("retrying MIGRATE to %s:%s after a transient error: %v", host, port, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pollInterval):
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// migrateAuthArgs returns the MIGRATE arguments authenticating on the destination node: AUTH2 for an ACL
+// user, AUTH for the default user, none without password
+func migrateAuthArgs(auth AdminOptions) []interface{} {
+	switch {
+	case auth.Username != "":
+		return []interface{}{"AUTH2", auth.Username, auth.Password}
+	case auth.Password != "":
+		return []interface{}{"AUTH", auth.Password}
+	}
+	return nil
+}
+
+// isTransientMigrateError returns true if MIGRATE failed on an error worth retrying: a timeout or an I/O
+// error between the nodes, or a network error with the source node
+func isTransientMigrateError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return strings.HasPrefix(err.Error(), "IOERR") || strings.HasPrefix(err.Error(), "TRYAGAIN")
+}
+
+// budgetBatchSize returns the number of keys fitting in maxBytes, according to the average MEMORY USAGE
+// of the first keys. All the keys fit if maxBytes is 0, and at least one key is always returned.
+func budgetBatchSize(ctx context.Context, c *redis.Client, keys []string, maxBytes int64) (int, error) {
+	if maxBytes <= 0 {
+		return len(keys), nil
+	}
+	samples := keys
+	if len(samples) > memoryUsageSamples {
+		samples = samples[:memoryUsageSamples]
+	}
+	var total int64
+	for _, key := range samples {
+		usage, err := c.MemoryUsage(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			return 0, fmt.Errorf("unable to get memory usage of key %s: %v", key, err)
+		}
+		total += usage
+	}
+	average := total / int64(len(samples))
+	if average == 0 {
+		return len(keys), nil
+	}
+	size := int(maxBytes / average)
+	if size < 1 {
+		size = 1
+	}
+	if size > len(keys) {
+		size = len(keys)
+	}
+	return size, nil
+}
+
+// GetOpenSlots returns the slots left importing or migrating, typically by an interrupted reshard, with the IDs
+// of the nodes still referencing them. CLUSTER INFO reports the cluster ok while the keys of these slots may be
+// unreachable. The markers are gathered from each node own view.
+func (a *Admin) GetOpenSlots(ctx context.Context) (map[Slot][]string, error) {
+	selves, err := a.selfNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return selves.OpenSlots(), nil
+}
+
+// FixOpenSlots closes the slots left importing or migrating, the way redis-cli --cluster fix does. The owner of
+// each open slot is the master holding the most keys of it, the one CLUSTER NODES lists as owner on a tie or when
+// no master holds keys, and otherwise the first master referencing the slot. The keys held by the other masters are
+// moved to the owner, then the slot is assigned to the owner with SETSLOT NODE on every master, the owner first.
+// Nothing is done when no slot is open.
+func (a *Admin) FixOpenSlots(ctx context.Context) error {
+	selves, err := a.selfNodes(ctx)
+	if err != nil {
+		return err
+	}
+	open := selves.OpenSlots()
+	if len(open) == 0 {
+		return nil
+	}
+	owners := selves.slotOwners()
+	masters := selves.Masters()
+
+	slots := make([]Slot, 0, len(open))
+	for slot := range open {
+		slots = append(slots, slot)
+	}
+	sort.Sort(SlotSlice(slots))
+	for _, slot := range slots {
+		counts := make(map[string]int64, len(masters))
+		for _, master := range masters {
+			c := a.nodeClient(master.IPPort())
+			count, err := c.ClusterCountKeysInSlot(ctx, int(slot)).Result()
+			c.Close()
+			if err != nil {
+				return fmt.Errorf("unable to count keys of slot %s on node %s: %v", slot, master.ID, err)
+			}
+			counts[master.ID] = count
+		}
+		ownerID := owners[slot]
+		for _, master := range masters {
+			if counts[master.ID] > counts[ownerID] {
+				ownerID = master.ID
+			}
+		}
+		if ownerID == "" {
+			for _, id := range open[slot] {
+				if _, err := masters.GetNodeByID(id); err == nil {
+					ownerID = id
+					break
+				}
+			}
+		}
+		owner, err := selves.GetNodeByID(ownerID)
+		if err != nil {
+			return err
+		}
+
+		for _, master := range masters {
+			if master.ID == owner.ID || counts[master.ID] == 0 {
+				continue
+			}
+			if err := a.moveOpenSlotKeys(ctx, slot, master, owner, owners[slot] != owner.ID); err != nil {
+				return err
+			}
+		}
+		// SETSLOT is refused by the replicas, they follow the assignment of their master
+		for _, node := range append(Nodes{owner}, masters.FilterByFunc(func(n *Node) bool { return n.ID != owner.ID })...) {
+			c := a.nodeClient(node.IPPort())
+			err := c.Do(ctx, "CLUSTER", "SETSLOT", int(slot), "NODE", owner.ID).Err()
+			c.Close()
+			if err != nil {
+				return fmt.Errorf("unable to assign slot %s to node %s on node %s: %v", slot, owner.ID, node.ID, err)
+			}
+		}
+		logger.Infof("open slot %s fixed, assigned to node %s", slot, owner.ID)
+	}
+	return nil
+}
+
+// moveOpenSlotKeys moves the keys of the open slot from src to the owner, setting the slot importing on the
+// owner first if it doesn't own the slot, so that it accepts the keys
+func (a *Admin) moveOpenSlotKeys(ctx context.Context, slot Slot, src, owner *Node, importing bool) error {
+	if importing {
+		c := a.nodeClient(owner.IPPort())
+		err := c.Do(ctx, "CLUSTER", "SETSLOT", int(slot), "IMPORTING", src.ID).Err()
+		c.Close()
+		if err != nil {
+			return fmt.Errorf("unable to set slot %s importing on node %s: %v", slot, owner.ID, err)
+		}
+	}
+	c := a.nodeClient(src.IPPort())
+	defer c.Close()
+	moved, err := migrateKeys(ctx, c, a.opts, slot, src, owner, nil)
+	if err != nil {
+		return err
+	}
+	logger.Infof("moved %d keys of open slot %s from node %s to node %s", moved, slot, src.ID, owner.ID)
+	return nil
+}
+
+// CleanupStaleImports clears with SETSLOT STABLE the importing markers left by an interrupted migration: the
+// ones whose source node isn't migrating the slot, seen by this Admin for at least olderThan, on a node not
+// holding any key of the slot (the keys are still on the source). It returns the cleared slots. The markers
+// are gathered from each node own view, as a node only reports its own markers.
+func (a *Admin) CleanupStaleImports(ctx context.Context, olderThan time.Duration) ([]Slot, error) {
+	selves, err := a.selfNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	importing, _ := selves.UnmatchedMigrations()
+
+	now := time.Now()
+	stale := []Slot{}
+	a.mu.Lock()
+	seen := map[string]time.Time{}
+	for slot, id := range importing {
+		key := id + "/" + slot.String()
+		first, ok := a.imports[key]
+		if !ok {
+			first = now
+		}
+		seen[key] = first
+		if now.Sub(first) >= olderThan {
+			stale = append(stale, slot)
+		}
+	}
+	// forget the markers cleared or completed since
+	a.imports = seen
+	a.mu.Unlock()
+	sort.Sort(SlotSlice(stale))
+
+	cleared := []Slot{}
+	for _, slot := range stale {
+		node, err := selves.GetNodeByID(importing[slot])
+		if err != nil {
+			return cleared, err
+		}
+		c := a.nodeClient(node.IPPort())
+		count, err := c.ClusterCountKeysInSlot(ctx, int(slot)).Result()
+		if err == nil && count > 0 {
+			logger.Infof("slot %s importing on node %s holds %d keys, leaving it", slot, node.ID, count)
+			c.Close()
+			continue
+		}
+		if err == nil {
+			err = c.Do(ctx, "CLUSTER", "SETSLOT", int(slot), "STABLE").Err()
+		}
+		c.Close()
+		if err != nil {
+			return cleared, fmt.Errorf("unable to clear importing slot %s on node %s: %v", slot, node.ID, err)
+		}
+		logger.Infof("stale importing slot %s cleared on node %s", slot, node.ID)
+		cleared = append(cleared, slot)
+	}
+	return cleared, nil
+}
@@ -0,0 +1,513 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slotKeysHandler returns a handler serving the number of keys of a slot, each key using size bytes,
+// and removing the keys received by MIGRATE
+func slotKeysHandler(count int, size int64) fakeHandler {
+	keys := map[string]bool{}
+	for i := 0; i < count; i++ {
+		keys[fmt.Sprintf("key:%d", i)] = true
+	}
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER GETKEYSINSLOT":
+			batch := []string{}
+			for i := 0; i < count && len(batch) < 100; i++ {
+				if key := fmt.Sprintf("key:%d", i); keys[key] {
+					batch = append(batch, key)
+				}
+			}
+			return batch
+		case "MEMORY USAGE":
+			return size
+		case "MIGRATE":
+			for _, key := range migrateKeysArgs(args) {
+				delete(keys, key)
+			}
+		}
+		return fakeOK
+	}
+}
+
+// migrateKeysArgs returns the keys of a MIGRATE command, following KEYS
+func migrateKeysArgs(args []string) []string {
+	for i, arg := range args {
+		if arg == "KEYS" {
+			return args[i+1:]
+		}
+	}
+	return nil
+}
+
+func TestAdminMigrateKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int64
+		opts     *MigrateKeysOptions
+		migrates int
+	}{
+		{name: "default batch", size: 1 << 20, opts: nil, migrates: 1},
+		{name: "small values under budget", size: 100, opts: &MigrateKeysOptions{MaxBatchBytes: 1 << 20}, migrates: 1},
+		{name: "large values over budget", size: 1 << 20, opts: &MigrateKeysOptions{MaxBatchBytes: 3 << 20}, migrates: 4},
+		{name: "value larger than budget", size: 1 << 20, opts: &MigrateKeysOptions{MaxBatchBytes: 1024}, migrates: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newFakeRedis(t, slotKeysHandler(10, tt.size))
+			dst := newFakeRedis(t, nil)
+			a := newTestAdmin(src.Addr())
+
+			moved, err := a.MigrateKeys(context.Background(), 42, &Node{ID: "A", IP: src.IP(), Port: src.Port()},
+				&Node{ID: "B", IP: dst.IP(), Port: dst.Port()}, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if moved != 10 {
+				t.Errorf("expected 10 keys moved, got %d", moved)
+			}
+			if calls := src.Calls("MIGRATE"); len(calls) != tt.migrates {
+				t.Errorf("expected %d MIGRATE, got %d", tt.migrates, len(calls))
+			}
+		})
+	}
+}
+
+func TestAdminMigrateSlot(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+
+	// the first MIGRATE hits a key already on the destination, the next one a transient error
+	keysHandler := slotKeysHandler(10, 100)
+	migrates := 0
+	src := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "MIGRATE" {
+			migrates++
+			switch migrates {
+			case 1:
+				return fakeError("BUSYKEY Target key name already exists.")
+			case 2:
+				return fakeError("IOERR error or timeout reading to target instance")
+			}
+		}
+		return keysHandler(args)
+	})
+	dst := newFakeRedis(t, nil)
+	a := newTestAdmin(src.Addr())
+
+	err := a.MigrateSlot(context.Background(), 42, &Node{ID: "A", IP: src.IP(), Port: src.Port()},
+		&Node{ID: "B", IP: dst.IP(), Port: dst.Port()}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	calls := src.Calls("MIGRATE")
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 MIGRATE, got %d", len(calls))
+	}
+	if calls[0][5] != "2000" || calls[0][6] != "KEYS" || calls[2][6] != "REPLACE" || len(migrateKeysArgs(calls[2])) != 10 {
+		t.Errorf("expected the batch to be sent again with REPLACE and the provided timeout, got %v", calls)
+	}
+	if setslots := dst.Calls("CLUSTER SETSLOT"); len(setslots) != 2 || setslots[1][3] != "NODE" {
+		t.Errorf("expected the slot to be imported then assigned on the destination, got %v", setslots)
+	}
+	if setslots := src.Calls("CLUSTER SETSLOT"); len(setslots) != 2 || setslots[0][3] != "MIGRATING" {
+		t.Errorf("expected the slot to be migrating then assigned on the source, got %v", setslots)
+	}
+
+	failingKeysHandler := slotKeysHandler(10, 100)
+	failing := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "MIGRATE" {
+			return fakeError("ERR Target instance replied with error: WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return failingKeysHandler(args)
+	})
+	err = a.MigrateSlot(context.Background(), 42, &Node{ID: "A", IP: failing.IP(), Port: failing.Port()},
+		&Node{ID: "B", IP: dst.IP(), Port: dst.Port()}, 0)
+	if err == nil || len(failing.Calls("MIGRATE")) != 1 {
+		t.Errorf("expected a non transient error to fail right away, got %v", err)
+	}
+	if setslots := failing.Calls("CLUSTER SETSLOT"); len(setslots) != 2 || setslots[1][3] != "STABLE" {
+		t.Errorf("expected the failed migration to be rolled back on the source, got %v", setslots)
+	}
+}
+
+func TestAdminMigrateKeysAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		opts AdminOptions
+		auth []string
+	}{
+		{name: "default user", opts: AdminOptions{Password: "secret"}, auth: []string{"AUTH", "secret"}},
+		{name: "acl user", opts: AdminOptions{Username: "admin", Password: "secret"}, auth: []string{"AUTH2", "admin", "secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newFakeRedis(t, slotKeysHandler(10, 100))
+			dst := newFakeRedis(t, nil)
+			a := NewAdminWithOptions([]string{src.Addr()}, tt.opts).(*Admin)
+
+			if _, err := a.MigrateKeys(context.Background(), 42, &Node{ID: "A", IP: src.IP(), Port: src.Port()},
+				&Node{ID: "B", IP: dst.IP(), Port: dst.Port()}, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			calls := src.Calls("MIGRATE")
+			if len(calls) != 1 || !reflect.DeepEqual(calls[0][6:7+len(tt.auth)], append(tt.auth, "KEYS")) || len(migrateKeysArgs(calls[0])) != 10 {
+				t.Errorf("expected MIGRATE to authenticate on the destination with %v, got %v", tt.auth, calls)
+			}
+		})
+	}
+}
+
+func TestAdminMigrateKeysSlowMigrate(t *testing.T) {
+	defer func(timeout time.Duration) { nodeReadTimeout = timeout }(nodeReadTimeout)
+	nodeReadTimeout = 50 * time.Millisecond
+
+	// MIGRATE replies after the read timeout of the node clients, but within its own timeout
+	keysHandler := slotKeysHandler(10, 100)
+	src := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "MIGRATE" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return keysHandler(args)
+	})
+	dst := newFakeRedis(t, nil)
+	a := newTestAdmin(src.Addr())
+
+	moved, err := a.MigrateKeys(context.Background(), 42, &Node{ID: "A", IP: src.IP(), Port: src.Port()},
+		&Node{ID: "B", IP: dst.IP(), Port: dst.Port()}, &MigrateKeysOptions{Timeout: time.Second})
+	if err != nil || moved != 10 {
+		t.Fatalf("expected the 10 keys to be moved, got %d, %v", moved, err)
+	}
+	if calls := src.Calls("MIGRATE"); len(calls) != 1 {
+		t.Errorf("expected a single MIGRATE, got %d", len(calls))
+	}
+}
+
+// keyStoresHandler returns a handler of the node serving port, storing the keys of a single slot in stores,
+// MIGRATE moving the keys to the store of the destination port
+func keyStoresHandler(port string, stores map[string]map[string]bool) fakeHandler {
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER GETKEYSINSLOT":
+			count, _ := strconv.Atoi(args[3])
+			keys := []string{}
+			for key := range stores[port] {
+				if len(keys) < count {
+					keys = append(keys, key)
+				}
+			}
+			return keys
+		case "MEMORY USAGE":
+			return 100
+		case "MIGRATE":
+			for _, key := range migrateKeysArgs(args) {
+				delete(stores[port], key)
+				stores[args[2]][key] = true
+			}
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminMigrateSlotCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := newFakeRedis(t, nil)
+	dst := newFakeRedis(t, nil)
+	stores := map[string]map[string]bool{src.Port(): {}, dst.Port(): {}}
+	for i := 0; i < 250; i++ {
+		stores[src.Port()][fmt.Sprintf("key:%d", i)] = true
+	}
+	// the operator shuts down while the first batch of keys is migrated
+	srcHandler := keyStoresHandler(src.Port(), stores)
+	src.SetHandler(func(args []string) interface{} {
+		if fakeCmdName(args) == "MIGRATE" && args[2] == dst.Port() {
+			cancel()
+		}
+		return srcHandler(args)
+	})
+	dst.SetHandler(keyStoresHandler(dst.Port(), stores))
+	a := newTestAdmin(src.Addr())
+
+	err := a.MigrateSlot(ctx, 42, &Node{ID: "A", IP: src.IP(), Port: src.Port()},
+		&Node{ID: "B", IP: dst.IP(), Port: dst.Port()}, 0)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if calls := src.Calls("MIGRATE"); len(calls) != 1 {
+		t.Errorf("expected the migration to stop after the first batch, got %d MIGRATE", len(calls))
+	}
+	// the slot stays on the source, so the keys already migrated must be back there to be reachable
+	if len(stores[src.Port()]) != 250 || len(stores[dst.Port()]) != 0 {
+		t.Errorf("expected the 250 keys back on the source, got %d on the source, %d on the destination",
+			len(stores[src.Port()]), len(stores[dst.Port()]))
+	}
+	if calls := dst.Calls("MIGRATE"); len(calls) != 1 || calls[0][2] != src.Port() {
+		t.Errorf("expected the migrated keys to be moved back from the destination, got %v", calls)
+	}
+	for name, fake := range map[string]*fakeRedis{"source": src, "destination": dst} {
+		setslots := fake.Calls("CLUSTER SETSLOT")
+		if len(setslots) != 2 || setslots[1][3] != "STABLE" {
+			t.Errorf("expected the slot to be set stable on the %s, got %v", name, setslots)
+		}
+	}
+}
+
+func TestAdminMigrateKeysProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := newFakeRedis(t, slotKeysHandler(100, 100))
+	dst := newFakeRedis(t, nil)
+	a := newTestAdmin(src.Addr())
+
+	progress := []int{}
+	moved, err := a.MigrateKeys(ctx, 42, &Node{ID: "A", IP: src.IP(), Port: src.Port()}, &Node{ID: "B", IP: dst.IP(), Port: dst.Port()},
+		&MigrateKeysOptions{MaxBatchBytes: 1000, Progress: func(moved int) {
+			progress = append(progress, moved)
+			if moved == 30 {
+				cancel()
+			}
+		}})
+	if err != context.Canceled || moved != 30 {
+		t.Errorf("expected 30 keys moved before the cancellation, got %d, %v", moved, err)
+	}
+	if want := []int{10, 20, 30}; fmt.Sprint(progress) != fmt.Sprint(want) {
+		t.Errorf("expected progress %v, got %v", want, progress)
+	}
+}
+
+func TestAdminGetOpenSlots(t *testing.T) {
+	var viewA, viewB string
+	a1 := newFakeRedis(t, selfHandler("A", &viewA))
+	b1 := newFakeRedis(t, selfHandler("B", &viewB))
+	// A migrates slot 100 to B, B imports it along with slot 200 from A which isn't migrating it anymore
+	viewA = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "myself,master", "-", "0-8191", "[100->-B]"),
+		fakeNodeLine("B", b1.Addr(), "master", "-", "8192-16383"),
+	)
+	viewB = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-8191"),
+		fakeNodeLine("B", b1.Addr(), "myself,master", "-", "8192-16383", "[100-<-A]", "[200-<-A]"),
+	)
+	a := newTestAdmin(a1.Addr())
+
+	open, err := a.GetOpenSlots(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[Slot][]string{100: {"A", "B"}, 200: {"B"}}
+	if !reflect.DeepEqual(open, want) {
+		t.Errorf("GetOpenSlots() = %v, want %v", open, want)
+	}
+}
+
+// openSlotHandler returns a handler of the node id, serving its own view and the number of keys of each slot,
+// the keys of a slot being removed once migrated
+func openSlotHandler(id string, view *string, counts map[Slot]int) fakeHandler {
+	self := selfHandler(id, view)
+	var current Slot
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER COUNTKEYSINSLOT":
+			slot, _ := strconv.Atoi(args[2])
+			return counts[Slot(slot)]
+		case "CLUSTER GETKEYSINSLOT":
+			slot, _ := strconv.Atoi(args[2])
+			current = Slot(slot)
+			keys := []string{}
+			for i := 0; i < counts[current]; i++ {
+				keys = append(keys, fmt.Sprintf("key:%d", i))
+			}
+			return keys
+		case "MEMORY USAGE":
+			return 100
+		case "MIGRATE":
+			counts[current] = 0
+			return fakeOK
+		}
+		return self(args)
+	}
+}
+
+// replicaHandler returns a handler of the replica id, serving its own view and refusing SETSLOT as redis does
+func replicaHandler(id string, view *string) fakeHandler {
+	self := selfHandler(id, view)
+	return func(args []string) interface{} {
+		if fakeCmdName(args) == "CLUSTER SETSLOT" {
+			return fakeError("ERR Please use SETSLOT only with masters.")
+		}
+		return self(args)
+	}
+}
+
+func TestAdminFixOpenSlots(t *testing.T) {
+	var viewA, viewB, viewC string
+	a1 := newFakeRedis(t, openSlotHandler("A", &viewA, map[Slot]int{100: 3, 9000: 5}))
+	b1 := newFakeRedis(t, openSlotHandler("B", &viewB, map[Slot]int{9000: 2}))
+	c1 := newFakeRedis(t, replicaHandler("C", &viewC))
+	// the migration of slot 100 from A to B was interrupted before any key moved, the one of slot 9000 from
+	// B to A after most of the keys moved
+	viewA = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "myself,master", "-", "0-8191", "[100->-B]", "[9000-<-B]"),
+		fakeNodeLine("B", b1.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", c1.Addr(), "slave", "A"),
+	)
+	viewB = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-8191"),
+		fakeNodeLine("B", b1.Addr(), "myself,master", "-", "8192-16383", "[100-<-A]", "[9000->-A]"),
+		fakeNodeLine("C", c1.Addr(), "slave", "A"),
+	)
+	viewC = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-8191"),
+		fakeNodeLine("B", b1.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("C", c1.Addr(), "myself,slave", "A"),
+	)
+	a := newTestAdmin(a1.Addr())
+
+	if err := a.FixOpenSlots(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := b1.Calls("MIGRATE"); len(calls) != 1 {
+		t.Errorf("expected the keys of slot 9000 to be moved from B to A, got %v", calls)
+	}
+	if calls := a1.Calls("CLUSTER SETSLOT"); len(calls) != 3 || strings.Join(calls[1][2:], " ") != "9000 IMPORTING B" {
+		t.Errorf("expected slot 9000 set importing on A before the keys move, got %v", calls)
+	}
+	for _, fake := range []*fakeRedis{a1, b1} {
+		assigned := []string{}
+		for _, call := range fake.Calls("CLUSTER SETSLOT") {
+			if call[3] == "NODE" {
+				assigned = append(assigned, strings.Join(call[2:], " "))
+			}
+		}
+		if want := "100 NODE A, 9000 NODE A"; strings.Join(assigned, ", ") != want {
+			t.Errorf("expected %s on node %s, got %v", want, fake.Addr(), assigned)
+		}
+	}
+	if calls := c1.Calls("CLUSTER SETSLOT"); len(calls) != 0 {
+		t.Errorf("expected no SETSLOT on the replica, got %v", calls)
+	}
+
+	// the cluster is fixed, nothing is left to do
+	viewA = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "myself,master", "-", "0-8191", "9000"),
+		fakeNodeLine("B", b1.Addr(), "master", "-", "8192-8999", "9001-16383"),
+	)
+	viewB = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-8191", "9000"),
+		fakeNodeLine("B", b1.Addr(), "myself,master", "-", "8192-8999", "9001-16383"),
+	)
+	if err := a.FixOpenSlots(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := a1.Calls("CLUSTER SETSLOT"); len(calls) != 3 {
+		t.Errorf("expected no slot to be assigned on a healthy cluster, got %v", calls)
+	}
+}
+
+func TestAdminFixOpenSlotsTie(t *testing.T) {
+	var viewA, viewB string
+	a1 := newFakeRedis(t, openSlotHandler("A", &viewA, map[Slot]int{9000: 3}))
+	b1 := newFakeRedis(t, openSlotHandler("B", &viewB, map[Slot]int{9000: 3}))
+	// half of the keys of slot 9000 moved from B, its listed owner, to A, counted first
+	viewA = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "myself,master", "-", "0-8191", "[9000-<-B]"),
+		fakeNodeLine("B", b1.Addr(), "master", "-", "8192-16383"),
+	)
+	viewB = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-8191"),
+		fakeNodeLine("B", b1.Addr(), "myself,master", "-", "8192-16383", "[9000->-A]"),
+	)
+	a := newTestAdmin(a1.Addr())
+
+	if err := a.FixOpenSlots(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := a1.Calls("MIGRATE"); len(calls) != 1 || len(b1.Calls("MIGRATE")) != 0 {
+		t.Errorf("expected the keys of slot 9000 to be moved back from A to B, got %v", calls)
+	}
+	for _, fake := range []*fakeRedis{a1, b1} {
+		if calls := fake.Calls("CLUSTER SETSLOT"); len(calls) != 1 || strings.Join(calls[0][2:], " ") != "9000 NODE B" {
+			t.Errorf("expected only 9000 NODE B on node %s, got %v", fake.Addr(), calls)
+		}
+	}
+}
+
+func TestAdminCleanupStaleImports(t *testing.T) {
+	var viewA, viewB, viewC string
+	handler := func(id string, view *string) fakeHandler {
+		self := selfHandler(id, view)
+		return func(args []string) interface{} {
+			if fakeCmdName(args) == "CLUSTER COUNTKEYSINSLOT" {
+				return 0
+			}
+			return self(args)
+		}
+	}
+	a1 := newFakeRedis(t, handler("A", &viewA))
+	b1 := newFakeRedis(t, handler("B", &viewB))
+	c1 := newFakeRedis(t, handler("C", &viewC))
+	// each node only reports its own markers: A migrates slot 100 to B, B imports it along with slot 200
+	// from C which isn't migrating it anymore
+	viewA = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "myself,master", "-", "0-5460", "[100->-B]"),
+		fakeNodeLine("B", b1.Addr(), "master", "-", "5461-10922"),
+		fakeNodeLine("C", c1.Addr(), "master", "-", "10923-16383"),
+	)
+	viewB = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-5460"),
+		fakeNodeLine("B", b1.Addr(), "myself,master", "-", "5461-10922", "[100-<-A]", "[200-<-C]"),
+		fakeNodeLine("C", c1.Addr(), "master", "-", "10923-16383"),
+	)
+	viewC = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-5460"),
+		fakeNodeLine("B", b1.Addr(), "master", "-", "5461-10922"),
+		fakeNodeLine("C", c1.Addr(), "myself,master", "-", "10923-16383"),
+	)
+	a := newTestAdmin(a1.Addr())
+	ctx := context.Background()
+
+	cleared, err := a.CleanupStaleImports(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cleared) != 0 {
+		t.Errorf("expected no import to be cleared before being seen for an hour, got %v", cleared)
+	}
+
+	cleared, err = a.CleanupStaleImports(ctx, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cleared) != 1 || cleared[0] != 200 {
+		t.Errorf("expected only the stale slot 200 to be cleared, got %v", cleared)
+	}
+	calls := b1.Calls("CLUSTER SETSLOT")
+	if len(calls) != 1 || calls[0][2] != "200" || calls[0][3] != "STABLE" {
+		t.Errorf("expected CLUSTER SETSLOT 200 STABLE on B, got %v", calls)
+	}
+}
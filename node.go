@@ -24,16 +24,18 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/klog/v2"
 
 	"github.com/kubernetes-app/redisutil/utils"
 )
 
 // Node Represent a Redis Node
+// ServerStartTime, UsedMemory and MaxMemory aren't part of CLUSTER NODES, they have to be filled separately,
+// with Admin.PopulateServerStartTimes and Admin.PopulateMemory
 type Node struct {
 	ID              string
 	IP              string
 	Port            string
+	BusPort         string
 	Role            string
 	LinkState       string
 	MasterReferent  string
@@ -45,8 +47,14 @@ type Node struct {
 	MigratingSlots  map[Slot]string
 	ImportingSlots  map[Slot]string
 	ServerStartTime time.Time
+	UsedMemory      int64
+	MaxMemory       int64
+	Zone            string
 
 	Pod *corev1.Pod
+
+	// Myself true for the node that answered CLUSTER NODES
+	Myself bool
 }
 
 // Nodes represent a Node slice
@@ -77,6 +85,7 @@ func NewNode(id, ip string, pod *corev1.Pod) *Node {
 	node.ID = id
 	node.IP = ip
 	node.Pod = pod
+	node.Zone = PodZone(pod)
 
 	return node
 }
@@ -124,11 +133,34 @@ func (n *Node) String() string {
 		n.ID, n.GetRole(), n.MasterReferent, n.LinkState, n.FailStatus, n.IPPort(), SlotSlice(n.Slots), len(n.MigratingSlots), len(n.ImportingSlots), n.ServerStartTime.Format("2006-01-02 15:04:05"))
 }
 
+// defaultBusPort returns the cluster bus port used by default for the port: port + 10000
+func defaultBusPort(port string) string {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return ""
+	}
+	return strconv.Itoa(p + 10000)
+}
+
 // IPPort returns join Ip Port string
 func (n *Node) IPPort() string {
 	return net.JoinHostPort(n.IP, n.Port)
 }
 
+// BusAddr returns the cluster form ip:port@busport of the node address, the bus port defaulting to port+10000
+func (n *Node) BusAddr() string {
+	busPort := n.BusPort
+	if busPort == "" {
+		busPort = defaultBusPort(n.Port)
+	}
+	return n.IPPort() + "@" + busPort
+}
+
+// HostPortArgs returns the host and port as separate arguments, as expected by CLUSTER MEET or MIGRATE
+func (n *Node) HostPortArgs() (string, string) {
+	return n.IP, n.Port
+}
+
 // FindNodeFunc function for finding a Node
 // it is use as input for GetNodeByFunc and GetNodesByFunc
 type FindNodeFunc func(node *Node) bool
@@ -179,6 +211,8 @@ func (n *Node) SetFailureStatus(flags string) {
 			n.FailStatus = append(n.FailStatus, NodeStatusNoAddr)
 		case NodeStatusNoFlags:
 			n.FailStatus = append(n.FailStatus, NodeStatusNoFlags)
+		case NodeStatusNoFailover:
+			n.FailStatus = append(n.FailStatus, NodeStatusNoFailover)
 		}
 	}
 }
@@ -197,6 +231,12 @@ func (n *Node) TotalSlots() int {
 	return len(n.Slots)
 }
 
+// SlotRanges returns the node slots collapsed into sorted ranges, ex: ["0-5460", "5462"] for a node owning
+// slots 0 to 5460 and 5462. An empty slice is returned for a node without slot.
+func (n *Node) SlotRanges() []string {
+	return encodeSlotRanges(n.Slots)
+}
+
 // HasStatus returns true if the node has the provided fail status flag
 func (n *Node) HasStatus(flag string) bool {
 	for _, status := range n.FailStatus {
@@ -228,6 +268,192 @@ var IsSlave = func(n *Node) bool {
 	return n.GetRole() == RedisSlaveRole
 }
 
+// OwnsSlot returns a FindNodeFunc searching the Master Node owning the slot
+func OwnsSlot(slot Slot) FindNodeFunc {
+	return func(n *Node) bool {
+		return n.GetRole() == RedisMasterRole && Contains(n.Slots, slot)
+	}
+}
+
+// RecentlyRestarted returns the nodes started less than within ago. It relies on the ServerStartTime
+// which must have been filled before (see Admin.PopulateServerStartTimes), nodes without a start time are ignored.
+func (n Nodes) RecentlyRestarted(within time.Duration) Nodes {
+	since := time.Now().Add(-within)
+	return n.FilterByFunc(func(node *Node) bool {
+		return !node.ServerStartTime.IsZero() && node.ServerStartTime.After(since)
+	})
+}
+
+// DuplicateIDs returns the IDs claimed by more than one address, with the nodes claiming them.
+// It usually reveals a node started from a cloned data directory.
+func (n Nodes) DuplicateIDs() map[string]Nodes {
+	byID := make(map[string]Nodes)
+	for _, node := range n {
+		if _, err := byID[node.ID].GetNodeByAddr(node.IPPort()); err == nil {
+			continue
+		}
+		byID[node.ID] = append(byID[node.ID], node)
+	}
+	duplicates := make(map[string]Nodes)
+	for id, nodes := range byID {
+		if len(nodes) > 1 {
+			duplicates[id] = nodes
+		}
+	}
+	return duplicates
+}
+
+// UnmatchedMigrations returns the slots marked importing on a node while the source node isn't migrating
+// them to it, and the slots marked migrating on a node while the destination node isn't importing them from
+// it, both keyed by slot with the ID of the node holding the dangling marker. As a node only reports its own
+// markers in CLUSTER NODES, the Nodes should be gathered from each node own view.
+func (n Nodes) UnmatchedMigrations() (danglingImporting map[Slot]string, danglingMigrating map[Slot]string) {
+	danglingImporting = make(map[Slot]string)
+	danglingMigrating = make(map[Slot]string)
+	for _, node := range n {
+		for slot, from := range node.ImportingSlots {
+			src, err := n.GetNodeByID(from)
+			if err != nil || src.MigratingSlots[slot] != node.ID {
+				danglingImporting[slot] = node.ID
+			}
+		}
+		for slot, to := range node.MigratingSlots {
+			dst, err := n.GetNodeByID(to)
+			if err != nil || dst.ImportingSlots[slot] != node.ID {
+				danglingMigrating[slot] = node.ID
+			}
+		}
+	}
+	return danglingImporting, danglingMigrating
+}
+
+// OpenSlots returns the slots marked importing or migrating on any node, with the sorted IDs of the nodes
+// holding a marker on them. As a node only reports its own markers in CLUSTER NODES, the Nodes should be
+// gathered from each node own view.
+func (n Nodes) OpenSlots() map[Slot][]string {
+	open := make(map[Slot][]string)
+	for _, node := range n {
+		for slot := range node.ImportingSlots {
+			open[slot] = append(open[slot], node.ID)
+		}
+		for slot := range node.MigratingSlots {
+			open[slot] = append(open[slot], node.ID)
+		}
+	}
+	for _, ids := range open {
+		sort.Strings(ids)
+	}
+	return open
+}
+
+// GroupByMaster returns the slaves of each master, keyed by master ID. Masters without slaves
+// have an empty entry.
+func (n Nodes) GroupByMaster() map[string]Nodes {
+	groups := make(map[string]Nodes)
+	for _, node := range n {
+		if node.GetRole() == RedisMasterRole {
+			if _, ok := groups[node.ID]; !ok {
+				groups[node.ID] = Nodes{}
+			}
+			continue
+		}
+		if node.MasterReferent != "" {
+			groups[node.MasterReferent] = append(groups[node.MasterReferent], node)
+		}
+	}
+	return groups
+}
+
+// MastersWithoutReplicas returns the masters no slave replicates, each one being a single point of data loss
+func (n Nodes) MastersWithoutReplicas() Nodes {
+	groups := n.GroupByMaster()
+	return n.FilterByFunc(func(node *Node) bool {
+		return node.GetRole() == RedisMasterRole && len(groups[node.ID]) == 0
+	})
+}
+
+// OrphanedReplicas returns the slaves whose master is unknown, no longer a master, or failed without slots.
+// The replicas of a failing master still owning slots aren't orphaned: one of them is expected to take over
+// its slots through a failover.
+func (n Nodes) OrphanedReplicas() Nodes {
+	return n.FilterByFunc(func(node *Node) bool {
+		if node.GetRole() != RedisSlaveRole {
+			return false
+		}
+		master, err := n.GetNodeByID(node.MasterReferent)
+		if err != nil {
+			return true
+		}
+		return master.GetRole() != RedisMasterRole || (master.HasStatus(NodeStatusFail) && len(master.Slots) == 0)
+	})
+}
+
+// MasterNeedingReplica returns the healthy master with the fewest replicas, the lowest ID wins among
+// masters having as many replicas. Masters owning slots are preferred to empty ones.
+func (n Nodes) MasterNeedingReplica() (*Node, error) {
+	groups := n.GroupByMaster()
+	var best *Node
+	for _, node := range n {
+		if node.GetRole() != RedisMasterRole || contactRank(node) == 0 {
+			continue
+		}
+		if best == nil {
+			best = node
+			continue
+		}
+		if rank, bestRank := contactRank(node), contactRank(best); rank != bestRank {
+			if rank > bestRank {
+				best = node
+			}
+			continue
+		}
+		if count, bestCount := len(groups[node.ID]), len(groups[best.ID]); count < bestCount || (count == bestCount && node.ID < best.ID) {
+			best = node
+		}
+	}
+	if best == nil {
+		return nil, nodeNotFoundedError
+	}
+	return best, nil
+}
+
+// contactRank returns how suitable the node is as contact node, 0 if it isn't healthy
+func contactRank(n *Node) int {
+	if n.LinkState != RedisLinkStateConnected {
+		return 0
+	}
+	for _, status := range []string{NodeStatusFail, NodeStatusPFail, NodeStatusHandshake, NodeStatusNoAddr} {
+		if n.HasStatus(status) {
+			return 0
+		}
+	}
+	switch {
+	case IsMasterWithSlot(n):
+		return 3
+	case n.GetRole() == RedisMasterRole:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// BestContactNode returns the healthiest node to bind a single client to: a connected and not failing master
+// owning slots, otherwise a healthy master or replica. The lowest ID wins among equally suitable nodes.
+func (n Nodes) BestContactNode() (*Node, error) {
+	var best *Node
+	bestRank := 0
+	for _, node := range n {
+		rank := contactRank(node)
+		if rank > bestRank || (rank > 0 && rank == bestRank && node.ID < best.ID) {
+			best, bestRank = node, rank
+		}
+	}
+	if best == nil {
+		return nil, nodeNotFoundedError
+	}
+	return best, nil
+}
+
 // SortNodes sort Nodes and return the sorted Nodes
 func (n Nodes) SortNodes() Nodes {
 	sort.Sort(n)
@@ -258,6 +484,12 @@ func (n Nodes) GetNodeByMasterID(id string) (*Node, error) {
 	return nil, nodeNotFoundedError
 }
 
+// GetSlavesOfMaster returns all the nodes replicating the master ID, an empty Nodes if there is none.
+// Unlike GetNodeByMasterID, it doesn't stop at the first replica.
+func (n Nodes) GetSlavesOfMaster(masterID string) Nodes {
+	return n.FilterByFunc(func(node *Node) bool { return node.MasterReferent == masterID })
+}
+
 // GetNodeByAddr returns a Redis Node by its ID
 // if not present in the Nodes slice return an error
 func (n Nodes) GetNodeByAddr(addr string) (*Node, error) {
@@ -291,6 +523,21 @@ func (n Nodes) FilterByFunc(fn func(*Node) bool) Nodes {
 	return newSlice
 }
 
+// FilterByRole returns the nodes having the role, as returned by GetRole
+func (n Nodes) FilterByRole(role string) Nodes {
+	return n.FilterByFunc(func(node *Node) bool { return node.GetRole() == role })
+}
+
+// Masters returns the master nodes
+func (n Nodes) Masters() Nodes {
+	return n.FilterByRole(RedisMasterRole)
+}
+
+// Slaves returns the slave nodes, including the ones only known by their MasterReferent
+func (n Nodes) Slaves() Nodes {
+	return n.FilterByRole(RedisSlaveRole)
+}
+
 // SortByFunc returns a new ordered NodeSlice, determined by a func defining ‘less’.
 func (n Nodes) SortByFunc(less func(*Node, *Node) bool) Nodes {
 	result := make(Nodes, len(n))
@@ -366,44 +613,12 @@ func DecodeNodeInfos(input *string) *Nodes {
 		values := strings.Split(line, " ")
 		if len(values) < 8 {
 			// last line is always empty
-			klog.V(7).Infof("Not enough values in line split, ignoring line: '%s'", line)
+			logger.V(7).Infof("Not enough values in line split, ignoring line: '%s'", line)
 			continue
 		} else {
-			node := NewDefaultNode()
-
-			node.ID = values[0]
-			//remove trailing port for cluster internal protocol
-			ipPort := strings.Split(values[1], "@")
-			if ip, port, err := net.SplitHostPort(ipPort[0]); err == nil {
-				node.IP = ip
-				node.Port = port
-			} else {
-				klog.Errorf("Error while decoding node info for node '%s', cannot split ip:port ('%s'): %v", node.ID, values[1], err)
-			}
-			node.SetRole(values[2])
-			node.SetFailureStatus(values[2])
-			node.SetReferentMaster(values[3])
-			if i, err := strconv.ParseInt(values[4], 10, 64); err == nil {
-				node.PingSent = i
-			}
-			if i, err := strconv.ParseInt(values[5], 10, 64); err == nil {
-				node.PongRecv = i
-			}
-			if i, err := strconv.ParseInt(values[6], 10, 64); err == nil {
-				node.ConfigEpoch = i
-			}
-			node.SetLinkStatus(values[7])
-
-			for _, slot := range values[8:] {
-				if s, importing, migrating, err := DecodeSlotRange(slot); err == nil {
-					node.Slots = append(node.Slots, s...)
-					if importing != nil {
-						node.ImportingSlots[importing.SlotID] = importing.FromNodeID
-					}
-					if migrating != nil {
-						node.MigratingSlots[migrating.SlotID] = migrating.ToNodeID
-					}
-				}
+			node, err := decodeNodeInfo(values)
+			if err != nil {
+				logger.Errorf("Error while decoding node info for node '%s', %v", node.ID, err)
 			}
 			nodes = append(nodes, node)
 		}
@@ -412,6 +627,95 @@ func DecodeNodeInfos(input *string) *Nodes {
 	return &nodes
 }
 
+// DecodeNodeInfosStrict decodes the CLUSTER NODES output like DecodeNodeInfos, but returns an error listing the
+// numbers (starting at 1) of the lines it couldn't parse instead of skipping them, so a truncated or corrupted
+// output isn't mistaken for a smaller topology. Empty lines, like the last one, are ignored.
+func DecodeNodeInfosStrict(input *string) (*Nodes, error) {
+	nodes := Nodes{}
+	malformed := []string{}
+	for i, line := range strings.Split(*input, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		values := strings.Split(line, " ")
+		if len(values) < 8 {
+			malformed = append(malformed, strconv.Itoa(i+1))
+			continue
+		}
+		node, err := decodeNodeInfo(values)
+		if err != nil {
+			malformed = append(malformed, strconv.Itoa(i+1))
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	if len(malformed) > 0 {
+		return nil, fmt.Errorf("unable to decode CLUSTER NODES lines %s", strings.Join(malformed, ", "))
+	}
+	return &nodes, nil
+}
+
+// decodeNodeInfo decodes the fields of a CLUSTER NODES line, at least 8 are expected. The node is returned
+// along with the error if its address or one of its slots can't be decoded, without the slots in error.
+func decodeNodeInfo(values []string) (*Node, error) {
+	var decodeErr error
+	node := NewDefaultNode()
+
+	node.ID = values[0]
+	//remove trailing port for cluster internal protocol
+	ipPort := strings.Split(values[1], "@")
+	if ip, port, err := net.SplitHostPort(ipPort[0]); err == nil {
+		node.IP = ip
+		node.Port = port
+		node.BusPort = defaultBusPort(port)
+		if len(ipPort) > 1 {
+			// since redis 7 the bus port can be followed by the hostname
+			node.BusPort = strings.Split(ipPort[1], ",")[0]
+		}
+	} else {
+		decodeErr = fmt.Errorf("cannot split ip:port ('%s'): %v", values[1], err)
+	}
+	node.SetRole(values[2])
+	node.SetFailureStatus(values[2])
+	for _, flag := range strings.Split(values[2], ",") {
+		if flag == NodeFlagMyself {
+			node.Myself = true
+		}
+	}
+	node.SetReferentMaster(values[3])
+	if i, err := strconv.ParseInt(values[4], 10, 64); err == nil {
+		node.PingSent = i
+	}
+	if i, err := strconv.ParseInt(values[5], 10, 64); err == nil {
+		node.PongRecv = i
+	}
+	if i, err := strconv.ParseInt(values[6], 10, 64); err == nil {
+		node.ConfigEpoch = i
+	}
+	node.SetLinkStatus(values[7])
+
+	for _, slot := range values[8:] {
+		if slot == "" {
+			continue
+		}
+		s, importing, migrating, err := DecodeSlotRange(slot)
+		if err != nil {
+			if decodeErr == nil {
+				decodeErr = fmt.Errorf("cannot decode slot '%s': %v", slot, err)
+			}
+			continue
+		}
+		node.Slots = append(node.Slots, s...)
+		if importing != nil {
+			node.ImportingSlots[importing.SlotID] = importing.FromNodeID
+		}
+		if migrating != nil {
+			node.MigratingSlots[migrating.SlotID] = migrating.ToNodeID
+		}
+	}
+	return node, decodeErr
+}
+
 // DecodeClusterInfos decode from the cmd output the Redis nodes info. Second argument is the node on which we are connected to request info
 func DecodeClusterInfos(input *string) *map[string]string {
 	clusterInfo := make(map[string]string)
@@ -419,7 +723,7 @@ func DecodeClusterInfos(input *string) *map[string]string {
 		values := strings.Split(line, ":")
 		if len(values) < 2 {
 			// last line is always empty
-			klog.V(2).Infof("Not enough values in line split, ignoring line: '%s'", line)
+			logger.V(2).Infof("Not enough values in line split, ignoring line: '%s'", line)
 			continue
 		} else {
 			clusterInfo[values[0]] = values[1]
@@ -18,7 +18,9 @@ package redis
 import (
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -283,3 +285,299 @@ func TestSearchNodeByID(t *testing.T) {
 		t.Errorf("Expected to find node %v, got %v", nodeSlave, node)
 	}
 }
+
+func TestOwnsSlot(t *testing.T) {
+	slice := Nodes{
+		{ID: "A", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 100)},
+		{ID: "B", Role: RedisMasterRole, Slots: append(BuildSlotSlice(101, 200), 300, 305)},
+		{ID: "C", Role: RedisSlaveRole, MasterReferent: "B", Slots: []Slot{300}},
+	}
+	tests := []struct {
+		slot Slot
+		want string
+	}{
+		{slot: 0, want: "A"},
+		{slot: 100, want: "A"},
+		{slot: 101, want: "B"},
+		{slot: 300, want: "B"},
+		{slot: 305, want: "B"},
+		{slot: 301, want: ""},
+	}
+	for _, tt := range tests {
+		nodes := slice.FilterByFunc(OwnsSlot(tt.slot))
+		if tt.want == "" {
+			if len(nodes) != 0 {
+				t.Errorf("expected no owner for slot %d, got %v", tt.slot, nodes)
+			}
+			continue
+		}
+		if len(nodes) != 1 || nodes[0].ID != tt.want {
+			t.Errorf("expected owner %s for slot %d, got %v", tt.want, tt.slot, nodes)
+		}
+	}
+	if _, err := slice.GetNodesByFunc(OwnsSlot(301)); !IsNodeNotFoundedError(err) {
+		t.Errorf("expected a NodeNotFoundedError for an unassigned slot, got %v", err)
+	}
+}
+
+func TestNodeSlotRanges(t *testing.T) {
+	node := &Node{ID: "A", Slots: append([]Slot{5462, 16383}, BuildSlotSlice(0, 5460)...)}
+	if got, want := node.SlotRanges(), []string{"0-5460", "5462", "16383"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SlotRanges() = %v, want %v", got, want)
+	}
+	if node.Slots[0] != 5462 {
+		t.Errorf("expected the node slots to be left unsorted")
+	}
+	if got := (&Node{ID: "B"}).SlotRanges(); len(got) != 0 {
+		t.Errorf("expected no range for a node without slot, got %v", got)
+	}
+}
+
+func TestNodesFilterByRole(t *testing.T) {
+	nodes := Nodes{
+		{ID: "A", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 100)},
+		{ID: "B", Slots: BuildSlotSlice(101, 200)},
+		{ID: "C", Role: RedisSlaveRole, MasterReferent: "A"},
+		{ID: "D", MasterReferent: "B"},
+		{ID: "E"},
+	}
+	ids := func(nodes Nodes) (ids []string) {
+		for _, node := range nodes {
+			ids = append(ids, node.ID)
+		}
+		return ids
+	}
+	if got, want := ids(nodes.Masters()), []string{"A", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Masters() = %v, want %v", got, want)
+	}
+	if got, want := ids(nodes.Slaves()), []string{"C", "D"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Slaves() = %v, want %v", got, want)
+	}
+	if got, want := ids(nodes.FilterByRole("none")), []string{"E"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FilterByRole(none) = %v, want %v", got, want)
+	}
+}
+
+func TestNodesGetSlavesOfMaster(t *testing.T) {
+	nodes := Nodes{
+		{ID: "A", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 100)},
+		{ID: "B", Role: RedisMasterRole, Slots: BuildSlotSlice(101, 200)},
+		{ID: "C", Role: RedisSlaveRole, MasterReferent: "A"},
+		{ID: "D", Role: RedisSlaveRole, MasterReferent: "A"},
+	}
+	if slaves := nodes.GetSlavesOfMaster("A"); len(slaves) != 2 || slaves[0].ID != "C" || slaves[1].ID != "D" {
+		t.Errorf("expected C and D to replicate A, got %v", slaves)
+	}
+	if slaves := nodes.GetSlavesOfMaster("B"); slaves == nil || len(slaves) != 0 {
+		t.Errorf("expected an empty Nodes for B, got %v", slaves)
+	}
+}
+
+func TestNodesRecentlyRestarted(t *testing.T) {
+	now := time.Now()
+	slice := Nodes{
+		{ID: "A", ServerStartTime: now.Add(-72 * time.Hour)},
+		{ID: "B", ServerStartTime: now.Add(-30 * time.Second)},
+		{ID: "C"},
+		{ID: "D", ServerStartTime: now.Add(-2 * time.Minute)},
+	}
+	restarted := slice.RecentlyRestarted(5 * time.Minute)
+	if len(restarted) != 2 || restarted[0].ID != "B" || restarted[1].ID != "D" {
+		t.Errorf("expected B and D to be recently restarted, got %v", restarted)
+	}
+	if restarted = slice.RecentlyRestarted(time.Minute); len(restarted) != 1 || restarted[0].ID != "B" {
+		t.Errorf("expected B to be restarted within a minute, got %v", restarted)
+	}
+}
+
+func TestDecodeNodeInfosBusPort(t *testing.T) {
+	raw := "A 10.0.0.1:6379@16380 myself,master - 0 0 1 connected 0-8191\n" +
+		"B 10.0.0.2:6379@16379,redis-1 master - 0 0 2 connected 8192-16383\n" +
+		"C 10.0.0.3:7000 slave A 0 0 1 connected\n"
+	nodes := *DecodeNodeInfos(&raw)
+	for i, want := range []string{"16380", "16379", "17000"} {
+		if nodes[i].BusPort != want {
+			t.Errorf("expected bus port %s for node %s, got %s", want, nodes[i].ID, nodes[i].BusPort)
+		}
+	}
+}
+
+func TestDecodeNodeInfosMyself(t *testing.T) {
+	raw := "A 10.0.0.1:6379@16379 master - 0 0 1 connected 0-8191\n" +
+		"B 10.0.0.2:6379@16379 myself,slave A 0 0 1 connected\n" +
+		"C 10.0.0.3:6379@16379 master - 0 0 2 connected 8192-16383\n"
+	nodes := *DecodeNodeInfos(&raw)
+	for i, want := range []bool{false, true, false} {
+		if nodes[i].Myself != want {
+			t.Errorf("expected myself %v for node %s, got %v", want, nodes[i].ID, nodes[i].Myself)
+		}
+	}
+	if nodes[1].GetRole() != RedisSlaveRole {
+		t.Errorf("expected the myself flag not to change the role, got %s", nodes[1].GetRole())
+	}
+}
+
+func TestDecodeNodeInfosStrict(t *testing.T) {
+	raw := "A 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-8191\n" +
+		"B 10.0.0.2:6379@16379 master - 0 0 2 connected 8192-16383\n"
+	nodes, err := DecodeNodeInfosStrict(&raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*nodes) != 2 {
+		t.Errorf("expected 2 nodes, got %d", len(*nodes))
+	}
+
+	// the response is cut in the middle of the second line, the third one has a broken address
+	truncated := "A 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-8191\n" +
+		"B 10.0.0.2:6379@16379 master - 0\n" +
+		"C 10.0.0.3 slave A 0 0 1 connected\n"
+	if _, err := DecodeNodeInfosStrict(&truncated); err == nil || !strings.Contains(err.Error(), "lines 2, 3") {
+		t.Errorf("expected an error reporting lines 2 and 3, got %v", err)
+	}
+	if lenient := DecodeNodeInfos(&truncated); len(*lenient) != 2 {
+		t.Errorf("expected the lenient decoding to skip the truncated line only, got %d nodes", len(*lenient))
+	}
+
+	// a corrupted slot token must not silently leave the node without its slots
+	corrupted := "A 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-8191\n" +
+		"B 10.0.0.2:6379@16379 master - 0 0 2 connected 8192-1x383\n"
+	if _, err := DecodeNodeInfosStrict(&corrupted); err == nil || !strings.Contains(err.Error(), "lines 2") {
+		t.Errorf("expected an error reporting line 2, got %v", err)
+	}
+}
+
+func TestNodesDuplicateIDs(t *testing.T) {
+	slice := Nodes{
+		{ID: "A", IP: "10.0.0.1", Port: "6379"},
+		{ID: "B", IP: "10.0.0.2", Port: "6379"},
+		{ID: "A", IP: "10.0.0.3", Port: "6379"},
+		{ID: "B", IP: "10.0.0.2", Port: "6379"},
+	}
+	duplicates := slice.DuplicateIDs()
+	if len(duplicates) != 1 || len(duplicates["A"]) != 2 {
+		t.Fatalf("expected only A to be claimed twice, got %v", duplicates)
+	}
+	if duplicates["A"][0].IP != "10.0.0.1" || duplicates["A"][1].IP != "10.0.0.3" {
+		t.Errorf("unexpected nodes claiming A: %v", duplicates["A"])
+	}
+}
+
+func TestNodesUnmatchedMigrations(t *testing.T) {
+	a := &Node{ID: "A", MigratingSlots: map[Slot]string{1: "B", 2: "C"}, ImportingSlots: map[Slot]string{}}
+	b := &Node{ID: "B", MigratingSlots: map[Slot]string{}, ImportingSlots: map[Slot]string{1: "A", 3: "A", 4: "Z"}}
+	c := &Node{ID: "C", MigratingSlots: map[Slot]string{}, ImportingSlots: map[Slot]string{}}
+
+	importing, migrating := Nodes{a, b, c}.UnmatchedMigrations()
+	if want := map[Slot]string{3: "B", 4: "B"}; !reflect.DeepEqual(importing, want) {
+		t.Errorf("danglingImporting = %v, want %v", importing, want)
+	}
+	if want := map[Slot]string{2: "A"}; !reflect.DeepEqual(migrating, want) {
+		t.Errorf("danglingMigrating = %v, want %v", migrating, want)
+	}
+}
+
+func TestNodeAddressForms(t *testing.T) {
+	tests := []struct {
+		node    *Node
+		busAddr string
+		host    string
+		port    string
+	}{
+		{node: &Node{IP: "10.0.0.1", Port: "6379", BusPort: "16380"}, busAddr: "10.0.0.1:6379@16380", host: "10.0.0.1", port: "6379"},
+		{node: &Node{IP: "10.0.0.1", Port: "7000"}, busAddr: "10.0.0.1:7000@17000", host: "10.0.0.1", port: "7000"},
+		{node: &Node{IP: "fd00::1", Port: "6379", BusPort: "16379"}, busAddr: "[fd00::1]:6379@16379", host: "fd00::1", port: "6379"},
+	}
+	for _, tt := range tests {
+		if got := tt.node.BusAddr(); got != tt.busAddr {
+			t.Errorf("BusAddr() = %s, want %s", got, tt.busAddr)
+		}
+		if host, port := tt.node.HostPortArgs(); host != tt.host || port != tt.port {
+			t.Errorf("HostPortArgs() = %s %s, want %s %s", host, port, tt.host, tt.port)
+		}
+	}
+}
+
+func TestNodesMastersWithoutReplicas(t *testing.T) {
+	slice := Nodes{
+		{ID: "A", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 8191)},
+		{ID: "B", Role: RedisMasterRole, Slots: BuildSlotSlice(8192, 16383)},
+		{ID: "C", Role: RedisSlaveRole, MasterReferent: "A"},
+		{ID: "D", Role: RedisSlaveRole, MasterReferent: "A"},
+	}
+	groups := slice.GroupByMaster()
+	if len(groups) != 2 || len(groups["A"]) != 2 || len(groups["B"]) != 0 {
+		t.Errorf("unexpected groups %v", groups)
+	}
+	without := slice.MastersWithoutReplicas()
+	if len(without) != 1 || without[0].ID != "B" {
+		t.Errorf("expected B to have no replica, got %v", without)
+	}
+}
+
+func TestNodesBestContactNode(t *testing.T) {
+	connected := func(id, role string, slots []Slot, status ...string) *Node {
+		return &Node{ID: id, Role: role, LinkState: RedisLinkStateConnected, Slots: slots, FailStatus: status}
+	}
+	tests := []struct {
+		name  string
+		nodes Nodes
+		want  string
+	}{
+		{
+			name: "healthy master owning slots first",
+			nodes: Nodes{
+				connected("A", RedisSlaveRole, nil),
+				connected("B", RedisMasterRole, nil),
+				connected("D", RedisMasterRole, BuildSlotSlice(0, 100)),
+				connected("C", RedisMasterRole, BuildSlotSlice(101, 200)),
+			},
+			want: "C",
+		},
+		{
+			name: "failing and disconnected masters skipped",
+			nodes: Nodes{
+				connected("A", RedisMasterRole, BuildSlotSlice(0, 100), NodeStatusFail),
+				connected("B", RedisMasterRole, BuildSlotSlice(101, 200), NodeStatusPFail),
+				{ID: "C", Role: RedisMasterRole, LinkState: RedisLinkStateDisconnected, Slots: BuildSlotSlice(201, 300)},
+				connected("D", RedisSlaveRole, nil),
+			},
+			want: "D",
+		},
+	}
+	for _, tt := range tests {
+		node, err := tt.nodes.BestContactNode()
+		if err != nil || node.ID != tt.want {
+			t.Errorf("%s: BestContactNode() = %v, %v, want %s", tt.name, node, err, tt.want)
+		}
+	}
+
+	if _, err := (Nodes{connected("A", RedisMasterRole, nil, NodeStatusHandshake)}).BestContactNode(); !IsNodeNotFoundedError(err) {
+		t.Errorf("expected no contact node among unhealthy nodes, got %v", err)
+	}
+}
+
+func TestNodesOrphanedReplicas(t *testing.T) {
+	slice := Nodes{
+		{ID: "A", Role: RedisMasterRole, LinkState: RedisLinkStateConnected, Slots: BuildSlotSlice(0, 8191)},
+		{ID: "B", Role: RedisMasterRole, LinkState: RedisLinkStateConnected, Slots: BuildSlotSlice(8192, 16383)},
+		{ID: "X", Role: RedisMasterRole, LinkState: RedisLinkStateConnected, FailStatus: []string{NodeStatusFail}},
+		{ID: "Y", Role: RedisMasterRole, LinkState: RedisLinkStateConnected, FailStatus: []string{NodeStatusPFail}, Slots: BuildSlotSlice(0, 10)},
+		{ID: "Z", Role: RedisMasterRole, LinkState: RedisLinkStateConnected, FailStatus: []string{NodeStatusFail}, Slots: BuildSlotSlice(11, 20)},
+		{ID: "C", Role: RedisSlaveRole, MasterReferent: "A"},
+		{ID: "D", Role: RedisSlaveRole, MasterReferent: "X"},
+		{ID: "E", Role: RedisSlaveRole, MasterReferent: "gone"},
+		{ID: "F", Role: RedisSlaveRole, MasterReferent: "Y"},
+		{ID: "G", Role: RedisSlaveRole, MasterReferent: "Z"},
+		{ID: "H", Role: RedisSlaveRole, MasterReferent: "C"},
+	}
+	// F and G are expected to failover their master still owning slots
+	orphans := slice.OrphanedReplicas()
+	if len(orphans) != 3 || orphans[0].ID != "D" || orphans[1].ID != "E" || orphans[2].ID != "H" {
+		t.Errorf("expected D, E and H to be orphaned, got %v", orphans)
+	}
+	master, err := slice.MasterNeedingReplica()
+	if err != nil || master.ID != "B" {
+		t.Errorf("expected B to need a replica, got %v, %v", master, err)
+	}
+}
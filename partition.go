@@ -0,0 +1,211 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// sees returns whether the view shows the node id as a healthy peer
+func sees(view Nodes, id string) bool {
+	node, err := view.GetNodeByID(id)
+	if err != nil {
+		return false
+	}
+	if node.LinkState != RedisLinkStateConnected {
+		return false
+	}
+	for _, status := range []string{NodeStatusFail, NodeStatusPFail, NodeStatusHandshake, NodeStatusNoAddr} {
+		if node.HasStatus(status) {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterViews returns the CLUSTER NODES view of every reachable node, keyed by node ID, along with the nodes
+// found. The nodes are discovered from the views, starting from the single client one, so that the nodes of
+// another partition are found as long as one view still lists them.
+func (a *Admin) clusterViews(ctx context.Context) (map[string]Nodes, map[string]*Node, error) {
+	seed, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, nil, err
+	}
+	known := map[string]*Node{}
+	toVisit := Nodes{}
+	discover := func(view Nodes) {
+		for _, node := range view {
+			if _, ok := known[node.ID]; !ok && !node.HasStatus(NodeStatusNoAddr) && !node.HasStatus(NodeStatusHandshake) {
+				known[node.ID] = node
+				toVisit = append(toVisit, node)
+			}
+		}
+	}
+	discover(*seed)
+
+	views := map[string]Nodes{}
+	for len(toVisit) > 0 {
+		node := toVisit[0]
+		toVisit = toVisit[1:]
+		c := a.nodeClient(node.IPPort())
+		view, err := getClusterNodes(ctx, c)
+		c.Close()
+		if err != nil {
+			logger.V(4).Infof("unable to get the view of node %s: %v", node.ID, err)
+			continue
+		}
+		views[node.ID] = *view
+		discover(*view)
+	}
+	return views, known, nil
+}
+
+// HealPartition reconnects the components of a partitioned cluster, typically after a network partition
+// healed but the nodes didn't gossip again. Two nodes are in the same component when one of them sees the
+// other as a healthy peer, the components are found from the views of all the reachable nodes. A single
+// CLUSTER MEET is issued per extra component, from the node with the lowest ID of the first component to the
+// node with the lowest ID of the other one: gossip spreads the rest of the topology.
+func (a *Admin) HealPartition(ctx context.Context) error {
+	views, known, err := a.clusterViews(ctx)
+	if err != nil {
+		return err
+	}
+
+	// union find over the reachable nodes
+	parent := map[string]string{}
+	var find func(id string) string
+	find = func(id string) string {
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+	union := func(id1, id2 string) {
+		root1, root2 := find(id1), find(id2)
+		if root1 == root2 {
+			return
+		}
+		if root1 < root2 {
+			parent[root2] = root1
+		} else {
+			parent[root1] = root2
+		}
+	}
+	reachable := Nodes{}
+	for id := range views {
+		parent[id] = id
+		reachable = append(reachable, known[id])
+	}
+	for id, view := range views {
+		for other := range views {
+			if other != id && sees(view, other) {
+				union(id, other)
+			}
+		}
+	}
+
+	// roots are the lowest ID of each component, so sorting gives the first component first
+	roots := []*Node{}
+	for _, node := range reachable.SortNodes() {
+		if find(node.ID) == node.ID {
+			roots = append(roots, node)
+		}
+	}
+	if len(roots) < 2 {
+		return nil
+	}
+
+	c := a.nodeClient(roots[0].IPPort())
+	defer c.Close()
+	for _, root := range roots[1:] {
+		host, port := root.HostPortArgs()
+		if err := c.ClusterMeet(ctx, host, port).Err(); err != nil {
+			return fmt.Errorf("unable to meet node %s from node %s: %v", root.ID, roots[0].ID, err)
+		}
+		logger.Infof("partition of node %s met from node %s", root.ID, roots[0].ID)
+	}
+	return nil
+}
+
+// GetClusterNodesFromAll returns the topology merged from the CLUSTER NODES views of every reachable node,
+// discovered like HealPartition does, so that a stale view of the single client node doesn't hide the others.
+// The entry of each node is taken from its own view, where it is flagged myself and its link state is
+// authoritative, or from the first view listing it, in viewer ID order, if it isn't reachable. When the views
+// disagree on the role of a node or on whether it is failing, the merged nodes are returned along with a
+// TopologyDisagreementError describing each disagreement.
+func (a *Admin) GetClusterNodesFromAll(ctx context.Context) (*Nodes, error) {
+	views, known, err := a.clusterViews(ctx)
+	if err != nil {
+		return nil, err
+	}
+	viewers := make([]string, 0, len(views))
+	for id := range views {
+		viewers = append(viewers, id)
+	}
+	sort.Strings(viewers)
+	ids := make([]string, 0, len(known))
+	for id := range known {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	merged := Nodes{}
+	disagreements := []string{}
+	for _, id := range ids {
+		var entry *Node
+		if self, err := views[id].GetNodeByID(id); err == nil {
+			entry = self
+		}
+		roles := map[string][]string{}
+		failing := map[bool][]string{}
+		for _, viewer := range viewers {
+			node, err := views[viewer].GetNodeByID(id)
+			if err != nil {
+				continue
+			}
+			if entry == nil {
+				entry = node
+			}
+			roles[node.GetRole()] = append(roles[node.GetRole()], viewer)
+			fail := node.HasStatus(NodeStatusFail) || node.HasStatus(NodeStatusPFail)
+			failing[fail] = append(failing[fail], viewer)
+		}
+		if entry == nil {
+			entry = known[id]
+		}
+		merged = append(merged, entry)
+
+		if len(roles) > 1 {
+			seen := []string{}
+			for role, by := range roles {
+				seen = append(seen, fmt.Sprintf("%s by %s", role, strings.Join(by, ",")))
+			}
+			sort.Strings(seen)
+			disagreements = append(disagreements, fmt.Sprintf("node %s seen as %s", id, strings.Join(seen, " and ")))
+		}
+		if len(failing) > 1 {
+			disagreements = append(disagreements, fmt.Sprintf("node %s seen failing by %s and healthy by %s", id,
+				strings.Join(failing[true], ","), strings.Join(failing[false], ",")))
+		}
+	}
+	if len(disagreements) > 0 {
+		return &merged, TopologyDisagreementError{Disagreements: disagreements}
+	}
+	return &merged, nil
+}
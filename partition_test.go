@@ -0,0 +1,124 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAdminHealPartition(t *testing.T) {
+	var left, right string
+	a1 := newFakeRedis(t, clusterNodesHandler(&left))
+	b1 := newFakeRedis(t, clusterNodesHandler(&left))
+	c1 := newFakeRedis(t, clusterNodesHandler(&right))
+	d1 := newFakeRedis(t, clusterNodesHandler(&right))
+	left = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-8191"),
+		fakeNodeLine("B", b1.Addr(), "slave", "A"),
+		fakeNodeLine("C", c1.Addr(), "master,fail", "-", "8192-16383"),
+		fakeNodeLine("D", d1.Addr(), "slave,fail", "C"),
+	)
+	right = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master,fail", "-", "0-8191"),
+		fakeNodeLine("B", b1.Addr(), "slave,fail", "A"),
+		fakeNodeLine("C", c1.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("D", d1.Addr(), "slave", "C"),
+	)
+	a := newTestAdmin(b1.Addr())
+
+	if err := a.HealPartition(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calls := a1.Calls("CLUSTER MEET")
+	if len(calls) != 1 || calls[0][2] != c1.IP() || calls[0][3] != c1.Port() {
+		t.Errorf("expected A to meet C, got %v", calls)
+	}
+	for _, fake := range []*fakeRedis{b1, c1, d1} {
+		if len(fake.Calls("CLUSTER MEET")) != 0 {
+			t.Errorf("expected a single CLUSTER MEET")
+		}
+	}
+
+	// once merged, nothing is done
+	left = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-8191"),
+		fakeNodeLine("B", b1.Addr(), "slave", "A"),
+		fakeNodeLine("C", c1.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("D", d1.Addr(), "slave", "C"),
+	)
+	right = left
+	if err := a.HealPartition(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(a1.Calls("CLUSTER MEET")) != 1 {
+		t.Errorf("expected no CLUSTER MEET once the partitions are merged")
+	}
+}
+
+func TestAdminGetClusterNodesFromAll(t *testing.T) {
+	var viewA, viewB, viewC string
+	a1 := newFakeRedis(t, clusterNodesHandler(&viewA))
+	b1 := newFakeRedis(t, clusterNodesHandler(&viewB))
+	c1 := newFakeRedis(t, clusterNodesHandler(&viewC))
+	// B is cut from A and promoted itself, C still sees it as a replica
+	viewA = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", b1.Addr(), "slave,fail", "A"),
+		fakeNodeLine("C", c1.Addr(), "master", "-", "8192-16383"),
+	)
+	viewB = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master,fail", "-"),
+		fakeNodeLine("B", b1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("C", c1.Addr(), "master", "-", "8192-16383"),
+	)
+	viewC = fakeClusterNodes(
+		fakeNodeLine("A", a1.Addr(), "master", "-", "0-8191"),
+		fakeNodeLine("B", b1.Addr(), "slave", "A"),
+		fakeNodeLine("C", c1.Addr(), "myself,master", "-", "8192-16383"),
+	)
+	a := newTestAdmin(c1.Addr())
+
+	nodes, err := a.GetClusterNodesFromAll(context.Background())
+	if !IsTopologyDisagreementError(err) {
+		t.Fatalf("expected a TopologyDisagreementError, got %v", err)
+	}
+	want := []string{
+		"node A seen failing by B and healthy by A,C",
+		"node B seen as master by B and slave by A,C",
+		"node B seen failing by A and healthy by B,C",
+	}
+	if got := err.(TopologyDisagreementError).Disagreements; !reflect.DeepEqual(got, want) {
+		t.Errorf("Disagreements = %v, want %v", got, want)
+	}
+	if len(*nodes) != 3 {
+		t.Fatalf("expected 3 merged nodes, got %d", len(*nodes))
+	}
+	for _, node := range *nodes {
+		if !node.Myself {
+			t.Errorf("expected node %s to be taken from its own view", node.ID)
+		}
+	}
+	if b := (*nodes)[1]; b.GetRole() != RedisMasterRole || len(b.Slots) != 8192 {
+		t.Errorf("expected B as master from its own view, got %s", b)
+	}
+
+	viewA, viewB = viewC, viewC
+	if _, err := a.GetClusterNodesFromAll(context.Background()); err != nil {
+		t.Errorf("unexpected error on agreeing views: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ZoneLabel well-known label of the zone a Pod's Kubernetes node runs in
+const ZoneLabel = "topology.kubernetes.io/zone"
+
+// PodZone returns the zone of the Pod, from its ZoneLabel, empty if unknown
+func PodZone(pod *corev1.Pod) string {
+	if pod == nil {
+		return ""
+	}
+	return pod.Labels[ZoneLabel]
+}
+
+// SetZones sets the Zone of the nodes from their Pod, nodes without Pod are left unchanged
+func (n Nodes) SetZones() {
+	for _, node := range n {
+		if node.Pod != nil {
+			node.Zone = PodZone(node.Pod)
+		}
+	}
+}
+
+// sameZone returns true if both nodes are known to run in the same zone
+func sameZone(n1, n2 *Node) bool {
+	return n1.Zone != "" && n1.Zone == n2.Zone
+}
+
+// ZoneViolations returns the IDs of the masters having at least one replica in their own zone, the ones
+// a zone outage would leave without a copy of their data
+func (n Nodes) ZoneViolations() []string {
+	groups := n.GroupByMaster()
+	violations := []string{}
+	for _, master := range n.FilterByFunc(func(node *Node) bool { return node.GetRole() == RedisMasterRole }) {
+		for _, replica := range groups[master.ID] {
+			if sameZone(master, replica) {
+				violations = append(violations, master.ID)
+				break
+			}
+		}
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+// zonesCovered returns the number of distinct known zones the nodes run in
+func zonesCovered(nodes Nodes) int {
+	zones := map[string]bool{}
+	for _, node := range nodes {
+		if node.Zone != "" {
+			zones[node.Zone] = true
+		}
+	}
+	return len(zones)
+}
+
+// SafestReplicaToRemove returns the replica whose removal costs the least redundancy, when scaling down the
+// number of replicas: it belongs to the master having the most replicas, the lowest ID first on ties. Among
+// that master replicas, a failing one is preferred, then the one whose removal leaves the master and its
+// remaining replicas spread across the most zones, then the lowest ID.
+func (n Nodes) SafestReplicaToRemove() (*Node, error) {
+	groups := n.GroupByMaster()
+	var master *Node
+	for _, node := range n.FilterByFunc(func(node *Node) bool { return node.GetRole() == RedisMasterRole }).SortNodes() {
+		if master == nil || len(groups[node.ID]) > len(groups[master.ID]) {
+			master = node
+		}
+	}
+	if master == nil || len(groups[master.ID]) == 0 {
+		return nil, fmt.Errorf("no replica to remove")
+	}
+
+	replicas := groups[master.ID].SortNodes()
+	covered := func(removed *Node) int {
+		remaining := Nodes{master}
+		for _, replica := range replicas {
+			if replica != removed {
+				remaining = append(remaining, replica)
+			}
+		}
+		return zonesCovered(remaining)
+	}
+	var best *Node
+	for _, replica := range replicas {
+		if best == nil {
+			best = replica
+			continue
+		}
+		if failing, bestFailing := contactRank(replica) == 0, contactRank(best) == 0; failing != bestFailing {
+			if failing {
+				best = replica
+			}
+			continue
+		}
+		if covered(replica) > covered(best) {
+			best = replica
+		}
+	}
+	return best, nil
+}
+
+// k8sNodeName returns the Kubernetes node name the Node Pod is scheduled on, empty if unknown
+func (n *Node) k8sNodeName() string {
+	if n.Pod == nil {
+		return ""
+	}
+	return n.Pod.Spec.NodeName
+}
+
+// sameK8sNode returns true if both nodes Pods are known to be scheduled on the same Kubernetes node
+func sameK8sNode(n1, n2 *Node) bool {
+	return n1.k8sNodeName() != "" && n1.k8sNodeName() == n2.k8sNodeName()
+}
+
+// AssignReplicas returns a replica ID to master ID assignment spreading the replicas evenly across the
+// masters. Among the least loaded masters, a master scheduled on another Kubernetes node (Pod spec.nodeName)
+// than the replica is preferred, so that anti-affinity is honored where possible.
+func AssignReplicas(masters, replicas Nodes) (map[string]string, error) {
+	if len(masters) == 0 {
+		return nil, fmt.Errorf("no master to assign %d replicas to", len(replicas))
+	}
+	sortedMasters := make(Nodes, len(masters))
+	copy(sortedMasters, masters)
+	sort.Sort(sortedMasters)
+	sortedReplicas := make(Nodes, len(replicas))
+	copy(sortedReplicas, replicas)
+	sort.Sort(sortedReplicas)
+
+	assignment := make(map[string]string, len(replicas))
+	counts := make(map[string]int, len(masters))
+	for _, replica := range sortedReplicas {
+		candidates := make(Nodes, len(sortedMasters))
+		copy(candidates, sortedMasters)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			ci, cj := counts[candidates[i].ID], counts[candidates[j].ID]
+			if ci != cj {
+				return ci < cj
+			}
+			return !sameK8sNode(candidates[i], replica) && sameK8sNode(candidates[j], replica)
+		})
+		master := candidates[0]
+		assignment[replica.ID] = master.ID
+		counts[master.ID]++
+	}
+	return assignment, nil
+}
+
+// SetPods provides the Pods of the redis nodes, matched to the nodes by Pod IP, so that the placement
+// related methods know the nodes zone
+func (a *Admin) SetPods(pods []*corev1.Pod) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pods = pods
+}
+
+// attachPods sets the Pod and Zone of the nodes whose IP matches a Pod provided with SetPods
+func (a *Admin) attachPods(nodes Nodes) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	byIP := make(map[string]*corev1.Pod, len(a.pods))
+	for _, pod := range a.pods {
+		if pod.Status.PodIP != "" {
+			byIP[pod.Status.PodIP] = pod
+		}
+	}
+	for _, node := range nodes {
+		if pod, ok := byIP[node.IP]; ok {
+			node.Pod = pod
+		}
+	}
+	nodes.SetZones()
+}
+
+// BalanceAcrossZones swaps the masters of pairs of replicas, with CLUSTER REPLICATE, so that no replica runs in
+// the zone of its master. Swapping keeps the number of replicas of each master. The zones come from the Pods
+// provided with SetPods. It returns the "replica->master" reassignments done, and an error listing the
+// replicas left in the zone of their master when no other replica could be swapped with them.
+func (a *Admin) BalanceAcrossZones(ctx context.Context) ([]string, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	a.attachPods(*nodes)
+	sort.Sort(*nodes)
+
+	masterOf := func(replica *Node) *Node {
+		master, _ := nodes.GetNodeByID(replica.MasterReferent)
+		return master
+	}
+	replicas := nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisSlaveRole && masterOf(n) != nil })
+
+	reassignments := []string{}
+	unresolved := []string{}
+	for _, replica := range replicas {
+		master := masterOf(replica)
+		if !sameZone(master, replica) {
+			continue
+		}
+		var swap *Node
+		for _, other := range replicas {
+			otherMaster := masterOf(other)
+			if otherMaster.ID == master.ID || other.Zone == "" || otherMaster.Zone == "" {
+				continue
+			}
+			if other.Zone != master.Zone && replica.Zone != otherMaster.Zone {
+				swap = other
+				break
+			}
+		}
+		if swap == nil {
+			unresolved = append(unresolved, fmt.Sprintf("replica %s of master %s in zone %s", replica.ID, master.ID, master.Zone))
+			continue
+		}
+
+		otherMaster := masterOf(swap)
+		for _, move := range []struct{ replica, master *Node }{{replica, otherMaster}, {swap, master}} {
+			if _, err := a.AttachSlaveToMaster(ctx, move.replica, move.master.ID); err != nil {
+				return reassignments, err
+			}
+			logger.Infof("replica %s moved from zone %s master to zone %s master %s", move.replica.ID, masterOf(move.replica).Zone, move.master.Zone, move.master.ID)
+			move.replica.MasterReferent = move.master.ID
+			reassignments = append(reassignments, move.replica.ID+"->"+move.master.ID)
+		}
+	}
+	if len(unresolved) > 0 {
+		return reassignments, fmt.Errorf("not enough nodes in other zones to rehome %s", strings.Join(unresolved, ", "))
+	}
+	return reassignments, nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// nodeOnK8sNode returns a Node whose Pod is scheduled on the Kubernetes node
+func nodeOnK8sNode(id, k8sNode string) *Node {
+	return NewNode(id, "", &corev1.Pod{Spec: corev1.PodSpec{NodeName: k8sNode}})
+}
+
+func TestAssignReplicas(t *testing.T) {
+	tests := []struct {
+		name     string
+		masters  Nodes
+		replicas Nodes
+		want     map[string]string
+	}{
+		{
+			name:     "anti-affinity possible",
+			masters:  Nodes{nodeOnK8sNode("M1", "k1"), nodeOnK8sNode("M2", "k2")},
+			replicas: Nodes{nodeOnK8sNode("R1", "k1"), nodeOnK8sNode("R2", "k2")},
+			want:     map[string]string{"R1": "M2", "R2": "M1"},
+		},
+		{
+			name:     "anti-affinity impossible on a single kubernetes node",
+			masters:  Nodes{nodeOnK8sNode("M1", "k1"), nodeOnK8sNode("M2", "k1")},
+			replicas: Nodes{nodeOnK8sNode("R1", "k1"), nodeOnK8sNode("R2", "k1")},
+			want:     map[string]string{"R1": "M1", "R2": "M2"},
+		},
+		{
+			name:     "several replicas per master",
+			masters:  Nodes{nodeOnK8sNode("M1", "k1"), nodeOnK8sNode("M2", "k2")},
+			replicas: Nodes{nodeOnK8sNode("R1", "k2"), nodeOnK8sNode("R2", "k1"), nodeOnK8sNode("R3", "k3"), nodeOnK8sNode("R4", "k3")},
+			want:     map[string]string{"R1": "M1", "R2": "M2", "R3": "M1", "R4": "M2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AssignReplicas(tt.masters, tt.replicas)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AssignReplicas() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := AssignReplicas(Nodes{}, Nodes{nodeOnK8sNode("R1", "k1")}); err == nil {
+		t.Errorf("expected an error without master")
+	}
+}
+
+// nodeInZone returns a Node with the role whose Pod is labeled with the zone
+func nodeInZone(id, role, master, zone string) *Node {
+	node := NewNode(id, "", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{ZoneLabel: zone}}})
+	node.Role = role
+	node.MasterReferent = master
+	return node
+}
+
+func TestNodesZoneViolations(t *testing.T) {
+	nodes := Nodes{
+		nodeInZone("M1", RedisMasterRole, "", "zone-a"),
+		nodeInZone("M2", RedisMasterRole, "", "zone-b"),
+		nodeInZone("R1", RedisSlaveRole, "M1", "zone-b"),
+		nodeInZone("R2", RedisSlaveRole, "M2", "zone-b"),
+		NewNode("R3", "", nil),
+	}
+	nodes[4].Role, nodes[4].MasterReferent = RedisSlaveRole, "M1"
+	if nodes[0].Zone != "zone-a" {
+		t.Errorf("expected the zone to be set from the Pod label, got %q", nodes[0].Zone)
+	}
+
+	if got, want := nodes.ZoneViolations(), []string{"M2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ZoneViolations() = %v, want %v", got, want)
+	}
+
+	nodes[3].Pod.Labels[ZoneLabel] = "zone-a"
+	nodes.SetZones()
+	if got := nodes.ZoneViolations(); len(got) != 0 {
+		t.Errorf("expected no violation once R2 moved to zone-a, got %v", got)
+	}
+}
+
+func TestNodesSafestReplicaToRemove(t *testing.T) {
+	// M2 has the most replicas, only removing R3 keeps them spread across zone-a, zone-b and zone-c
+	nodes := Nodes{
+		nodeInZone("M1", RedisMasterRole, "", "zone-a"),
+		nodeInZone("M2", RedisMasterRole, "", "zone-a"),
+		nodeInZone("R1", RedisSlaveRole, "M1", "zone-b"),
+		nodeInZone("R2", RedisSlaveRole, "M2", "zone-b"),
+		nodeInZone("R3", RedisSlaveRole, "M2", "zone-a"),
+		nodeInZone("R4", RedisSlaveRole, "M2", "zone-c"),
+	}
+	for _, node := range nodes {
+		node.LinkState = RedisLinkStateConnected
+	}
+
+	replica, err := nodes.SafestReplicaToRemove()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replica.ID != "R3" {
+		t.Errorf("expected R3, sharing its master zone, got %s", replica.ID)
+	}
+
+	nodes[5].LinkState = RedisLinkStateDisconnected
+	if replica, err := nodes.SafestReplicaToRemove(); err != nil || replica.ID != "R4" {
+		t.Errorf("expected the disconnected R4, got %v, %v", replica, err)
+	}
+
+	if _, err := nodes[:2].SafestReplicaToRemove(); err == nil {
+		t.Errorf("expected an error without replica")
+	}
+}
+
+// podInZone returns a Pod with the IP labeled with the zone
+func podInZone(ip, zone string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{ZoneLabel: zone}},
+		Status:     corev1.PodStatus{PodIP: ip},
+	}
+}
+
+func TestAdminBalanceAcrossZones(t *testing.T) {
+	var view string
+	r1 := newFakeRedisOn(t, "127.0.0.3", clusterNodesHandler(&view))
+	r2 := newFakeRedisOn(t, "127.0.0.4", clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("M1", "10.0.0.1:6379", "master", "-", "0-8191"),
+		fakeNodeLine("M2", "10.0.0.2:6379", "master", "-", "8192-16383"),
+		fakeNodeLine("R1", r1.Addr(), "myself,slave", "M1"),
+		fakeNodeLine("R2", r2.Addr(), "slave", "M2"),
+	)
+	a := newTestAdmin(r1.Addr())
+	a.SetPods([]*corev1.Pod{
+		podInZone("10.0.0.1", "zone-a"),
+		podInZone("10.0.0.2", "zone-b"),
+		podInZone(r1.IP(), "zone-a"),
+		podInZone(r2.IP(), "zone-b"),
+	})
+
+	reassignments, err := a.BalanceAcrossZones(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"R1->M2", "R2->M1"}; !reflect.DeepEqual(reassignments, want) {
+		t.Errorf("BalanceAcrossZones() = %v, want %v", reassignments, want)
+	}
+	if calls := r1.Calls("CLUSTER REPLICATE"); len(calls) != 1 || calls[0][2] != "M2" {
+		t.Errorf("expected R1 to replicate M2, got %v", calls)
+	}
+	if calls := r2.Calls("CLUSTER REPLICATE"); len(calls) != 1 || calls[0][2] != "M1" {
+		t.Errorf("expected R2 to replicate M1, got %v", calls)
+	}
+}
+
+func TestAdminBalanceAcrossZonesSingleZone(t *testing.T) {
+	var view string
+	r1 := newFakeRedisOn(t, "127.0.0.3", clusterNodesHandler(&view))
+	r2 := newFakeRedisOn(t, "127.0.0.4", clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("M1", "10.0.0.1:6379", "master", "-", "0-8191"),
+		fakeNodeLine("M2", "10.0.0.2:6379", "master", "-", "8192-16383"),
+		fakeNodeLine("R1", r1.Addr(), "myself,slave", "M1"),
+		fakeNodeLine("R2", r2.Addr(), "slave", "M2"),
+	)
+	a := newTestAdmin(r1.Addr())
+	a.SetPods([]*corev1.Pod{
+		podInZone("10.0.0.1", "zone-a"),
+		podInZone("10.0.0.2", "zone-a"),
+		podInZone(r1.IP(), "zone-a"),
+		podInZone(r2.IP(), "zone-a"),
+	})
+
+	reassignments, err := a.BalanceAcrossZones(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "replica R1 of master M1 in zone zone-a") {
+		t.Errorf("expected an error reporting R1 can't be rehomed, got %v", err)
+	}
+	if len(reassignments) != 0 || len(r1.Calls("CLUSTER REPLICATE"))+len(r2.Calls("CLUSTER REPLICATE")) != 0 {
+		t.Errorf("expected no reassignment within a single zone, got %v", reassignments)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pooledAdmin an Admin cached by the AdminPool
+type pooledAdmin struct {
+	admin    AdminInterface
+	refs     int
+	lastUsed time.Time
+}
+
+// AdminPool caches the Admins of several clusters to reuse their connections across reconciles.
+// An Admin is identified by its sorted seed addresses and a hash of its options. Admins that are
+// not used anymore (every Get has been released) are closed and evicted once idle for idleTimeout.
+type AdminPool struct {
+	mu          sync.Mutex
+	idleTimeout time.Duration
+	admins      map[string]*pooledAdmin
+}
+
+// NewAdminPool builds and returns new AdminPool instance
+func NewAdminPool(idleTimeout time.Duration) *AdminPool {
+	return &AdminPool{
+		idleTimeout: idleTimeout,
+		admins:      map[string]*pooledAdmin{},
+	}
+}
+
+// clusterIdentity returns the key identifying a cluster in the pool. The options are hashed: the username,
+// the password, the timeouts, the promotion lag and the TLS identity, made of the client certificates, the
+// root CAs and the server name verification.
+func clusterIdentity(addrs []string, opts AdminOptions) string {
+	sorted := append([]string{}, addrs...)
+	sort.Strings(sorted)
+	h := sha256.New()
+	fmt.Fprintf(h, "%q/%q/%d/%d/%d", opts.Username, opts.Password, opts.JoinTimeout, opts.PromoteTimeout, opts.PromoteMaxLag)
+	if tlsConfig := opts.TLSConfig; tlsConfig != nil {
+		fmt.Fprintf(h, "/tls/%q/%t", tlsConfig.ServerName, tlsConfig.InsecureSkipVerify)
+		for _, cert := range tlsConfig.Certificates {
+			for _, der := range cert.Certificate {
+				fmt.Fprintf(h, "/cert/%x", der)
+			}
+		}
+		if tlsConfig.RootCAs != nil {
+			for _, subject := range tlsConfig.RootCAs.Subjects() {
+				fmt.Fprintf(h, "/ca/%x", subject)
+			}
+		}
+	}
+	return strings.Join(sorted, ",") + "/" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the Admin of the cluster connecting with the options, creating it if needed. Each Get must be
+// followed by a Release with the same options.
+func (p *AdminPool) Get(addrs []string, opts AdminOptions) AdminInterface {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evictIdle()
+
+	key := clusterIdentity(addrs, opts)
+	pooled, ok := p.admins[key]
+	if !ok {
+		pooled = &pooledAdmin{admin: NewAdminWithOptions(addrs, opts)}
+		p.admins[key] = pooled
+	}
+	pooled.refs++
+	pooled.lastUsed = time.Now()
+	return pooled.admin
+}
+
+// Release releases an Admin previously returned by Get
+func (p *AdminPool) Release(addrs []string, opts AdminOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pooled, ok := p.admins[clusterIdentity(addrs, opts)]; ok && pooled.refs > 0 {
+		pooled.refs--
+		pooled.lastUsed = time.Now()
+	}
+	p.evictIdle()
+}
+
+// EvictIdle closes and removes the Admins idle for longer than the idle timeout
+func (p *AdminPool) EvictIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evictIdle()
+}
+
+func (p *AdminPool) evictIdle() {
+	for key, pooled := range p.admins {
+		if pooled.refs == 0 && time.Since(pooled.lastUsed) > p.idleTimeout {
+			pooled.admin.CloseClient()
+			pooled.admin.CloseClusterClient()
+			delete(p.admins, key)
+		}
+	}
+}
+
+// Close closes and removes all the Admins of the pool
+func (p *AdminPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, pooled := range p.admins {
+		pooled.admin.CloseClient()
+		pooled.admin.CloseClusterClient()
+		delete(p.admins, key)
+	}
+}
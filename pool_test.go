@@ -0,0 +1,66 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestAdminPool(t *testing.T) {
+	pool := NewAdminPool(20 * time.Millisecond)
+	defer pool.Close()
+
+	a1 := pool.Get([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, AdminOptions{Password: "secret"})
+	a2 := pool.Get([]string{"10.0.0.2:6379", "10.0.0.1:6379"}, AdminOptions{Password: "secret"})
+	if a1 != a2 {
+		t.Errorf("expected the same Admin for identical cluster identities")
+	}
+	if other := pool.Get([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, AdminOptions{Password: "other"}); other == a1 {
+		t.Errorf("expected a different Admin for different credentials")
+	}
+	pool.Release([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, AdminOptions{Password: "other"})
+	user := AdminOptions{Username: "admin", Password: "secret"}
+	if other := pool.Get([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, user); other == a1 || other.(*Admin).opts.Username != "admin" {
+		t.Errorf("expected a different Admin authenticating as the ACL user")
+	}
+	pool.Release([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, user)
+	secure := AdminOptions{Password: "secret", TLSConfig: &tls.Config{ServerName: "redis"}}
+	if other := pool.Get([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, secure); other == a1 {
+		t.Errorf("expected a different Admin for a different TLS configuration")
+	}
+	pool.Release([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, secure)
+
+	// still in use
+	time.Sleep(40 * time.Millisecond)
+	pool.EvictIdle()
+	if a3 := pool.Get([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, AdminOptions{Password: "secret"}); a3 != a1 {
+		t.Errorf("expected an Admin in use not to be evicted")
+	}
+	for i := 0; i < 3; i++ {
+		pool.Release([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, AdminOptions{Password: "secret"})
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	pool.EvictIdle()
+	if len(pool.admins) != 0 {
+		t.Errorf("expected idle Admins to be evicted, %d remaining", len(pool.admins))
+	}
+	if a4 := pool.Get([]string{"10.0.0.1:6379", "10.0.0.2:6379"}, AdminOptions{Password: "secret"}); a4 == a1 {
+		t.Errorf("expected a new Admin after idle eviction")
+	}
+}
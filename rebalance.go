@@ -0,0 +1,582 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// SlotMigration represents the move of a slot from a master ID to another
+type SlotMigration struct {
+	Slot Slot   `json:"slot"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// String string representation of a slot migration
+func (m SlotMigration) String() string {
+	return fmt.Sprintf("%s: %s->%s", m.Slot, m.From, m.To)
+}
+
+// ReshardPlan represents slot migrations and replica reassignments computed without being executed
+type ReshardPlan struct {
+	// Migrations slot moves, in execution order
+	Migrations []SlotMigration `json:"migrations"`
+	// SlotsPerTarget number of slots received by each destination master ID
+	SlotsPerTarget map[string]int `json:"slotsPerTarget"`
+	// ReplicaReassignments new master ID of each moved replica ID
+	ReplicaReassignments map[string]string `json:"replicaReassignments"`
+}
+
+// newReshardPlan returns an empty ReshardPlan
+func newReshardPlan() ReshardPlan {
+	return ReshardPlan{
+		Migrations:           []SlotMigration{},
+		SlotsPerTarget:       map[string]int{},
+		ReplicaReassignments: map[string]string{},
+	}
+}
+
+// OrderMigrations returns the migrations grouped by destination, in destination ID order, then by source
+// and slot order so that contiguous slot ranges move one after the other. The clients routing tables then
+// stabilize faster than with interleaved migrations, reducing the MOVED redirects during a reshard.
+func OrderMigrations(migs []SlotMigration) []SlotMigration {
+	ordered := append([]SlotMigration{}, migs...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].To != ordered[j].To {
+			return ordered[i].To < ordered[j].To
+		}
+		if ordered[i].From != ordered[j].From {
+			return ordered[i].From < ordered[j].From
+		}
+		return ordered[i].Slot < ordered[j].Slot
+	})
+	return ordered
+}
+
+// SlotsToMove returns the approximate number of slots migrated to rebalance evenly the slots when changing
+// the number of masters from currentMasters to targetMasters: the share of the new masters on scale up, the
+// share of the removed ones on scale down.
+func SlotsToMove(currentMasters, targetMasters int, hashMax Slot) int {
+	if currentMasters < 1 || targetMasters < 1 || currentMasters == targetMasters {
+		return 0
+	}
+	total := int(hashMax) + 1
+	if targetMasters > currentMasters {
+		return (targetMasters - currentMasters) * total / targetMasters
+	}
+	return (currentMasters - targetMasters) * total / currentMasters
+}
+
+// RebalanceMode defines what is balanced across the masters
+type RebalanceMode string
+
+const (
+	// RebalanceBySlotCount balances the number of slots owned by each master
+	RebalanceBySlotCount RebalanceMode = "SlotCount"
+	// RebalanceByKeyCount balances the number of keys stored by each master
+	RebalanceByKeyCount RebalanceMode = "KeyCount"
+)
+
+// ComputeKeyCountRebalancePlan returns the slot migrations balancing the number of keys across the masters,
+// using the number of keys of each slot (see SlotKeyHistogram). At each step the master storing the most
+// keys gives the receiving master the biggest slot that doesn't overshoot the balance, so the high count
+// slots move first and the plan moves as few slots as possible. A master owning slots always keeps one.
+func ComputeKeyCountRebalancePlan(masters Nodes, histogram map[Slot]int64) []SlotMigration {
+	type load struct {
+		id    string
+		slots []Slot
+		keys  int64
+	}
+	loads := []*load{}
+	for _, master := range masters.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole }) {
+		l := &load{id: master.ID, slots: append([]Slot{}, master.Slots...)}
+		for _, slot := range l.slots {
+			l.keys += histogram[slot]
+		}
+		loads = append(loads, l)
+	}
+	if len(loads) < 2 {
+		return []SlotMigration{}
+	}
+
+	plan := []SlotMigration{}
+	for {
+		sort.Slice(loads, func(i, j int) bool {
+			if loads[i].keys != loads[j].keys {
+				return loads[i].keys > loads[j].keys
+			}
+			return loads[i].id < loads[j].id
+		})
+		donor, receiver := loads[0], loads[len(loads)-1]
+		gap := donor.keys - receiver.keys
+		if gap <= 1 || len(donor.slots) <= 1 {
+			break
+		}
+
+		// the best slot is the biggest one fitting in half of the gap, otherwise the smallest
+		// one that still reduces the gap
+		best := -1
+		for i, slot := range donor.slots {
+			count := histogram[slot]
+			if count == 0 || count >= gap {
+				continue
+			}
+			if best == -1 {
+				best = i
+				continue
+			}
+			bestCount := histogram[donor.slots[best]]
+			fits, bestFits := 2*count <= gap, 2*bestCount <= gap
+			switch {
+			case fits && !bestFits:
+				best = i
+			case fits && bestFits && (count > bestCount || (count == bestCount && slot < donor.slots[best])):
+				best = i
+			case !fits && !bestFits && (count < bestCount || (count == bestCount && slot < donor.slots[best])):
+				best = i
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		slot := donor.slots[best]
+		donor.slots = append(donor.slots[:best], donor.slots[best+1:]...)
+		receiver.slots = append(receiver.slots, slot)
+		donor.keys -= histogram[slot]
+		receiver.keys += histogram[slot]
+		plan = append(plan, SlotMigration{Slot: slot, From: donor.id, To: receiver.id})
+	}
+	return plan
+}
+
+// ComputeKeyCountRebalancePlan returns the slot migrations balancing the number of keys across the masters
+func (a *Admin) ComputeKeyCountRebalancePlan(ctx context.Context) ([]SlotMigration, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	histogram, err := a.SlotKeyHistogram(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeKeyCountRebalancePlan(*nodes, histogram), nil
+}
+
+// ComputeRebalancePlan returns the fewest slot migrations giving every master owning slots the same number of
+// slots, (HashMaxSlots+1)/masters on a covered cluster. The remaining slots are left to the masters owning the
+// most slots, the lowest ID first, as they would have to give them anyway. Empty masters are considered empty
+// on purpose: they aren't given any slot, and a master owning slots always keeps some. Each master in excess
+// gives its highest slots so the slot ranges stay contiguous. The migrations are ordered with OrderMigrations.
+func ComputeRebalancePlan(masters Nodes) []SlotMigration {
+	owners := masters.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole && len(n.Slots) > 0 })
+	if len(owners) < 2 {
+		return []SlotMigration{}
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		if len(owners[i].Slots) != len(owners[j].Slots) {
+			return len(owners[i].Slots) > len(owners[j].Slots)
+		}
+		return owners[i].ID < owners[j].ID
+	})
+	total := 0
+	for _, owner := range owners {
+		total += len(owner.Slots)
+	}
+	targets := make(map[string]int, len(owners))
+	for i, owner := range owners {
+		targets[owner.ID] = total / len(owners)
+		if i < total%len(owners) {
+			targets[owner.ID]++
+		}
+	}
+
+	given := []SlotMigration{}
+	for _, owner := range owners {
+		if excess := len(owner.Slots) - targets[owner.ID]; excess > 0 {
+			slots := append([]Slot{}, owner.Slots...)
+			sort.Sort(SlotSlice(slots))
+			for _, slot := range slots[len(slots)-excess:] {
+				given = append(given, SlotMigration{Slot: slot, From: owner.ID})
+			}
+		}
+	}
+	sort.Sort(owners)
+	plan := []SlotMigration{}
+	for _, owner := range owners {
+		for missing := targets[owner.ID] - len(owner.Slots); missing > 0 && len(given) > 0; missing-- {
+			mig := given[0]
+			mig.To = owner.ID
+			plan = append(plan, mig)
+			given = given[1:]
+		}
+	}
+	return OrderMigrations(plan)
+}
+
+// ComputeRebalancePlan returns the slot migrations balancing the number of slots across the masters owning
+// slots, without executing them (see ComputeRebalancePlan)
+func (a *Admin) ComputeRebalancePlan(ctx context.Context) ([]SlotMigration, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeRebalancePlan(*nodes), nil
+}
+
+// RebalanceSlots balances the number of slots across the masters owning slots, running the migrations of
+// ComputeRebalancePlan one at a time with MigrateSlot. It stops on the first failing migration.
+func (a *Admin) RebalanceSlots(ctx context.Context) error {
+	return a.RebalanceSlotsWithMode(ctx, RebalanceBySlotCount)
+}
+
+// RebalanceSlotsWithMode balances the masters owning slots according to the mode: the number of slots with
+// RebalanceBySlotCount (or an empty mode), like RebalanceSlots, the number of keys with RebalanceByKeyCount,
+// running the migrations of ComputeKeyCountRebalancePlan grouped by OrderMigrations. The migrations run one
+// at a time with MigrateSlot, it stops on the first failing one.
+func (a *Admin) RebalanceSlotsWithMode(ctx context.Context, mode RebalanceMode) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	if mode == "" {
+		mode = RebalanceBySlotCount
+	}
+	var plan []SlotMigration
+	switch mode {
+	case RebalanceBySlotCount:
+		plan = ComputeRebalancePlan(*nodes)
+	case RebalanceByKeyCount:
+		histogram, err := a.SlotKeyHistogram(ctx)
+		if err != nil {
+			return err
+		}
+		plan = OrderMigrations(ComputeKeyCountRebalancePlan(*nodes, histogram))
+	default:
+		return fmt.Errorf("unknown rebalance mode %q", mode)
+	}
+	logger.Infof("rebalancing slots by %s: %d slot(s) to migrate", mode, len(plan))
+	for _, mig := range plan {
+		src, err := nodes.GetNodeByID(mig.From)
+		if err != nil {
+			return err
+		}
+		dst, err := nodes.GetNodeByID(mig.To)
+		if err != nil {
+			return err
+		}
+		if err := a.MigrateSlot(ctx, mig.Slot, src, dst, migrateTimeout); err != nil {
+			return fmt.Errorf("unable to rebalance slots, migration %s failed: %v", mig, err)
+		}
+	}
+	return nil
+}
+
+// PlanReshard returns, without executing anything, the plan moving numSlots slots from the source master to
+// the destination master. The highest slots of the source are picked, so the moved range stays contiguous.
+func (a *Admin) PlanReshard(ctx context.Context, sourceID, destID string, numSlots int) (*ReshardPlan, error) {
+	if sourceID == destID {
+		return nil, fmt.Errorf("unable to reshard from master %s to itself", sourceID)
+	}
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	src, err := nodes.GetNodeByID(sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("source master %s not found: %v", sourceID, err)
+	}
+	dst, err := nodes.GetNodeByID(destID)
+	if err != nil {
+		return nil, fmt.Errorf("destination master %s not found: %v", destID, err)
+	}
+	for _, node := range []*Node{src, dst} {
+		if node.GetRole() != RedisMasterRole {
+			return nil, fmt.Errorf("unable to reshard with node %s, not a master", node.ID)
+		}
+	}
+	if numSlots < 1 || numSlots > len(src.Slots) {
+		return nil, fmt.Errorf("unable to move %d slots from master %s owning %d slots", numSlots, sourceID, len(src.Slots))
+	}
+
+	slots := append([]Slot{}, src.Slots...)
+	sort.Sort(SlotSlice(slots))
+	plan := newReshardPlan()
+	for _, slot := range slots[len(slots)-numSlots:] {
+		plan.Migrations = append(plan.Migrations, SlotMigration{Slot: slot, From: sourceID, To: destID})
+	}
+	plan.SlotsPerTarget[destID] = numSlots
+	return &plan, nil
+}
+
+// ExecutePlan runs the migrations of the plan in order with MigrateSlot, then attaches the reassigned replicas
+// to their new master, in replica ID order. It stops on the first failure.
+func (a *Admin) ExecutePlan(ctx context.Context, plan *ReshardPlan) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	for _, mig := range plan.Migrations {
+		src, err := nodes.GetNodeByID(mig.From)
+		if err != nil {
+			return fmt.Errorf("source master %s of migration %s not found: %v", mig.From, mig, err)
+		}
+		dst, err := nodes.GetNodeByID(mig.To)
+		if err != nil {
+			return fmt.Errorf("destination master %s of migration %s not found: %v", mig.To, mig, err)
+		}
+		if err := a.MigrateSlot(ctx, mig.Slot, src, dst, migrateTimeout); err != nil {
+			return err
+		}
+	}
+
+	replicaIDs := make([]string, 0, len(plan.ReplicaReassignments))
+	for id := range plan.ReplicaReassignments {
+		replicaIDs = append(replicaIDs, id)
+	}
+	sort.Strings(replicaIDs)
+	for _, id := range replicaIDs {
+		replica, err := nodes.GetNodeByID(id)
+		if err != nil {
+			return fmt.Errorf("replica %s not found: %v", id, err)
+		}
+		if _, err := a.AttachSlaveToMaster(ctx, replica, plan.ReplicaReassignments[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EstimateReshardDuration returns the time the migrations should take at keysPerSec migrated keys per second,
+// along with the total number of keys to migrate, counted with COUNTKEYSINSLOT on the source masters
+func (a *Admin) EstimateReshardDuration(ctx context.Context, migs []SlotMigration, keysPerSec float64) (time.Duration, int64, error) {
+	if keysPerSec <= 0 {
+		return 0, 0, fmt.Errorf("invalid migration rate %v keys per second", keysPerSec)
+	}
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	slotsByMaster := make(map[string][]Slot)
+	for _, mig := range migs {
+		slotsByMaster[mig.From] = append(slotsByMaster[mig.From], mig.Slot)
+	}
+	var total int64
+	for id, slots := range slotsByMaster {
+		master, err := nodes.GetNodeByID(id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("source master %s not found: %v", id, err)
+		}
+		c := a.nodeClient(master.IPPort())
+		counts, err := countKeysInSlots(ctx, c, slots)
+		c.Close()
+		if err != nil {
+			return 0, 0, fmt.Errorf("unable to count keys in slots of master %s: %v", id, err)
+		}
+		for _, count := range counts {
+			total += count
+		}
+	}
+	return time.Duration(float64(total) / keysPerSec * float64(time.Second)), total, nil
+}
+
+const (
+	// busyClientsThreshold number of connected clients from which a master is considered busy
+	busyClientsThreshold = 1000
+	// busyCPUThreshold CPU usage (used_cpu_sys+used_cpu_user per second between two samples) from which a
+	// master is considered busy
+	busyCPUThreshold = 0.5
+)
+
+// loadSampleInterval interval between the two INFO of each master measuring its current CPU usage
+var loadSampleInterval = time.Second
+
+// loadSample two INFO of a master taken elapsed apart
+type loadSample struct {
+	before, after map[string]string
+	elapsed       time.Duration
+}
+
+// cpuUsage returns the CPU seconds (used_cpu_sys+used_cpu_user) used per second between the two INFO
+func (s loadSample) cpuUsage() (float64, bool) {
+	cpu := func(info map[string]string) (float64, bool) {
+		sys, errSys := infoFloat(info, "used_cpu_sys")
+		user, errUser := infoFloat(info, "used_cpu_user")
+		return sys + user, errSys == nil && errUser == nil
+	}
+	before, okBefore := cpu(s.before)
+	after, okAfter := cpu(s.after)
+	if !okBefore || !okAfter || s.elapsed <= 0 {
+		return 0, false
+	}
+	return (after - before) / s.elapsed.Seconds(), true
+}
+
+// recommendMigrationConcurrency returns the number of slot migrations that can run in parallel given
+// two INFO samples of each master. The heuristic is:
+//   - one migration involves two masters, so start with half the number of masters (at least 1)
+//   - halve it if the busiest master has more than busyClientsThreshold connected clients
+//   - halve it again if the busiest master CPU usage between the two samples is over busyCPUThreshold
+//
+// The result is never lower than 1.
+func recommendMigrationConcurrency(samples []loadSample) int {
+	concurrency := len(samples) / 2
+	var maxClients int64
+	var maxCPU float64
+	for _, sample := range samples {
+		if clients, err := infoInt(sample.after, "connected_clients"); err == nil && clients > maxClients {
+			maxClients = clients
+		}
+		if cpu, ok := sample.cpuUsage(); ok && cpu > maxCPU {
+			maxCPU = cpu
+		}
+	}
+	if maxClients > busyClientsThreshold {
+		concurrency /= 2
+	}
+	if maxCPU > busyCPUThreshold {
+		concurrency /= 2
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return concurrency
+}
+
+// RecommendMigrationConcurrency returns a conservative number of slot migrations that can run in
+// parallel without causing latency spikes, computed from two INFO of every master taken
+// loadSampleInterval apart: see recommendMigrationConcurrency for the heuristic.
+func (a *Admin) RecommendMigrationConcurrency(ctx context.Context) (int, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return 0, err
+	}
+	masters := nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole })
+	samples := make([]loadSample, len(masters))
+	starts := make([]time.Time, len(masters))
+	for i, master := range masters {
+		info, err := a.nodeInfo(ctx, master.IPPort())
+		if err != nil {
+			return 0, err
+		}
+		samples[i].before, starts[i] = info, time.Now()
+	}
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-time.After(loadSampleInterval):
+	}
+	for i, master := range masters {
+		info, err := a.nodeInfo(ctx, master.IPPort())
+		if err != nil {
+			return 0, err
+		}
+		samples[i].after, samples[i].elapsed = info, time.Since(starts[i])
+	}
+	return recommendMigrationConcurrency(samples), nil
+}
+
+// BalanceReport represents the distribution of the slots and of the keys across the masters
+type BalanceReport struct {
+	// Slots number of slots of each master ID
+	Slots map[string]int `json:"slots"`
+	// Keys number of keys (DBSIZE) of each master ID
+	Keys map[string]int64 `json:"keys"`
+	// SlotsCV coefficient of variation (standard deviation / mean) of the slots counts
+	SlotsCV float64 `json:"slotsCV"`
+	// KeysCV coefficient of variation of the keys counts
+	KeysCV float64 `json:"keysCV"`
+}
+
+// meanStdDev returns the mean and population standard deviation of the values
+func meanStdDev(values []float64) (mean, stdDev float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean = sum / float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// coefficientOfVariation returns the population standard deviation of the values divided by their mean, 0 when
+// the mean is 0
+func coefficientOfVariation(values []float64) float64 {
+	mean, stdDev := meanStdDev(values)
+	if mean == 0 {
+		return 0
+	}
+	return stdDev / mean
+}
+
+// Outliers returns the masters, sorted by ID, whose slot count is more than stdDevs standard deviations away
+// from the masters mean. They are the rebalance candidates, typically left after manual fixes of a failed master.
+func (n Nodes) Outliers(stdDevs float64) Nodes {
+	masters := n.FilterByFunc(func(node *Node) bool { return node.GetRole() == RedisMasterRole })
+	counts := make([]float64, 0, len(masters))
+	for _, master := range masters {
+		counts = append(counts, float64(len(master.Slots)))
+	}
+	mean, stdDev := meanStdDev(counts)
+
+	outliers := Nodes{}
+	for i, master := range masters {
+		if math.Abs(counts[i]-mean) > stdDevs*stdDev {
+			outliers = append(outliers, master)
+		}
+	}
+	sort.Sort(outliers)
+	return outliers
+}
+
+// BalanceReport returns the slots and keys counts of every master along with their coefficient of variation,
+// the lower the more even. Comparing the reports before and after a rebalance tells whether the keys
+// distribution improved along with the slots one.
+func (a *Admin) BalanceReport(ctx context.Context) (BalanceReport, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return BalanceReport{}, err
+	}
+	report := BalanceReport{Slots: map[string]int{}, Keys: map[string]int64{}}
+	slots, keys := []float64{}, []float64{}
+	for _, master := range nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole }) {
+		c := a.nodeClient(master.IPPort())
+		size, err := c.DBSize(ctx).Result()
+		c.Close()
+		if err != nil {
+			return BalanceReport{}, fmt.Errorf("unable to get the number of keys of master %s: %v", master.ID, err)
+		}
+		report.Slots[master.ID] = len(master.Slots)
+		report.Keys[master.ID] = size
+		slots = append(slots, float64(len(master.Slots)))
+		keys = append(keys, float64(size))
+	}
+	report.SlotsCV = coefficientOfVariation(slots)
+	report.KeysCV = coefficientOfVariation(keys)
+	return report, nil
+}
@@ -0,0 +1,505 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestComputeKeyCountRebalancePlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		masters   Nodes
+		histogram map[Slot]int64
+		want      []SlotMigration
+	}{
+		{
+			name: "equal slot counts with skewed key counts",
+			masters: Nodes{
+				{ID: "M1", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 3)},
+				{ID: "M2", Role: RedisMasterRole, Slots: BuildSlotSlice(4, 7)},
+			},
+			histogram: map[Slot]int64{0: 50, 1: 40, 2: 5, 3: 5, 4: 5, 5: 5, 6: 5, 7: 5},
+			want:      []SlotMigration{{Slot: 1, From: "M1", To: "M2"}},
+		},
+		{
+			name: "high count slots spread over several masters",
+			masters: Nodes{
+				{ID: "M1", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 3)},
+				{ID: "M2", Role: RedisMasterRole, Slots: BuildSlotSlice(4, 7)},
+				{ID: "M3", Role: RedisMasterRole, Slots: BuildSlotSlice(8, 11)},
+			},
+			histogram: map[Slot]int64{0: 30, 1: 30, 2: 30, 3: 0, 4: 0, 5: 0, 6: 0, 7: 0, 8: 0, 9: 0, 10: 0, 11: 0},
+			want:      []SlotMigration{{Slot: 0, From: "M1", To: "M3"}, {Slot: 1, From: "M1", To: "M2"}},
+		},
+		{
+			name: "already balanced",
+			masters: Nodes{
+				{ID: "M1", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 1)},
+				{ID: "M2", Role: RedisMasterRole, Slots: BuildSlotSlice(2, 3)},
+			},
+			histogram: map[Slot]int64{0: 10, 1: 10, 2: 15, 3: 5},
+			want:      []SlotMigration{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComputeKeyCountRebalancePlan(tt.masters, tt.histogram); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ComputeKeyCountRebalancePlan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeRebalancePlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		masters Nodes
+		want    []SlotMigration
+	}{
+		{
+			name: "one master in excess and an intentionally empty master",
+			masters: Nodes{
+				{ID: "M3", Role: RedisMasterRole, Slots: BuildSlotSlice(8, 9)},
+				{ID: "M1", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 5)},
+				{ID: "M2", Role: RedisMasterRole, Slots: BuildSlotSlice(6, 7)},
+				{ID: "M4", Role: RedisMasterRole},
+				{ID: "S1", Role: RedisSlaveRole, MasterReferent: "M1", Slots: BuildSlotSlice(0, 5)},
+			},
+			want: []SlotMigration{{Slot: 4, From: "M1", To: "M2"}, {Slot: 5, From: "M1", To: "M3"}},
+		},
+		{
+			name: "balanced with a remainder",
+			masters: Nodes{
+				{ID: "M1", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 1)},
+				{ID: "M2", Role: RedisMasterRole, Slots: BuildSlotSlice(2, 4)},
+			},
+			want: []SlotMigration{},
+		},
+		{
+			name: "single master owning slots",
+			masters: Nodes{
+				{ID: "M1", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 16383)},
+				{ID: "M2", Role: RedisMasterRole},
+			},
+			want: []SlotMigration{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComputeRebalancePlan(tt.masters); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ComputeRebalancePlan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminRebalanceSlots(t *testing.T) {
+	var view string
+	handler := func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return view
+		case "CLUSTER GETKEYSINSLOT":
+			return []string{}
+		}
+		return fakeOK
+	}
+	m1 := newFakeRedis(t, handler)
+	m2 := newFakeRedis(t, handler)
+	view = fakeClusterNodes(
+		fakeNodeLine("M1", m1.Addr(), "myself,master", "-", "0-8291"),
+		fakeNodeLine("M2", m2.Addr(), "master", "-", "8292-16383"),
+	)
+	a := newTestAdmin(m1.Addr())
+
+	plan, err := a.ComputeRebalancePlan(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan) != 100 || plan[0].Slot != 8192 || plan[len(plan)-1].Slot != 8291 {
+		t.Fatalf("expected slots 8192-8291 to move, got %d migrations", len(plan))
+	}
+	if len(m1.Calls("CLUSTER SETSLOT"))+len(m2.Calls("CLUSTER SETSLOT")) != 0 {
+		t.Fatalf("expected ComputeRebalancePlan not to migrate anything")
+	}
+
+	if err := a.RebalanceSlots(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := m2.Calls("CLUSTER SETSLOT"); len(calls) != 2*100 || calls[0][2] != "8192" || calls[0][3] != "IMPORTING" {
+		t.Errorf("expected every slot to be imported then assigned on M2, got %d SETSLOT", len(calls))
+	}
+	if err := a.RebalanceSlotsWithMode(context.Background(), "bogus"); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}
+
+func TestAdminRebalanceSlotsByKeyCount(t *testing.T) {
+	var view string
+	handler := func(counts map[Slot]int64) fakeHandler {
+		keys := keysHandler(&view, counts)
+		return func(args []string) interface{} {
+			if fakeCmdName(args) == "CLUSTER GETKEYSINSLOT" {
+				return []string{}
+			}
+			return keys(args)
+		}
+	}
+	// the slots are balanced, but slot 0 holds most of the keys
+	m1 := newFakeRedis(t, handler(map[Slot]int64{0: 100, 1: 10, 2: 10}))
+	m2 := newFakeRedis(t, handler(map[Slot]int64{3: 10, 4: 10, 5: 10}))
+	view = fakeClusterNodes(
+		fakeNodeLine("M1", m1.Addr(), "myself,master", "-", "0-2"),
+		fakeNodeLine("M2", m2.Addr(), "master", "-", "3-5"),
+	)
+	a := newTestAdmin(m1.Addr())
+
+	if err := a.RebalanceSlotsWithMode(context.Background(), RebalanceByKeyCount); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calls := m2.Calls("CLUSTER SETSLOT")
+	if len(calls) != 4 || calls[0][2] != "1" || calls[2][2] != "2" {
+		t.Errorf("expected slots 1 and 2 to move to M2, got %v", calls)
+	}
+}
+
+func TestAdminRebalanceSlotsByKeyCountOrdered(t *testing.T) {
+	var view string
+	var mu sync.Mutex
+	imported := []string{}
+	handler := func(id string, counts map[Slot]int64) fakeHandler {
+		keys := keysHandler(&view, counts)
+		return func(args []string) interface{} {
+			switch fakeCmdName(args) {
+			case "CLUSTER GETKEYSINSLOT":
+				return []string{}
+			case "CLUSTER SETSLOT":
+				if args[3] == "IMPORTING" {
+					mu.Lock()
+					imported = append(imported, args[2]+"->"+id)
+					mu.Unlock()
+				}
+			}
+			return keys(args)
+		}
+	}
+	m1 := newFakeRedis(t, handler("M1", map[Slot]int64{0: 30, 1: 30, 2: 20, 3: 20, 4: 10, 5: 10}))
+	m2 := newFakeRedis(t, handler("M2", map[Slot]int64{6: 1}))
+	m3 := newFakeRedis(t, handler("M3", map[Slot]int64{7: 1}))
+	view = fakeClusterNodes(
+		fakeNodeLine("M1", m1.Addr(), "myself,master", "-", "0-5"),
+		fakeNodeLine("M2", m2.Addr(), "master", "-", "6"),
+		fakeNodeLine("M3", m3.Addr(), "master", "-", "7"),
+	)
+	a := newTestAdmin(m1.Addr())
+
+	if err := a.RebalanceSlotsWithMode(context.Background(), RebalanceByKeyCount); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the plan alternates the destinations, the migrations are grouped by destination
+	if want := []string{"1->M2", "5->M2", "0->M3", "4->M3"}; !reflect.DeepEqual(imported, want) {
+		t.Errorf("expected the migrations %v, got %v", want, imported)
+	}
+}
+
+func TestOrderMigrations(t *testing.T) {
+	migs := []SlotMigration{
+		{Slot: 7, From: "M1", To: "M3"},
+		{Slot: 2, From: "M1", To: "M2"},
+		{Slot: 9, From: "M4", To: "M3"},
+		{Slot: 1, From: "M1", To: "M2"},
+		{Slot: 5, From: "M1", To: "M3"},
+		{Slot: 3, From: "M1", To: "M2"},
+		{Slot: 6, From: "M1", To: "M3"},
+	}
+	want := []SlotMigration{
+		{Slot: 1, From: "M1", To: "M2"},
+		{Slot: 2, From: "M1", To: "M2"},
+		{Slot: 3, From: "M1", To: "M2"},
+		{Slot: 5, From: "M1", To: "M3"},
+		{Slot: 6, From: "M1", To: "M3"},
+		{Slot: 7, From: "M1", To: "M3"},
+		{Slot: 9, From: "M4", To: "M3"},
+	}
+	if got := OrderMigrations(migs); !reflect.DeepEqual(got, want) {
+		t.Errorf("OrderMigrations() = %v, want %v", got, want)
+	}
+	if migs[0].Slot != 7 {
+		t.Errorf("expected the input migrations not to be reordered")
+	}
+}
+
+func TestSlotsToMove(t *testing.T) {
+	tests := []struct {
+		name            string
+		current, target int
+		want            int
+	}{
+		{name: "scale up 3 to 4", current: 3, target: 4, want: 4096},
+		{name: "scale down 4 to 3", current: 4, target: 3, want: 4096},
+		{name: "scale up 3 to 6", current: 3, target: 6, want: 8192},
+		{name: "scale down 6 to 1", current: 6, target: 1, want: 13653},
+		{name: "no change", current: 3, target: 3, want: 0},
+		{name: "no master", current: 0, target: 3, want: 0},
+	}
+	for _, tt := range tests {
+		if got := SlotsToMove(tt.current, tt.target, defaultHashMaxSlots); got != tt.want {
+			t.Errorf("%s: SlotsToMove() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// sampleLoadInfo returns an INFO output with the provided load related fields
+func sampleLoadInfo(clients int, cpuSys, cpuUser float64, uptime int) map[string]string {
+	return parseInfo(fmt.Sprintf("# Server\r\nuptime_in_seconds:%d\r\n# Clients\r\nconnected_clients:%d\r\n"+
+		"# CPU\r\nused_cpu_sys:%.6f\r\nused_cpu_user:%.6f\r\n", uptime, clients, cpuSys, cpuUser))
+}
+
+// sampleLoad returns two INFO samples a second apart of a master using cpu CPU seconds per second, after
+// having used lifetimeCPU CPU seconds since its start
+func sampleLoad(clients int, lifetimeCPU, cpu float64) loadSample {
+	return loadSample{
+		before:  sampleLoadInfo(clients, lifetimeCPU/2, lifetimeCPU/2, 1000),
+		after:   sampleLoadInfo(clients, (lifetimeCPU+cpu)/2, (lifetimeCPU+cpu)/2, 1001),
+		elapsed: time.Second,
+	}
+}
+
+func TestRecommendMigrationConcurrency(t *testing.T) {
+	idle := sampleLoad(10, 20, 0.1)
+	busyClients := sampleLoad(5000, 20, 0.1)
+	busyCPU := sampleLoad(10, 20, 0.8)
+	// busy for most of its lifetime, idle now
+	formerlyBusy := sampleLoad(10, 800, 0.1)
+	tests := []struct {
+		name    string
+		samples []loadSample
+		want    int
+	}{
+		{name: "single master", samples: []loadSample{idle}, want: 1},
+		{name: "idle masters", samples: []loadSample{idle, idle, idle, idle, idle, idle, idle, idle}, want: 4},
+		{name: "one master with many clients", samples: []loadSample{idle, idle, idle, idle, idle, idle, idle, busyClients}, want: 2},
+		{name: "one master with high cpu", samples: []loadSample{idle, busyCPU, idle, idle, idle, idle, idle, idle}, want: 2},
+		{name: "one master formerly busy", samples: []loadSample{idle, formerlyBusy, idle, idle, idle, idle, idle, idle}, want: 4},
+		{name: "busy master", samples: []loadSample{idle, idle, idle, idle, idle, idle, idle, sampleLoad(5000, 20, 0.8)}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recommendMigrationConcurrency(tt.samples); got != tt.want {
+				t.Errorf("recommendMigrationConcurrency() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminRecommendMigrationConcurrency(t *testing.T) {
+	defer func(interval time.Duration) { loadSampleInterval = interval }(loadSampleInterval)
+	loadSampleInterval = 100 * time.Millisecond
+
+	var view string
+	fakes := []*fakeRedis{}
+	lines := []string{}
+	for i := 0; i < 4; i++ {
+		// the first master uses a CPU second between its two samples, the others were busy since their start
+		busy := i == 0
+		cpu := 1000.0
+		fake := newFakeRedis(t, func(args []string) interface{} {
+			switch fakeCmdName(args) {
+			case "CLUSTER NODES":
+				return view
+			case "INFO":
+				if busy {
+					cpu++
+				}
+				return fmt.Sprintf("# Clients\r\nconnected_clients:10\r\n# CPU\r\nused_cpu_sys:%.6f\r\nused_cpu_user:%.6f\r\n", cpu/2, cpu/2)
+			}
+			return fakeOK
+		})
+		fakes = append(fakes, fake)
+		lines = append(lines, fakeNodeLine(fmt.Sprintf("M%d", i), fake.Addr(), "master", "-", fmt.Sprint(i)))
+	}
+	view = fakeClusterNodes(lines...)
+	a := newTestAdmin(fakes[0].Addr())
+
+	concurrency, err := a.RecommendMigrationConcurrency(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if concurrency != 1 {
+		t.Errorf("expected the busy master to halve the concurrency, got %d", concurrency)
+	}
+	if calls := fakes[1].Calls("INFO"); len(calls) != 2 {
+		t.Errorf("expected two INFO samples of each master, got %d", len(calls))
+	}
+}
+
+func TestAdminEstimateReshardDuration(t *testing.T) {
+	var view string
+	m1 := newFakeRedis(t, keysHandler(&view, map[Slot]int64{0: 1000, 1: 3000, 2: 500}))
+	m2 := newFakeRedis(t, keysHandler(&view, map[Slot]int64{8192: 6000}))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", m1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", m2.Addr(), "master", "-", "8192-16383"),
+	)
+	a := newTestAdmin(m1.Addr())
+	migs := []SlotMigration{
+		{Slot: 0, From: "A", To: "B"},
+		{Slot: 1, From: "A", To: "B"},
+		{Slot: 8192, From: "B", To: "A"},
+	}
+
+	eta, keys, err := a.EstimateReshardDuration(context.Background(), migs, 500)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keys != 10000 || eta != 20*time.Second {
+		t.Errorf("EstimateReshardDuration() = %s, %d, want 20s, 10000", eta, keys)
+	}
+
+	if _, _, err := a.EstimateReshardDuration(context.Background(), migs, 0); err == nil {
+		t.Errorf("expected an error for a null rate")
+	}
+}
+
+// dbSizeHandler returns a handler replying CLUSTER NODES and the DBSIZE
+func dbSizeHandler(view *string, size int64) fakeHandler {
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return *view
+		case "DBSIZE":
+			return size
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminBalanceReport(t *testing.T) {
+	tests := []struct {
+		name        string
+		sizes       [2]int64
+		slots       [2]string
+		wantSlotsCV float64
+		wantKeysCV  float64
+	}{
+		{name: "even", sizes: [2]int64{1000, 1000}, slots: [2]string{"0-8191", "8192-16383"}, wantSlotsCV: 0, wantKeysCV: 0},
+		// 4096 and 12288 slots, mean 8192 and standard deviation 4096
+		{name: "skewed", sizes: [2]int64{1000, 3000}, slots: [2]string{"0-4095", "4096-16383"}, wantSlotsCV: 0.5, wantKeysCV: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var view string
+			m1 := newFakeRedis(t, dbSizeHandler(&view, tt.sizes[0]))
+			m2 := newFakeRedis(t, dbSizeHandler(&view, tt.sizes[1]))
+			view = fakeClusterNodes(
+				fakeNodeLine("A", m1.Addr(), "myself,master", "-", tt.slots[0]),
+				fakeNodeLine("B", m2.Addr(), "master", "-", tt.slots[1]),
+				fakeNodeLine("C", "10.0.0.3:6379", "slave", "A"),
+			)
+			a := newTestAdmin(m1.Addr())
+
+			report, err := a.BalanceReport(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if report.Keys["A"] != tt.sizes[0] || report.Keys["B"] != tt.sizes[1] || len(report.Slots) != 2 {
+				t.Errorf("unexpected counts: %+v", report)
+			}
+			if math.Abs(report.SlotsCV-tt.wantSlotsCV) > 1e-9 || math.Abs(report.KeysCV-tt.wantKeysCV) > 1e-9 {
+				t.Errorf("BalanceReport() CVs = %v, %v, want %v, %v", report.SlotsCV, report.KeysCV, tt.wantSlotsCV, tt.wantKeysCV)
+			}
+		})
+	}
+}
+
+func TestNodesOutliers(t *testing.T) {
+	// D absorbed the slots of a failed master
+	nodes := Nodes{
+		{ID: "A", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 2999)},
+		{ID: "B", Role: RedisMasterRole, Slots: BuildSlotSlice(3000, 5999)},
+		{ID: "C", Role: RedisMasterRole, Slots: BuildSlotSlice(6000, 8999)},
+		{ID: "D", Role: RedisMasterRole, Slots: BuildSlotSlice(9000, 16383)},
+		{ID: "F", Role: RedisSlaveRole, MasterReferent: "D"},
+	}
+	if got := nodes.Outliers(1.5); len(got) != 1 || got[0].ID != "D" {
+		t.Errorf("expected D to be the only outlier, got %v", got)
+	}
+
+	balanced := Nodes{
+		{ID: "A", Role: RedisMasterRole, Slots: BuildSlotSlice(0, 8191)},
+		{ID: "B", Role: RedisMasterRole, Slots: BuildSlotSlice(8192, 16383)},
+	}
+	if got := balanced.Outliers(0); len(got) != 0 {
+		t.Errorf("expected no outlier on a balanced cluster, got %v", got)
+	}
+}
+
+func TestAdminPlanReshard(t *testing.T) {
+	var view string
+	handler := func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return view
+		case "CLUSTER GETKEYSINSLOT":
+			return []string{}
+		}
+		return fakeOK
+	}
+	m1 := newFakeRedis(t, handler)
+	m2 := newFakeRedis(t, handler)
+	view = fakeClusterNodes(
+		fakeNodeLine("M1", m1.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("M2", m2.Addr(), "master", "-", "8192-16383"),
+		fakeNodeLine("S1", "10.0.0.3:6379", "slave", "M1"),
+	)
+	a := newTestAdmin(m1.Addr())
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		src, dst string
+		num      int
+	}{{"M1", "M1", 1}, {"M1", "X", 1}, {"S1", "M2", 1}, {"M1", "M2", 0}, {"M1", "M2", 8193}} {
+		if _, err := a.PlanReshard(ctx, tt.src, tt.dst, tt.num); err == nil {
+			t.Errorf("expected an error moving %d slots from %s to %s", tt.num, tt.src, tt.dst)
+		}
+	}
+
+	plan, err := a.PlanReshard(ctx, "M1", "M2", 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []SlotMigration{{Slot: 8189, From: "M1", To: "M2"}, {Slot: 8190, From: "M1", To: "M2"}, {Slot: 8191, From: "M1", To: "M2"}}
+	if !reflect.DeepEqual(plan.Migrations, want) || plan.SlotsPerTarget["M2"] != 3 {
+		t.Errorf("PlanReshard() = %+v, want migrations %v", plan, want)
+	}
+	if len(m2.Calls("CLUSTER SETSLOT")) != 0 {
+		t.Fatalf("expected PlanReshard not to migrate anything")
+	}
+
+	if err := a.ExecutePlan(ctx, plan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := m2.Calls("CLUSTER SETSLOT"); len(calls) != 6 || calls[4][2] != "8191" || calls[5][3] != "NODE" {
+		t.Errorf("expected the 3 slots to be imported then assigned on M2, got %v", calls)
+	}
+}
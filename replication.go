@@ -0,0 +1,717 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// pollInterval interval between two checks when waiting for a cluster state
+var pollInterval = 100 * time.Millisecond
+
+const (
+	// defaultPromoteTimeout max time to wait for a replica to catch up, then to become master, during a
+	// promotion, when AdminOptions.PromoteTimeout isn't set
+	defaultPromoteTimeout = 10 * time.Second
+	// defaultPromoteMaxLag replication lag in bytes of a replica caught up enough to be promoted, when
+	// AdminOptions.PromoteMaxLag isn't set
+	defaultPromoteMaxLag = 1 << 20
+)
+
+// replicateTimeout max time to wait for a node to report its new master after CLUSTER REPLICATE
+var replicateTimeout = 10 * time.Second
+
+// ReplicaInfo represents a replica as reported by the INFO replication section of its master
+type ReplicaInfo struct {
+	Addr   string
+	State  string
+	Offset int64
+	// Lag number of bytes the replica is behind its master
+	Lag int64
+}
+
+// DecodeReplicas decodes the replicas from the INFO replication section of a master,
+// ex: slave0:ip=10.0.0.2,port=6379,state=online,offset=1234,lag=0
+func DecodeReplicas(info map[string]string) ([]ReplicaInfo, error) {
+	masterOffset, err := infoInt(info, "master_repl_offset")
+	if err != nil {
+		return nil, err
+	}
+	replicas := []ReplicaInfo{}
+	for i := 0; ; i++ {
+		value, ok := info["slave"+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		fields := map[string]string{}
+		for _, kv := range strings.Split(value, ",") {
+			if values := strings.SplitN(kv, "=", 2); len(values) == 2 {
+				fields[values[0]] = values[1]
+			}
+		}
+		offset, err := strconv.ParseInt(fields["offset"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("wrong format for INFO replication slave%d: %v", i, err)
+		}
+		replicas = append(replicas, ReplicaInfo{
+			Addr:   net.JoinHostPort(fields["ip"], fields["port"]),
+			State:  fields["state"],
+			Offset: offset,
+			Lag:    masterOffset - offset,
+		})
+	}
+	return replicas, nil
+}
+
+// ReplicationLag returns the number of bytes each replica of the master at addr is behind, keyed by replica address
+func (a *Admin) ReplicationLag(ctx context.Context, masterAddr string) (map[string]int64, error) {
+	info, err := a.nodeInfo(ctx, masterAddr, "replication")
+	if err != nil {
+		return nil, err
+	}
+	replicas, err := DecodeReplicas(info)
+	if err != nil {
+		return nil, err
+	}
+	lags := make(map[string]int64, len(replicas))
+	for _, replica := range replicas {
+		lags[replica.Addr] = replica.Lag
+	}
+	return lags, nil
+}
+
+// WaitReplicas waits until the replicas of the master at addr caught up with its replication offset.
+// If no replica address is provided, all the replicas of the master are waited for.
+// It returns a ReplicaLaggingError if they are still lagging after the timeout.
+func (a *Admin) WaitReplicas(ctx context.Context, masterAddr string, timeout time.Duration, replicaAddrs ...string) error {
+	return a.waitReplicas(ctx, masterAddr, timeout, 0, replicaAddrs...)
+}
+
+// waitReplicas waits until the replicas of the master at addr lag by maxLag bytes at most, like WaitReplicas
+func (a *Admin) waitReplicas(ctx context.Context, masterAddr string, timeout time.Duration, maxLag int64, replicaAddrs ...string) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		lags, err := a.ReplicationLag(ctx, masterAddr)
+		if err != nil {
+			return err
+		}
+		addrs := replicaAddrs
+		if len(addrs) == 0 {
+			for addr := range lags {
+				addrs = append(addrs, addr)
+			}
+		}
+		synced := true
+		for _, addr := range addrs {
+			if lag, ok := lags[addr]; !ok || lag > maxLag {
+				logger.V(4).Infof("replica %s of master %s is lagging: %d bytes (attached: %v)", addr, masterAddr, lag, ok)
+				synced = false
+			}
+		}
+		if synced {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return replicaLaggingError
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// WaitAOF blocks until the writes of the node at addr are fsynced to the AOF of numLocal (0 or 1) local
+// and numReplicas replicas, or until the timeout. It returns the number of local and replica AOF fsynced,
+// as replied by WAITAOF (Redis 7.2+). A timeout of 0 blocks until the fsyncs, or until ctx is done.
+func (a *Admin) WaitAOF(ctx context.Context, addr string, numLocal, numReplicas int, timeout time.Duration) (local, replicas int64, err error) {
+	c := a.nodeClient(addr)
+	defer c.Close()
+	result, err := c.WithTimeout(blockingTimeout(timeout)).Do(ctx, "WAITAOF", numLocal, numReplicas, timeout.Milliseconds()).Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to WAITAOF on node %s: %v", addr, err)
+	}
+	reply, ok := result.([]interface{})
+	if !ok || len(reply) != 2 {
+		return 0, 0, fmt.Errorf("wrong format from WAITAOF: %v", reply)
+	}
+	if local, ok = reply[0].(int64); !ok {
+		return 0, 0, fmt.Errorf("wrong format from WAITAOF: %v", reply)
+	}
+	if replicas, ok = reply[1].(int64); !ok {
+		return 0, 0, fmt.Errorf("wrong format from WAITAOF: %v", reply)
+	}
+	return local, replicas, nil
+}
+
+// ReplBacklog returns the replication backlog size (repl_backlog_size) of the node at addr and the number of
+// bytes it holds (repl_backlog_histlen), 0 when no backlog is allocated. A backlog full of data means a
+// replica disconnected for a while will need a full resync.
+func (a *Admin) ReplBacklog(ctx context.Context, addr string) (size, active int64, err error) {
+	info, err := a.nodeInfo(ctx, addr, "replication")
+	if err != nil {
+		return 0, 0, err
+	}
+	if size, err = infoInt(info, "repl_backlog_size"); err != nil {
+		return 0, 0, err
+	}
+	allocated, err := infoInt(info, "repl_backlog_active")
+	if err != nil {
+		return 0, 0, err
+	}
+	if allocated == 0 {
+		return size, 0, nil
+	}
+	if active, err = infoInt(info, "repl_backlog_histlen"); err != nil {
+		return 0, 0, err
+	}
+	return size, active, nil
+}
+
+// LaggingReplicas returns the replicas more than maxLagBytes behind their master. Replicas whose link is
+// down, either disconnected in the cluster view or not attached to their master, are always returned.
+func (a *Admin) LaggingReplicas(ctx context.Context, maxLagBytes int64) (Nodes, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	lagging := Nodes{}
+	masterLags := make(map[string]map[string]int64)
+	for _, replica := range nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisSlaveRole }) {
+		if replica.LinkState == RedisLinkStateDisconnected || replica.HasStatus(NodeStatusFail) {
+			lagging = append(lagging, replica)
+			continue
+		}
+		master, err := nodes.GetNodeByID(replica.MasterReferent)
+		if err != nil {
+			lagging = append(lagging, replica)
+			continue
+		}
+		lags, ok := masterLags[master.ID]
+		if !ok {
+			if lags, err = a.ReplicationLag(ctx, master.IPPort()); err != nil {
+				return nil, err
+			}
+			masterLags[master.ID] = lags
+		}
+		if lag, ok := lags[replica.IPPort()]; !ok || lag > maxLagBytes {
+			lagging = append(lagging, replica)
+		}
+	}
+	return lagging, nil
+}
+
+// OnReplica runs fn against a healthy replica of the master ID, with a client issuing READONLY on each of
+// its connections so that fn can read the keys of the master slots. The replica with the lowest replication
+// lag is chosen; replicas failing or not attached to the master aren't eligible.
+func (a *Admin) OnReplica(ctx context.Context, masterID string, fn func(ctx context.Context, client *redis.Client) error) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	master, err := nodes.GetNodeByID(masterID)
+	if err != nil {
+		return err
+	}
+	lags, err := a.ReplicationLag(ctx, master.IPPort())
+	if err != nil {
+		return err
+	}
+
+	var chosen *Node
+	var chosenLag int64
+	for _, replica := range nodes.FilterByFunc(func(n *Node) bool { return n.MasterReferent == masterID }) {
+		if replica.LinkState == RedisLinkStateDisconnected || replica.HasStatus(NodeStatusFail) || replica.HasStatus(NodeStatusPFail) {
+			continue
+		}
+		lag, ok := lags[replica.IPPort()]
+		if !ok {
+			continue
+		}
+		if chosen == nil || lag < chosenLag || (lag == chosenLag && replica.ID < chosen.ID) {
+			chosen, chosenLag = replica, lag
+		}
+	}
+	if chosen == nil {
+		return fmt.Errorf("no healthy replica for master %s", masterID)
+	}
+
+	c := a.replicaClient(chosen.IPPort())
+	defer c.Close()
+	return fn(ctx, c)
+}
+
+// maxConsistencySamples max number of keys compared by VerifyReplicaConsistency
+const maxConsistencySamples = 1000
+
+// VerifyReplicaConsistency compares up to sampleKeys random keys of the master of the replica ID with the
+// replica, using DEBUG DIGEST-VALUE, or their type and content (see typedValues) when DEBUG is refused, as
+// enable-debug-command is no by default since Redis 7. The keys missing or with a different value on the
+// replica are returned in a ReplicaInconsistencyError. The replica must have caught up with its master (see
+// WaitReplicas) for the comparison to be meaningful. The sampling is bounded by maxConsistencySamples.
+func (a *Admin) VerifyReplicaConsistency(ctx context.Context, replicaID string, sampleKeys int) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	replica, err := nodes.GetNodeByID(replicaID)
+	if err != nil {
+		return err
+	}
+	master, err := nodes.GetNodeByID(replica.MasterReferent)
+	if err != nil {
+		return fmt.Errorf("unable to find the master of replica %s: %v", replicaID, err)
+	}
+	if sampleKeys > maxConsistencySamples {
+		sampleKeys = maxConsistencySamples
+	}
+
+	mc := a.nodeClient(master.IPPort())
+	defer mc.Close()
+	sampled := map[string]bool{}
+	keys := []string{}
+	// random keys repeat, the attempts are bounded so that small datasets don't loop forever
+	for i := 0; i < 2*sampleKeys && len(keys) < sampleKeys; i++ {
+		key, err := mc.RandomKey(ctx).Result()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to sample keys of master %s: %v", master.ID, err)
+		}
+		if !sampled[key] {
+			sampled[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	rc := a.replicaClient(replica.IPPort())
+	defer rc.Close()
+	digest := digestValues
+	masterDigests, err := digest(ctx, mc, keys)
+	if isDebugRefused(err) {
+		logger.V(4).Infof("DEBUG refused by master %s, comparing the typed values: %v", master.ID, err)
+		digest = typedValues
+		masterDigests, err = digest(ctx, mc, keys)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to digest values of master %s: %v", master.ID, err)
+	}
+	replicaDigests, err := digest(ctx, rc, keys)
+	if err != nil {
+		return fmt.Errorf("unable to digest values of replica %s: %v", replicaID, err)
+	}
+	mismatches := []string{}
+	for i, key := range keys {
+		if masterDigests[i] != replicaDigests[i] {
+			mismatches = append(mismatches, key)
+		}
+	}
+	if len(mismatches) > 0 {
+		return ReplicaInconsistencyError{ReplicaID: replicaID, Keys: mismatches}
+	}
+	return nil
+}
+
+// digestValues returns the DEBUG DIGEST-VALUE of each key, a zero digest for a missing key
+func digestValues(ctx context.Context, c *redis.Client, keys []string) ([]string, error) {
+	args := []interface{}{"DEBUG", "DIGEST-VALUE"}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	cmd := redis.NewStringSliceCmd(ctx, args...)
+	_ = c.Process(ctx, cmd)
+	digests, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(digests) != len(keys) {
+		return nil, fmt.Errorf("wrong format from DEBUG DIGEST-VALUE: %d digests for %d keys", len(digests), len(keys))
+	}
+	return digests, nil
+}
+
+// isDebugRefused returns true if the DEBUG command failed because it is disabled or renamed on the node
+func isDebugRefused(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "DEBUG command not allowed") || strings.HasPrefix(err.Error(), "ERR unknown command"))
+}
+
+// typedValues returns the value of each key in a canonical form made of its TYPE and its content, read
+// with GET, HGETALL, LRANGE, SMEMBERS, ZRANGE WITHSCORES or XRANGE according to the type, an empty value
+// for a missing key. Unlike a DUMP payload, it depends neither on the encoding of the value nor on the
+// RDB version. The values of the other types, ex: the modules ones, are only compared by type.
+func typedValues(ctx context.Context, c *redis.Client, keys []string) ([]string, error) {
+	types := make([]*redis.StatusCmd, len(keys))
+	if _, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			types[i] = pipe.Type(ctx, key)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	contents := make([]redis.Cmder, len(keys))
+	if _, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			switch types[i].Val() {
+			case "string":
+				contents[i] = pipe.Get(ctx, key)
+			case "hash":
+				contents[i] = pipe.HGetAll(ctx, key)
+			case "list":
+				contents[i] = pipe.LRange(ctx, key, 0, -1)
+			case "set":
+				contents[i] = pipe.SMembers(ctx, key)
+			case "zset":
+				contents[i] = pipe.ZRangeWithScores(ctx, key, 0, -1)
+			case "stream":
+				contents[i] = pipe.XRange(ctx, key, "-", "+")
+			}
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	values := make([]string, len(keys))
+	for i := range keys {
+		value, err := canonicalValue(types[i].Val(), contents[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// canonicalValue returns the value of type kind read by cmd in a form independent of the order of the
+// fields of a hash and of the members of a set
+func canonicalValue(kind string, cmd redis.Cmder) (string, error) {
+	if kind == "none" {
+		return "", nil
+	}
+	if cmd == nil {
+		return kind, nil
+	}
+	if err := cmd.Err(); err == redis.Nil {
+		// expired or deleted since its TYPE was read
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	parts := []string{}
+	switch cmd := cmd.(type) {
+	case *redis.StringCmd:
+		parts = append(parts, cmd.Val())
+	case *redis.StringStringMapCmd:
+		for field, value := range cmd.Val() {
+			parts = append(parts, field+"="+value)
+		}
+		sort.Strings(parts)
+	case *redis.StringSliceCmd:
+		parts = append(parts, cmd.Val()...)
+		if kind == "set" {
+			sort.Strings(parts)
+		}
+	case *redis.ZSliceCmd:
+		for _, z := range cmd.Val() {
+			parts = append(parts, fmt.Sprintf("%v=%v", z.Member, z.Score))
+		}
+	case *redis.XMessageSliceCmd:
+		for _, message := range cmd.Val() {
+			parts = append(parts, fmt.Sprintf("%s=%v", message.ID, message.Values))
+		}
+	}
+	return fmt.Sprintf("%s:%q", kind, parts), nil
+}
+
+// WaitForRole waits until the node at addr reports the role (RedisMasterRole or RedisSlaveRole) in INFO replication
+func (a *Admin) WaitForRole(ctx context.Context, addr, role string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err := a.nodeInfo(ctx, addr, "replication")
+		if err != nil {
+			return err
+		}
+		if info["role"] == role {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("node %s still has role %s after %s, expected %s", addr, info["role"], timeout, role)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// promoteTimeout returns AdminOptions.PromoteTimeout, defaultPromoteTimeout if not set
+func (a *Admin) promoteTimeout() time.Duration {
+	if a.opts.PromoteTimeout > 0 {
+		return a.opts.PromoteTimeout
+	}
+	return defaultPromoteTimeout
+}
+
+// promoteMaxLag returns AdminOptions.PromoteMaxLag, defaultPromoteMaxLag if not set
+func (a *Admin) promoteMaxLag() int64 {
+	if a.opts.PromoteMaxLag > 0 {
+		return a.opts.PromoteMaxLag
+	}
+	return defaultPromoteMaxLag
+}
+
+// waitPromotable waits for the replica at addr to lag behind its master by AdminOptions.PromoteMaxLag at most
+func (a *Admin) waitPromotable(ctx context.Context, masterAddr, replicaAddr string) error {
+	return a.waitReplicas(ctx, masterAddr, a.promoteTimeout(), a.promoteMaxLag(), replicaAddr)
+}
+
+// PromoteReplica promotes the replica ID in place of its master with a manual CLUSTER FAILOVER.
+// The master must be healthy and the replica caught up with it, within AdminOptions.PromoteMaxLag,
+// otherwise the promotion is aborted without any change (a ReplicaLaggingError is returned if the
+// replica lags). It returns once the replica reports the master role, waiting for each step up to
+// AdminOptions.PromoteTimeout.
+func (a *Admin) PromoteReplica(ctx context.Context, replicaID string) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	replica, err := nodes.GetNodeByID(replicaID)
+	if err != nil {
+		return err
+	}
+	if replica.GetRole() != RedisSlaveRole {
+		return fmt.Errorf("node %s is not a replica", replicaID)
+	}
+	master, err := nodes.GetNodeByID(replica.MasterReferent)
+	if err != nil {
+		return fmt.Errorf("master %s of replica %s not found: %v", replica.MasterReferent, replicaID, err)
+	}
+	if master.HasStatus(NodeStatusFail) || master.HasStatus(NodeStatusPFail) || master.LinkState != RedisLinkStateConnected {
+		return fmt.Errorf("master %s of replica %s is not healthy: status %v, link %s", master.ID, replicaID, master.FailStatus, master.LinkState)
+	}
+
+	if err := a.waitPromotable(ctx, master.IPPort(), replica.IPPort()); err != nil {
+		return err
+	}
+
+	c := a.nodeClient(replica.IPPort())
+	err = c.ClusterFailover(ctx).Err()
+	c.Close()
+	if err != nil {
+		return fmt.Errorf("unable to failover replica %s: %v", replicaID, err)
+	}
+
+	return a.WaitForRole(ctx, replica.IPPort(), RedisMasterRole, a.promoteTimeout())
+}
+
+// ReattachOrphans attaches every orphaned replica (see Nodes.OrphanedReplicas) to the healthy
+// master needing a replica the most. It returns the "replica->master" reattachments done, the ones done
+// before a failure included.
+func (a *Admin) ReattachOrphans(ctx context.Context) ([]string, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	pairs := []string{}
+	for _, orphan := range nodes.OrphanedReplicas().SortNodes() {
+		master, err := nodes.MasterNeedingReplica()
+		if err != nil {
+			return pairs, fmt.Errorf("no healthy master to attach replica %s to: %v", orphan.ID, err)
+		}
+		if _, err := a.AttachSlaveToMaster(ctx, orphan, master.ID); err != nil {
+			return pairs, err
+		}
+		logger.Infof("replica %s reattached to master %s", orphan.ID, master.ID)
+		// account for the new replica before picking the next master
+		orphan.MasterReferent = master.ID
+		pairs = append(pairs, orphan.ID+"->"+master.ID)
+	}
+	return pairs, nil
+}
+
+// SafeFailover promotes the best replica of the master ID with a manual CLUSTER FAILOVER and returns its ID.
+// Only healthy replicas attached to the master and without the nofailover flag are eligible; a replica in the
+// zone of the master is preferred (see SetPods), then the lowest replication lag, then the lowest ID. The
+// replica must catch up with the master first, as for PromoteReplica, and once it reports the master role
+// its own view must show it owning the slots of the former master with the same overall slots coverage.
+func (a *Admin) SafeFailover(ctx context.Context, masterID string) (string, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return "", err
+	}
+	a.attachPods(*nodes)
+	master, err := nodes.GetNodeByID(masterID)
+	if err != nil {
+		return "", err
+	}
+	if master.GetRole() != RedisMasterRole {
+		return "", fmt.Errorf("node %s is not a master", masterID)
+	}
+	lags, err := a.ReplicationLag(ctx, master.IPPort())
+	if err != nil {
+		return "", err
+	}
+
+	var chosen *Node
+	var chosenLag int64
+	better := func(replica *Node, lag int64) bool {
+		if chosen == nil {
+			return true
+		}
+		if sameZone(replica, master) != sameZone(chosen, master) {
+			return sameZone(replica, master)
+		}
+		if lag != chosenLag {
+			return lag < chosenLag
+		}
+		return replica.ID < chosen.ID
+	}
+	for _, replica := range nodes.FilterByFunc(func(n *Node) bool { return n.MasterReferent == masterID }) {
+		if replica.LinkState == RedisLinkStateDisconnected || replica.HasStatus(NodeStatusFail) ||
+			replica.HasStatus(NodeStatusPFail) || replica.HasStatus(NodeStatusNoFailover) {
+			continue
+		}
+		lag, ok := lags[replica.IPPort()]
+		if !ok {
+			continue
+		}
+		if better(replica, lag) {
+			chosen, chosenLag = replica, lag
+		}
+	}
+	if chosen == nil {
+		return "", fmt.Errorf("no replica of master %s eligible to failover", masterID)
+	}
+	covered := len(nodes.slotOwners())
+
+	if err := a.waitPromotable(ctx, master.IPPort(), chosen.IPPort()); err != nil {
+		return "", err
+	}
+	c := a.nodeClient(chosen.IPPort())
+	defer c.Close()
+	if err := c.ClusterFailover(ctx).Err(); err != nil {
+		return "", fmt.Errorf("unable to failover replica %s: %v", chosen.ID, err)
+	}
+	if err := a.WaitForRole(ctx, chosen.IPPort(), RedisMasterRole, a.promoteTimeout()); err != nil {
+		return "", err
+	}
+
+	after, err := getClusterNodes(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	promoted, err := after.GetNodeByID(chosen.ID)
+	if err != nil {
+		return "", err
+	}
+	if formatSlots(promoted.Slots) != formatSlots(master.Slots) {
+		return "", fmt.Errorf("replica %s promoted but owns slots %s instead of %s", chosen.ID, formatSlots(promoted.Slots), formatSlots(master.Slots))
+	}
+	if now := len(after.slotOwners()); now != covered {
+		return "", fmt.Errorf("replica %s promoted but %d slots are covered instead of %d", chosen.ID, now, covered)
+	}
+	logger.Infof("replica %s promoted in place of master %s", chosen.ID, masterID)
+	return chosen.ID, nil
+}
+
+// ClusterReplicate turns the node at slaveAddr into a replica of the master ID with CLUSTER REPLICATE, then
+// waits for the cluster view to report the node replicating it. An error is returned without contacting the
+// node if the master ID is unknown or isn't a master.
+func (a *Admin) ClusterReplicate(ctx context.Context, slaveAddr, masterID string) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	master, err := nodes.GetNodeByID(masterID)
+	if err != nil {
+		return fmt.Errorf("unable to replicate master %s from node %s: %v", masterID, slaveAddr, err)
+	}
+	if master.GetRole() != RedisMasterRole {
+		return fmt.Errorf("unable to replicate node %s from node %s: not a master", masterID, slaveAddr)
+	}
+
+	c := a.nodeClient(slaveAddr)
+	err = c.ClusterReplicate(ctx, masterID).Err()
+	c.Close()
+	if err != nil {
+		return fmt.Errorf("unable to attach node %s to master %s: %v", slaveAddr, masterID, err)
+	}
+
+	deadline := time.Now().Add(replicateTimeout)
+	for {
+		nodes, err := getClusterNodes(ctx, a.rc)
+		if err != nil {
+			return err
+		}
+		if slave, err := nodes.GetNodeByAddr(slaveAddr); err == nil && slave.MasterReferent == masterID {
+			logger.Infof("node %s replicates master %s", slaveAddr, masterID)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("node %s doesn't replicate master %s after %s", slaveAddr, masterID, replicateTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// ClusterFailover triggers a manual failover on the replica at slaveAddr with CLUSTER FAILOVER. With force the
+// replica doesn't wait for its master agreement (FORCE), with takeover it doesn't wait for the other masters
+// either (TAKEOVER); both can't be set. An error is returned without failing over if the node isn't a replica.
+// Unlike PromoteReplica, it returns without waiting for the replica to become master.
+func (a *Admin) ClusterFailover(ctx context.Context, slaveAddr string, force, takeover bool) error {
+	if force && takeover {
+		return fmt.Errorf("unable to failover node %s: force and takeover are exclusive", slaveAddr)
+	}
+	c := a.nodeClient(slaveAddr)
+	defer c.Close()
+	node, err := getSelfNode(ctx, c)
+	if err != nil {
+		return err
+	}
+	if node.GetRole() != RedisSlaveRole {
+		return fmt.Errorf("unable to failover node %s: role is %s, not %s", slaveAddr, node.GetRole(), RedisSlaveRole)
+	}
+
+	args := []interface{}{"CLUSTER", "FAILOVER"}
+	switch {
+	case force:
+		args = append(args, "FORCE")
+	case takeover:
+		args = append(args, "TAKEOVER")
+	}
+	if err := c.Do(ctx, args...).Err(); err != nil {
+		return fmt.Errorf("unable to failover node %s: %v", slaveAddr, err)
+	}
+	return nil
+}
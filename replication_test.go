@@ -0,0 +1,599 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// masterHandler returns a handler replying CLUSTER NODES and the INFO replication of a master
+// whose replicas are at the provided offsets
+func masterHandler(view *string, offset int64, replicaOffsets map[string]int64) fakeHandler {
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return *view
+		case "INFO":
+			info := fmt.Sprintf("# Replication\r\nrole:master\r\nconnected_slaves:%d\r\n", len(replicaOffsets))
+			i := 0
+			for addr, replicaOffset := range replicaOffsets {
+				host, port, _ := net.SplitHostPort(addr)
+				info += fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,offset=%d,lag=0\r\n", i, host, port, replicaOffset)
+				i++
+			}
+			return info + fmt.Sprintf("master_repl_offset:%d\r\n", offset)
+		}
+		return fakeOK
+	}
+}
+
+// failoverHandler returns a handler replying the slave role until a CLUSTER FAILOVER is received
+func failoverHandler() fakeHandler {
+	role := RedisSlaveRole
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER FAILOVER":
+			role = RedisMasterRole
+		case "INFO":
+			return "# Replication\r\nrole:" + role + "\r\n"
+		}
+		return fakeOK
+	}
+}
+
+func TestDecodeReplicas(t *testing.T) {
+	info := parseInfo("role:master\r\nconnected_slaves:2\r\n" +
+		"slave0:ip=10.0.0.2,port=6379,state=online,offset=1000,lag=0\r\n" +
+		"slave1:ip=10.0.0.3,port=6380,state=wait_bgsave,offset=0,lag=1\r\n" +
+		"master_repl_offset:1200\r\n")
+	replicas, err := DecodeReplicas(info)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replicas) != 2 || replicas[0].Addr != "10.0.0.2:6379" || replicas[0].Lag != 200 ||
+		replicas[1].State != "wait_bgsave" || replicas[1].Lag != 1200 {
+		t.Errorf("unexpected replicas: %+v", replicas)
+	}
+}
+
+func TestAdminPromoteReplica(t *testing.T) {
+	tests := []struct {
+		name          string
+		replicaOffset int64
+		maxLag        int64
+		wantLagging   bool
+	}{
+		{name: "replica caught up", replicaOffset: 10 << 20},
+		{name: "replica within the default lag", replicaOffset: 10<<20 - 1000},
+		{name: "lagging replica", replicaOffset: 8 << 20, wantLagging: true},
+		{name: "replica over the lag option", replicaOffset: 10<<20 - 1000, maxLag: 100, wantLagging: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var view string
+			replica := newFakeRedis(t, failoverHandler())
+			master := newFakeRedis(t, masterHandler(&view, 10<<20, map[string]int64{replica.Addr(): tt.replicaOffset}))
+			view = fakeClusterNodes(
+				fakeNodeLine("M", master.Addr(), "myself,master", "-", "0-16383"),
+				fakeNodeLine("R", replica.Addr(), "slave", "M"),
+			)
+			a := NewAdminWithOptions([]string{master.Addr()}, AdminOptions{PromoteTimeout: 300 * time.Millisecond, PromoteMaxLag: tt.maxLag}).(*Admin)
+
+			err := a.PromoteReplica(context.Background(), "R")
+			failovers := len(replica.Calls("CLUSTER FAILOVER"))
+			if tt.wantLagging {
+				if !IsReplicaLaggingError(err) {
+					t.Errorf("expected a ReplicaLaggingError, got %v", err)
+				}
+				if failovers != 0 {
+					t.Errorf("expected no CLUSTER FAILOVER for a lagging replica")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if failovers != 1 {
+				t.Errorf("expected one CLUSTER FAILOVER on the replica, got %d", failovers)
+			}
+		})
+	}
+}
+
+func TestAdminLaggingReplicas(t *testing.T) {
+	var view string
+	master := newFakeRedis(t, masterHandler(&view, 10000, map[string]int64{
+		"10.0.0.2:6379": 10000,
+		"10.0.0.3:6379": 9000,
+		"10.0.0.4:6379": 8999,
+		"10.0.0.5:6379": 10000,
+	}))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", master.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", "10.0.0.2:6379", "slave", "A"),
+		fakeNodeLine("C", "10.0.0.3:6379", "slave", "A"),
+		fakeNodeLine("D", "10.0.0.4:6379", "slave", "A"),
+		strings.Replace(fakeNodeLine("E", "10.0.0.5:6379", "slave", "A"), "connected", "disconnected", 1),
+		fakeNodeLine("F", "10.0.0.6:6379", "slave", "A"),
+	)
+	a := newTestAdmin(master.Addr())
+
+	lagging, err := a.LaggingReplicas(context.Background(), 1000)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := []string{}
+	for _, node := range lagging {
+		got = append(got, node.ID)
+	}
+	if want := []string{"D", "E", "F"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LaggingReplicas() = %v, want %v", got, want)
+	}
+	if calls := master.Calls("INFO"); len(calls) != 1 {
+		t.Errorf("expected the master INFO to be read once, got %d", len(calls))
+	}
+}
+
+func TestAdminOnReplica(t *testing.T) {
+	var view string
+	near := newFakeRedis(t, clusterNodesHandler(&view))
+	far := newFakeRedis(t, clusterNodesHandler(&view))
+	failing := newFakeRedis(t, clusterNodesHandler(&view))
+	master := newFakeRedis(t, masterHandler(&view, 1000, map[string]int64{
+		near.Addr():    990,
+		far.Addr():     500,
+		failing.Addr(): 1000,
+	}))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", master.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", far.Addr(), "slave", "A"),
+		fakeNodeLine("C", near.Addr(), "slave", "A"),
+		fakeNodeLine("D", failing.Addr(), "slave,fail", "A"),
+	)
+	a := newTestAdmin(master.Addr())
+
+	var addr string
+	if err := a.OnReplica(context.Background(), "A", func(ctx context.Context, c *redis.Client) error {
+		addr = c.Options().Addr
+		// a dedicated connection, while the next command needs another one
+		conn := c.Conn(ctx)
+		defer conn.Close()
+		if err := conn.Ping(ctx).Err(); err != nil {
+			return err
+		}
+		return c.Ping(ctx).Err()
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addr != near.Addr() {
+		t.Errorf("expected the least lagging replica %s to be chosen, got %s", near.Addr(), addr)
+	}
+	if len(near.Calls("READONLY")) != 2 || len(near.Calls("PING")) != 2 {
+		t.Errorf("expected READONLY on each connection then fn on the chosen replica, got %d READONLY", len(near.Calls("READONLY")))
+	}
+	if len(master.Calls("PING")) != 0 || len(far.Calls("PING")) != 0 || len(failing.Calls("PING")) != 0 {
+		t.Errorf("expected fn to run on the chosen replica only")
+	}
+
+	if err := a.OnReplica(context.Background(), "C", func(ctx context.Context, c *redis.Client) error { return nil }); err == nil {
+		t.Errorf("expected an error for a node without replica")
+	}
+}
+
+func TestAdminWaitAOF(t *testing.T) {
+	reply := []interface{}{int64(1), int64(2)}
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "WAITAOF" {
+			return reply
+		}
+		return fakeError("ERR unexpected command")
+	})
+	a := newTestAdmin(fake.Addr())
+
+	local, replicas, err := a.WaitAOF(context.Background(), fake.Addr(), 1, 2, 1500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if local != 1 || replicas != 2 {
+		t.Errorf("WaitAOF() = %d, %d, want 1, 2", local, replicas)
+	}
+	if calls := fake.Calls("WAITAOF"); len(calls) != 1 || !reflect.DeepEqual(calls[0][1:], []string{"1", "2", "1500"}) {
+		t.Errorf("unexpected WAITAOF arguments %v", calls)
+	}
+
+	// WAITAOF replies after the read timeout of the node clients, with or without a timeout
+	defer func(timeout time.Duration) { nodeReadTimeout = timeout }(nodeReadTimeout)
+	nodeReadTimeout = 50 * time.Millisecond
+	slow := newFakeRedis(t, func(args []string) interface{} {
+		time.Sleep(200 * time.Millisecond)
+		return reply
+	})
+	for _, timeout := range []time.Duration{time.Second, 0} {
+		if _, _, err := a.WaitAOF(context.Background(), slow.Addr(), 1, 2, timeout); err != nil {
+			t.Errorf("unexpected error with timeout %s: %v", timeout, err)
+		}
+	}
+
+	reply = []interface{}{int64(1)}
+	if _, _, err := a.WaitAOF(context.Background(), fake.Addr(), 1, 2, time.Second); err == nil {
+		t.Errorf("expected an error for a malformed reply")
+	}
+}
+
+func TestAdminReplBacklog(t *testing.T) {
+	info := "# Replication\r\nrole:master\r\nconnected_slaves:1\r\nmaster_repl_offset:2097152\r\n" +
+		"repl_backlog_active:1\r\nrepl_backlog_size:1048576\r\nrepl_backlog_first_byte_offset:1048577\r\nrepl_backlog_histlen:1048576\r\n"
+	fake := newFakeRedis(t, func(args []string) interface{} { return info })
+	a := newTestAdmin(fake.Addr())
+
+	size, active, err := a.ReplBacklog(context.Background(), fake.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size != 1048576 || active != 1048576 {
+		t.Errorf("ReplBacklog() = %d, %d, want a full backlog of 1048576", size, active)
+	}
+
+	info = "# Replication\r\nrole:master\r\nrepl_backlog_active:0\r\nrepl_backlog_size:1048576\r\nrepl_backlog_histlen:0\r\n"
+	if size, active, err = a.ReplBacklog(context.Background(), fake.Addr()); err != nil || size != 1048576 || active != 0 {
+		t.Errorf("ReplBacklog() = %d, %d, %v, want an unallocated backlog", size, active, err)
+	}
+}
+
+// digestHandler returns a handler replying CLUSTER NODES, random keys among the values, and the values
+// as their digest
+func digestHandler(view *string, values map[string]string) fakeHandler {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	i := 0
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return *view
+		case "RANDOMKEY":
+			i++
+			return keys[i%len(keys)]
+		case "DEBUG DIGEST-VALUE":
+			digests := []string{}
+			for _, key := range args[2:] {
+				digest, ok := values[key]
+				if !ok {
+					digest = "0000000000000000000000000000000000000000"
+				}
+				digests = append(digests, digest)
+			}
+			return digests
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminVerifyReplicaConsistency(t *testing.T) {
+	var view string
+	values := map[string]string{"k1": "d1", "k2": "d2", "k3": "d3"}
+	master := newFakeRedis(t, digestHandler(&view, values))
+	consistent := newFakeRedis(t, digestHandler(&view, map[string]string{"k1": "d1", "k2": "d2", "k3": "d3"}))
+	inconsistent := newFakeRedis(t, digestHandler(&view, map[string]string{"k1": "d1", "k2": "corrupted"}))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", master.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", consistent.Addr(), "slave", "A"),
+		fakeNodeLine("C", inconsistent.Addr(), "slave", "A"),
+	)
+	a := newTestAdmin(master.Addr())
+
+	if err := a.VerifyReplicaConsistency(context.Background(), "B", 10); err != nil {
+		t.Errorf("unexpected error on a consistent replica: %v", err)
+	}
+	if len(consistent.Calls("READONLY")) != 1 {
+		t.Errorf("expected READONLY on the replica")
+	}
+
+	err := a.VerifyReplicaConsistency(context.Background(), "C", 10)
+	if !IsReplicaInconsistencyError(err) {
+		t.Fatalf("expected a ReplicaInconsistencyError, got %v", err)
+	}
+	keys := err.(ReplicaInconsistencyError).Keys
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"k2", "k3"}) {
+		t.Errorf("expected k2 and k3 to differ, got %v", keys)
+	}
+
+	if calls := master.Calls("RANDOMKEY"); len(calls) > 2*20 {
+		t.Errorf("expected the sampling to be bounded, got %d RANDOMKEY", len(calls))
+	}
+}
+
+// dumpHandler returns a handler refusing DEBUG as Redis 7 does by default, replying CLUSTER NODES, random
+// keys among the values, and the values as their DUMP payload
+// typedHandler returns a digestHandler refusing DEBUG and serving the values by type: a string, a set
+// ([]string) or a hash (map[string]string)
+func typedHandler(view *string, values map[string]interface{}) fakeHandler {
+	keys := map[string]string{}
+	for key := range values {
+		keys[key] = key
+	}
+	digest := digestHandler(view, keys)
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "DEBUG DIGEST-VALUE":
+			return fakeError("ERR DEBUG command not allowed. If the enable-debug-command option is set to \"no\", you can't use it.")
+		case "TYPE":
+			switch values[args[1]].(type) {
+			case string:
+				return fakeStatus("string")
+			case []string:
+				return fakeStatus("set")
+			case map[string]string:
+				return fakeStatus("hash")
+			}
+			return fakeStatus("none")
+		case "GET", "SMEMBERS":
+			return values[args[1]]
+		case "HGETALL":
+			// fields in map order, as an encoding can reorder them
+			reply := []string{}
+			for field, value := range values[args[1]].(map[string]string) {
+				reply = append(reply, field, value)
+			}
+			return reply
+		}
+		return digest(args)
+	}
+}
+
+func TestAdminVerifyReplicaConsistencyWithoutDebug(t *testing.T) {
+	var view string
+	master := newFakeRedis(t, typedHandler(&view, map[string]interface{}{
+		"k1": "v1",
+		"k2": "v2",
+		"k3": "v3",
+		"s":  []string{"a", "b", "c"},
+		"h":  map[string]string{"f1": "a", "f2": "b", "f3": "c"},
+	}))
+	// the set members are listed in another order, as with an intset on one side and a hashtable on the other
+	replica := newFakeRedis(t, typedHandler(&view, map[string]interface{}{
+		"k1": "v1",
+		"k2": "corrupted",
+		"s":  []string{"c", "a", "b"},
+		"h":  map[string]string{"f1": "a", "f2": "b", "f3": "c"},
+	}))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", master.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", replica.Addr(), "slave", "A"),
+	)
+	a := newTestAdmin(master.Addr())
+
+	err := a.VerifyReplicaConsistency(context.Background(), "B", 10)
+	if !IsReplicaInconsistencyError(err) {
+		t.Fatalf("expected a ReplicaInconsistencyError, got %v", err)
+	}
+	keys := err.(ReplicaInconsistencyError).Keys
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"k2", "k3"}) {
+		t.Errorf("expected k2 and k3 to differ, got %v", keys)
+	}
+	if len(replica.Calls("TYPE")) != 5 || len(replica.Calls("DUMP")) != 0 {
+		t.Errorf("expected the replica values to be compared by type, got %v", replica.Calls("TYPE"))
+	}
+}
+
+func TestAdminReattachOrphans(t *testing.T) {
+	var view string
+	r1 := newFakeRedis(t, clusterNodesHandler(&view))
+	r2 := newFakeRedis(t, clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", "10.0.0.1:6379", "master", "-", "0-8191"),
+		fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "8192-16383"),
+		fakeNodeLine("X", "10.0.0.3:6379", "master,fail", "-"),
+		fakeNodeLine("R1", r1.Addr(), "myself,slave", "X"),
+		fakeNodeLine("R2", r2.Addr(), "slave", "unknown"),
+	)
+	a := newTestAdmin(r1.Addr())
+
+	pairs, err := a.ReattachOrphans(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"R1->A", "R2->B"}; !reflect.DeepEqual(pairs, want) {
+		t.Errorf("ReattachOrphans() = %v, want %v", pairs, want)
+	}
+	for name, fake := range map[string]*fakeRedis{"A": r1, "B": r2} {
+		calls := fake.Calls("CLUSTER REPLICATE")
+		if len(calls) != 1 || calls[0][2] != name {
+			t.Errorf("expected CLUSTER REPLICATE %s, got %v", name, calls)
+		}
+	}
+}
+
+func TestAdminReattachOrphansDuringFailover(t *testing.T) {
+	var view string
+	r1 := newFakeRedis(t, clusterNodesHandler(&view))
+	// X is failing but still owns its slots, R1 is expected to take them over
+	view = fakeClusterNodes(
+		fakeNodeLine("A", "10.0.0.1:6379", "master", "-", "0-8191"),
+		fakeNodeLine("X", "10.0.0.3:6379", "master,fail?", "-", "8192-16383"),
+		fakeNodeLine("R1", r1.Addr(), "myself,slave", "X"),
+	)
+	a := newTestAdmin(r1.Addr())
+
+	pairs, err := a.ReattachOrphans(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pairs) != 0 || len(r1.Calls("CLUSTER REPLICATE")) != 0 {
+		t.Errorf("expected the replica of a failing master owning slots to be kept, got %v", pairs)
+	}
+}
+
+func TestAdminClusterReplicate(t *testing.T) {
+	defer func(d time.Duration) { replicateTimeout = d }(replicateTimeout)
+	replicateTimeout = 300 * time.Millisecond
+
+	var view, replicated string
+	m := newFakeRedis(t, clusterNodesHandler(&view))
+	n := newFakeRedis(t, func(args []string) interface{} {
+		// the new view is gossiped once the node received CLUSTER REPLICATE
+		if fakeCmdName(args) == "CLUSTER REPLICATE" {
+			view = replicated
+		}
+		return fakeOK
+	})
+	view = fakeClusterNodes(
+		fakeNodeLine("A", m.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("N", n.Addr(), "master", "-"),
+	)
+	replicated = fakeClusterNodes(
+		fakeNodeLine("A", m.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("N", n.Addr(), "slave", "A"),
+	)
+	a := newTestAdmin(m.Addr())
+	ctx := context.Background()
+
+	if err := a.ClusterReplicate(ctx, n.Addr(), "unknown"); err == nil {
+		t.Errorf("expected an error for an unknown master")
+	}
+	if calls := n.Calls("CLUSTER REPLICATE"); len(calls) != 0 {
+		t.Fatalf("expected nothing to be sent for an unknown master, got %v", calls)
+	}
+
+	if err := a.ClusterReplicate(ctx, n.Addr(), "A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := n.Calls("CLUSTER REPLICATE"); len(calls) != 1 || calls[0][2] != "A" {
+		t.Errorf("expected CLUSTER REPLICATE A, got %v", calls)
+	}
+
+	if err := a.ClusterReplicate(ctx, m.Addr(), "N"); err == nil {
+		t.Errorf("expected an error when replicating a slave")
+	}
+}
+
+func TestAdminClusterFailover(t *testing.T) {
+	var view string
+	m := newFakeRedis(t, selfHandler("A", &view))
+	r := newFakeRedis(t, selfHandler("B", &view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", m.Addr(), "master", "-", "0-16383"),
+		fakeNodeLine("B", r.Addr(), "slave", "A"),
+	)
+	a := newTestAdmin(m.Addr())
+	ctx := context.Background()
+
+	if err := a.ClusterFailover(ctx, m.Addr(), false, false); err == nil {
+		t.Errorf("expected an error when failing over a master")
+	}
+	if err := a.ClusterFailover(ctx, r.Addr(), true, true); err == nil {
+		t.Errorf("expected an error with both force and takeover")
+	}
+	if calls := append(m.Calls("CLUSTER FAILOVER"), r.Calls("CLUSTER FAILOVER")...); len(calls) != 0 {
+		t.Fatalf("expected no failover to be sent, got %v", calls)
+	}
+
+	for _, opts := range []struct{ force, takeover bool }{{false, false}, {true, false}, {false, true}} {
+		if err := a.ClusterFailover(ctx, r.Addr(), opts.force, opts.takeover); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	calls := r.Calls("CLUSTER FAILOVER")
+	if len(calls) != 3 || len(calls[0]) != 2 || calls[1][2] != "FORCE" || calls[2][2] != "TAKEOVER" {
+		t.Errorf("expected CLUSTER FAILOVER, then with FORCE, then with TAKEOVER, got %v", calls)
+	}
+}
+
+// promotedHandler returns a handler replying the slave role and the view until a CLUSTER FAILOVER is
+// received, then the master role and the view after the failover
+func promotedHandler(before, after *string) fakeHandler {
+	promoted := false
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER FAILOVER":
+			promoted = true
+		case "CLUSTER NODES":
+			if promoted {
+				return *after
+			}
+			return *before
+		case "INFO":
+			if promoted {
+				return "# Replication\r\nrole:master\r\n"
+			}
+			return "# Replication\r\nrole:slave\r\n"
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminSafeFailover(t *testing.T) {
+	var before, after string
+	r2 := newFakeRedis(t, promotedHandler(&before, &after))
+	master := newFakeRedis(t, masterHandler(&before, 1000, map[string]int64{
+		"10.0.0.1:6379": 900,
+		r2.Addr():       1000,
+		"10.0.0.3:6379": 1000,
+	}))
+	before = fakeClusterNodes(
+		fakeNodeLine("M", master.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("N", "10.0.0.9:6379", "master", "-", "8192-16383"),
+		fakeNodeLine("R1", "10.0.0.1:6379", "slave", "M"),
+		fakeNodeLine("R2", r2.Addr(), "slave", "M"),
+		fakeNodeLine("R3", "10.0.0.3:6379", "slave,nofailover", "M"),
+	)
+	after = fakeClusterNodes(
+		fakeNodeLine("M", master.Addr(), "slave", "R2"),
+		fakeNodeLine("N", "10.0.0.9:6379", "master", "-", "8192-16383"),
+		fakeNodeLine("R1", "10.0.0.1:6379", "slave", "R2"),
+		fakeNodeLine("R2", r2.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("R3", "10.0.0.3:6379", "slave,nofailover", "R2"),
+	)
+	a := NewAdminWithOptions([]string{master.Addr()}, AdminOptions{PromoteTimeout: 300 * time.Millisecond}).(*Admin)
+
+	promoted, err := a.SafeFailover(context.Background(), "M")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if promoted != "R2" || len(r2.Calls("CLUSTER FAILOVER")) != 1 {
+		t.Errorf("expected R2 to be promoted, got %q", promoted)
+	}
+}
+
+func TestAdminSafeFailoverNoEligibleReplica(t *testing.T) {
+	var view string
+	master := newFakeRedis(t, masterHandler(&view, 1000, map[string]int64{
+		"10.0.0.1:6379": 1000,
+		"10.0.0.2:6379": 1000,
+	}))
+	view = fakeClusterNodes(
+		fakeNodeLine("M", master.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("R1", "10.0.0.1:6379", "slave,nofailover", "M"),
+		fakeNodeLine("R2", "10.0.0.2:6379", "slave,fail", "M"),
+	)
+	a := newTestAdmin(master.Addr())
+
+	if _, err := a.SafeFailover(context.Background(), "M"); err == nil || !strings.Contains(err.Error(), "no replica of master M eligible") {
+		t.Errorf("expected no eligible replica, got %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// joinTimeout max time to wait for a new node to join the cluster, then for the cluster to be ok after a scale up,
+// when AdminOptions.JoinTimeout isn't set
+var joinTimeout = 30 * time.Second
+
+// leastLoaded returns the master with the fewest value, the lowest ID first on ties
+func leastLoaded(masters Nodes, value func(*Node) int) *Node {
+	var least *Node
+	for _, master := range masters {
+		if least == nil || value(master) < value(least) || (value(master) == value(least) && master.ID < least.ID) {
+			least = master
+		}
+	}
+	return least
+}
+
+// loads returns the number of slots and of replicas of each master
+func loads(nodes, masters Nodes) (slots, replicas map[string]int) {
+	slots = make(map[string]int, len(masters))
+	replicas = make(map[string]int, len(masters))
+	for _, master := range masters {
+		slots[master.ID] = len(master.Slots)
+		replicas[master.ID] = nodes.CountByFunc(func(n *Node) bool { return n.MasterReferent == master.ID })
+	}
+	return slots, replicas
+}
+
+// planRemoval returns the plan emptying the master before its removal: each slot, in slot order, goes to the
+// remaining master owning the fewest slots and each replica to the remaining master with the fewest replicas.
+// The slots and replicas counts of the remaining masters are updated with the plan.
+func planRemoval(nodes Nodes, master *Node, remaining Nodes, slots, replicas map[string]int) ReshardPlan {
+	plan := newReshardPlan()
+	ordered := append([]Slot{}, master.Slots...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i] < ordered[j] })
+	for _, slot := range ordered {
+		dst := leastLoaded(remaining, func(n *Node) int { return slots[n.ID] })
+		plan.Migrations = append(plan.Migrations, SlotMigration{Slot: slot, From: master.ID, To: dst.ID})
+		plan.SlotsPerTarget[dst.ID]++
+		slots[dst.ID]++
+	}
+
+	attached := nodes.FilterByFunc(func(n *Node) bool { return n.MasterReferent == master.ID })
+	sort.Sort(attached)
+	for _, replica := range attached {
+		dst := leastLoaded(remaining, func(n *Node) int { return replicas[n.ID] })
+		plan.ReplicaReassignments[replica.ID] = dst.ID
+		replicas[dst.ID]++
+	}
+	return plan
+}
+
+// ScaleDownTo removes masters until the cluster has targetMasters masters, and returns the removed IDs.
+// The masters owning the fewest slots are removed: each slot is migrated to the remaining master owning
+// the fewest slots and its new owner is broadcast to every master, each replica is attached to the
+// remaining master with the fewest replicas and waited for to be in sync with it, then the master is
+// removed from the cluster. It refuses to go below 1 master.
+func (a *Admin) ScaleDownTo(ctx context.Context, targetMasters int) ([]string, error) {
+	if targetMasters < 1 {
+		return nil, fmt.Errorf("unable to scale down to %d masters, at least 1 is required", targetMasters)
+	}
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+	masters := nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole })
+	if len(masters) <= targetMasters {
+		return []string{}, nil
+	}
+
+	sorted := append(Nodes{}, masters...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].Slots) != len(sorted[j].Slots) {
+			return len(sorted[i].Slots) < len(sorted[j].Slots)
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	removed, remaining := sorted[:len(sorted)-targetMasters], sorted[len(sorted)-targetMasters:]
+
+	slots, replicas := loads(*nodes, remaining)
+	removedIDs := []string{}
+	for i, master := range removed {
+		logger.Infof("scaling down: removing master %s owning %d slots", master.ID, len(master.Slots))
+		plan := planRemoval(*nodes, master, remaining, slots, replicas)
+		if err := a.ExecutePlan(ctx, &plan); err != nil {
+			return removedIDs, err
+		}
+		// the masters still to be removed serve their slots until then
+		if err := a.broadcastSlotOwners(ctx, append(append(Nodes{}, remaining...), removed[i+1:]...), plan.Migrations); err != nil {
+			return removedIDs, err
+		}
+		if err := a.waitReassignedReplicas(ctx, *nodes, plan.ReplicaReassignments); err != nil {
+			return removedIDs, err
+		}
+
+		if err := a.RemoveNode(ctx, master.ID, 0); err != nil {
+			return removedIDs, err
+		}
+		removedIDs = append(removedIDs, master.ID)
+	}
+	return removedIDs, nil
+}
+
+// broadcastSlotOwners assigns the slot of each migration to its destination on the masters with CLUSTER
+// SETSLOT NODE, so that they don't wait for the gossip to learn the new owners. MigrateSlot already assigned
+// the slot on the destination.
+func (a *Admin) broadcastSlotOwners(ctx context.Context, masters Nodes, migs []SlotMigration) error {
+	for _, master := range masters {
+		c := a.nodeClient(master.IPPort())
+		for _, mig := range migs {
+			if mig.To == master.ID {
+				continue
+			}
+			if err := c.Do(ctx, "CLUSTER", "SETSLOT", int(mig.Slot), "NODE", mig.To).Err(); err != nil {
+				c.Close()
+				return fmt.Errorf("unable to assign slot %s to node %s on node %s: %v", mig.Slot, mig.To, master.ID, err)
+			}
+		}
+		c.Close()
+	}
+	return nil
+}
+
+// waitReassignedReplicas waits for each replica, keyed by ID, to be in sync with its new master within
+// AdminOptions.PromoteMaxLag, so that a master it stopped replicating can be removed
+func (a *Admin) waitReassignedReplicas(ctx context.Context, nodes Nodes, reassignments map[string]string) error {
+	byMaster := map[string][]string{}
+	for replicaID, masterID := range reassignments {
+		replica, err := nodes.GetNodeByID(replicaID)
+		if err != nil {
+			return fmt.Errorf("replica %s not found: %v", replicaID, err)
+		}
+		byMaster[masterID] = append(byMaster[masterID], replica.IPPort())
+	}
+	for masterID, addrs := range byMaster {
+		master, err := nodes.GetNodeByID(masterID)
+		if err != nil {
+			return fmt.Errorf("master %s not found: %v", masterID, err)
+		}
+		if err := a.waitReplicas(ctx, master.IPPort(), replicateTimeout, a.promoteMaxLag(), addrs...); err != nil {
+			return fmt.Errorf("replicas %v not in sync with master %s: %v", addrs, masterID, err)
+		}
+	}
+	return nil
+}
+
+// ScaleUpTo adds masters from newMasterAddrs until the cluster has targetMasters masters. Each new node is
+// given a distinct config epoch then met, and once all of them joined the slots are moved from the masters
+// owning the most slots until every master owns its share. It returns when all the slots are covered and
+// the cluster state is ok, waiting for each step up to AdminOptions.JoinTimeout.
+func (a *Admin) ScaleUpTo(ctx context.Context, newMasterAddrs []string, targetMasters int) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	masters := nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole })
+	if len(masters) >= targetMasters {
+		return nil
+	}
+	missing := targetMasters - len(masters)
+	if len(newMasterAddrs) < missing {
+		return fmt.Errorf("unable to scale up to %d masters: %d new nodes required, %d provided", targetMasters, missing, len(newMasterAddrs))
+	}
+
+	infos, err := getClusterInfos(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	epoch, err := strconv.ParseInt((*infos)["cluster_current_epoch"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("wrong format for cluster_current_epoch in CLUSTER INFO: %v", err)
+	}
+
+	added := Nodes{}
+	for i, addr := range newMasterAddrs[:missing] {
+		ip, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		c := a.nodeClient(addr)
+		id, err := c.Do(ctx, "CLUSTER", "MYID").Text()
+		if err == nil {
+			err = c.Do(ctx, "CLUSTER", "SET-CONFIG-EPOCH", epoch+int64(i)+1).Err()
+		}
+		c.Close()
+		if err != nil {
+			return fmt.Errorf("unable to set the config epoch of node %s: %v", addr, err)
+		}
+		node := &Node{ID: id, IP: ip, Port: port, Role: RedisMasterRole}
+		host, port := node.HostPortArgs()
+		if err := a.rc.ClusterMeet(ctx, host, port).Err(); err != nil {
+			return fmt.Errorf("unable to meet node %s: %v", addr, err)
+		}
+		added = append(added, node)
+	}
+	for _, node := range added {
+		if _, err := a.waitNodeJoined(ctx, node.ID, byID(node.ID), a.nodeJoinTimeout()); err != nil {
+			return err
+		}
+	}
+
+	total := int(a.hashMaxSlots) + 1
+	share := total / targetMasters
+	slots := make(map[string][]Slot, len(masters))
+	for _, master := range masters {
+		slots[master.ID] = append([]Slot{}, master.Slots...)
+		sort.Slice(slots[master.ID], func(i, j int) bool { return slots[master.ID][i] < slots[master.ID][j] })
+	}
+	for _, node := range added {
+		logger.Infof("scaling up: moving %d slots to new master %s", share, node.ID)
+		for received := 0; received < share; received++ {
+			src := leastLoaded(masters, func(n *Node) int { return -len(slots[n.ID]) })
+			owned := slots[src.ID]
+			if len(owned) == 0 {
+				break
+			}
+			slot := owned[len(owned)-1]
+			if err := a.MigrateSlot(ctx, slot, src, node, migrateTimeout); err != nil {
+				return err
+			}
+			slots[src.ID] = owned[:len(owned)-1]
+		}
+	}
+	return a.waitClusterOK(ctx, a.nodeJoinTimeout())
+}
+
+// AddNode joins the node at newAddr to the cluster with a CLUSTER MEET issued on the single client node, the
+// host being resolved to an IP as CLUSTER MEET requires. It returns once the cluster view shows the node out of
+// handshake, under its own ID, or an error after AdminOptions.JoinTimeout.
+func (a *Admin) AddNode(ctx context.Context, newAddr string) error {
+	host, port, err := net.SplitHostPort(newAddr)
+	if err != nil {
+		return fmt.Errorf("wrong format for node address %s: %v", newAddr, err)
+	}
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("unable to resolve node address %s: %v", newAddr, err)
+	}
+	addr := net.JoinHostPort(ips[0], port)
+	if err := a.rc.ClusterMeet(ctx, ips[0], port).Err(); err != nil {
+		return fmt.Errorf("unable to meet node %s: %v", addr, err)
+	}
+
+	node, err := a.waitNodeJoined(ctx, addr, func(nodes Nodes) (*Node, error) {
+		node, err := nodes.GetNodeByAddr(addr)
+		if err == nil && node.ID == "" {
+			err = fmt.Errorf("node %s has no ID yet", addr)
+		}
+		return node, err
+	}, a.nodeJoinTimeout())
+	if err != nil {
+		return err
+	}
+	logger.Infof("node %s joined the cluster with ID %s", addr, node.ID)
+	return nil
+}
+
+// nodeJoinTimeout returns the max time to wait for a new node to join the cluster, AdminOptions.JoinTimeout
+// or joinTimeout if not set
+func (a *Admin) nodeJoinTimeout() time.Duration {
+	if a.opts.JoinTimeout > 0 {
+		return a.opts.JoinTimeout
+	}
+	return joinTimeout
+}
+
+// byID returns a function finding the node ID among nodes, for waitNodeJoined
+func byID(id string) func(Nodes) (*Node, error) {
+	return func(nodes Nodes) (*Node, error) { return nodes.GetNodeByID(id) }
+}
+
+// waitNodeJoined waits, up to timeout, until find returns the node name from the cluster view, out of
+// handshake, and returns it
+func (a *Admin) waitNodeJoined(ctx context.Context, name string, find func(Nodes) (*Node, error), timeout time.Duration) (*Node, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		nodes, err := getClusterNodes(ctx, a.rc)
+		if err != nil {
+			return nil, err
+		}
+		if node, err := find(*nodes); err == nil && !node.HasStatus(NodeStatusHandshake) {
+			return node, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("node %s didn't join the cluster after %s", name, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// waitClusterOK waits until the cluster state is ok with all the slots covered
+func (a *Admin) waitClusterOK(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		infos, err := getClusterInfos(ctx, a.rc)
+		if err != nil {
+			return err
+		}
+		if (*infos)["cluster_state"] == "ok" && (*infos)["cluster_slots_ok"] == strconv.Itoa(int(a.hashMaxSlots)+1) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cluster state %s with %s slots ok after %s", (*infos)["cluster_state"], (*infos)["cluster_slots_ok"], timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// SimulateRemoval returns, without executing anything, the plan ScaleDownTo would follow to remove the node ID:
+// where the slots and the replicas of a master would go. Removing a replica doesn't require any move.
+func (a *Admin) SimulateRemoval(ctx context.Context, nodeID string) (ReshardPlan, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return ReshardPlan{}, err
+	}
+	node, err := nodes.GetNodeByID(nodeID)
+	if err != nil {
+		return ReshardPlan{}, err
+	}
+	if node.GetRole() != RedisMasterRole {
+		return newReshardPlan(), nil
+	}
+	remaining := nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole && n.ID != nodeID })
+	if len(remaining) == 0 {
+		return ReshardPlan{}, fmt.Errorf("unable to remove master %s, it is the last one", nodeID)
+	}
+	slots, replicas := loads(*nodes, remaining)
+	return planRemoval(*nodes, node, remaining, slots, replicas), nil
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scaleDownHandler returns a forgetHandler also serving two keys in the slot, once
+func scaleDownHandler(before, after *string, slot string) fakeHandler {
+	forget := forgetHandler(before, after)
+	served := false
+	return func(args []string) interface{} {
+		if fakeCmdName(args) == "CLUSTER GETKEYSINSLOT" {
+			if args[2] == slot && !served {
+				served = true
+				return []string{"k1", "k2"}
+			}
+			return []string{}
+		}
+		return forget(args)
+	}
+}
+
+func TestAdminScaleDownTo(t *testing.T) {
+	var before, after string
+	fakes := map[string]*fakeRedis{}
+	for _, id := range []string{"A", "B", "C", "D", "E"} {
+		fakes[id] = newFakeRedis(t, scaleDownHandler(&before, &after, "16284"))
+	}
+	// E is in sync with A once attached to it
+	master := masterHandler(&before, 1000, map[string]int64{fakes["E"].Addr(): 1000})
+	forget := scaleDownHandler(&before, &after, "16284")
+	fakes["A"].SetHandler(func(args []string) interface{} {
+		if fakeCmdName(args) == "INFO" {
+			return master(args)
+		}
+		return forget(args)
+	})
+	after = fakeClusterNodes(
+		fakeNodeLine("A", fakes["A"].Addr(), "myself,master", "-", "0-5000"),
+		fakeNodeLine("B", fakes["B"].Addr(), "master", "-", "5001-10000"),
+		fakeNodeLine("C", fakes["C"].Addr(), "master", "-", "10001-16283"),
+		fakeNodeLine("E", fakes["E"].Addr(), "slave", "A"),
+	)
+	before = fakeClusterNodes(
+		fakeNodeLine("A", fakes["A"].Addr(), "myself,master", "-", "0-5000"),
+		fakeNodeLine("B", fakes["B"].Addr(), "master", "-", "5001-10000"),
+		fakeNodeLine("C", fakes["C"].Addr(), "master", "-", "10001-16283"),
+		fakeNodeLine("D", fakes["D"].Addr(), "master", "-", "16284-16383"),
+		fakeNodeLine("E", fakes["E"].Addr(), "slave", "D"),
+	)
+	a := newTestAdmin(fakes["A"].Addr())
+
+	removed, err := a.ScaleDownTo(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"D"}) {
+		t.Errorf("expected D to be removed, got %v", removed)
+	}
+
+	// B reaches A slot count, then both receive the remaining slots alternately
+	for id, want := range map[string]int{"A": 50, "B": 50, "C": 0} {
+		importing := 0
+		for _, call := range fakes[id].Calls("CLUSTER SETSLOT") {
+			if call[3] == "IMPORTING" {
+				importing++
+			}
+		}
+		if importing != want {
+			t.Errorf("expected %d slots migrated to %s, got %d", want, id, importing)
+		}
+		// the new owners of the 100 slots are broadcast to every remaining master
+		if calls := fakes[id].Calls("CLUSTER SETSLOT"); len(calls) != 100+want {
+			t.Errorf("expected %s to be told the 100 new owners, got %d SETSLOT", id, len(calls))
+		}
+	}
+	if len(fakes["A"].Calls("INFO")) == 0 {
+		t.Errorf("expected E to be waited for in sync with A")
+	}
+	if calls := fakes["D"].Calls("MIGRATE"); len(calls) != 1 || !reflect.DeepEqual(calls[0][6:], []string{"KEYS", "k1", "k2"}) {
+		t.Errorf("expected the slot keys to be migrated from D, got %v", calls)
+	}
+	if calls := fakes["E"].Calls("CLUSTER REPLICATE"); len(calls) != 1 || calls[0][2] != "A" {
+		t.Errorf("expected E to be attached to A, got %v", calls)
+	}
+	for _, id := range []string{"A", "B", "C", "E"} {
+		if calls := fakes[id].Calls("CLUSTER FORGET"); len(calls) != 1 || calls[0][2] != "D" {
+			t.Errorf("expected %s to forget D, got %v", id, calls)
+		}
+	}
+
+	if _, err := a.ScaleDownTo(context.Background(), 0); err == nil {
+		t.Errorf("expected an error scaling down to 0 master")
+	}
+}
+
+// meetHandler returns a handler replying the views before and after a CLUSTER MEET is received,
+// and an ok CLUSTER INFO covering the slots
+func meetHandler(id string, before, after *string, slots int) fakeHandler {
+	met := false
+	return func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER MEET":
+			met = true
+		case "CLUSTER MYID":
+			return id
+		case "CLUSTER INFO":
+			return clusterInfo("ok", slots, 0, 4, 4)
+		case "CLUSTER GETKEYSINSLOT":
+			return []string{}
+		case "CLUSTER NODES":
+			if met {
+				return *after
+			}
+			return *before
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminScaleUpTo(t *testing.T) {
+	var before, after string
+	fakes := map[string]*fakeRedis{}
+	for _, id := range []string{"A", "B", "C", "N"} {
+		fakes[id] = newFakeRedis(t, meetHandler(id, &before, &after, 16))
+	}
+	before = fakeClusterNodes(
+		fakeNodeLine("A", fakes["A"].Addr(), "myself,master", "-", "0-4"),
+		fakeNodeLine("B", fakes["B"].Addr(), "master", "-", "5-9"),
+		fakeNodeLine("C", fakes["C"].Addr(), "master", "-", "10-15"),
+	)
+	after = before + fakeNodeLine("N", fakes["N"].Addr(), "master", "-") + "\n"
+	a := newTestAdmin(fakes["A"].Addr())
+	a.hashMaxSlots = 15
+
+	if err := a.ScaleUpTo(context.Background(), []string{}, 4); err == nil {
+		t.Errorf("expected an error without enough new nodes")
+	}
+
+	if err := a.ScaleUpTo(context.Background(), []string{fakes["N"].Addr()}, 4); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := fakes["N"].Calls("CLUSTER SET-CONFIG-EPOCH"); len(calls) != 1 || calls[0][2] != "7" {
+		t.Errorf("expected N to be given the epoch 7, got %v", calls)
+	}
+	if calls := fakes["A"].Calls("CLUSTER MEET"); len(calls) != 1 || calls[0][2] != fakes["N"].IP() || calls[0][3] != fakes["N"].Port() {
+		t.Errorf("expected N to be met, got %v", calls)
+	}
+	imported := []string{}
+	for _, call := range fakes["N"].Calls("CLUSTER SETSLOT") {
+		if call[3] == "IMPORTING" {
+			imported = append(imported, call[2]+"<"+call[4])
+		}
+	}
+	if want := []string{"15<C", "4<A", "9<B", "14<C"}; !reflect.DeepEqual(imported, want) {
+		t.Errorf("expected slots %v to be moved to N, got %v", want, imported)
+	}
+}
+
+func TestAdminScaleUpToJoinTimeout(t *testing.T) {
+	var view string
+	seed := newFakeRedis(t, meetHandler("A", &view, &view, 16))
+	joining := newFakeRedis(t, meetHandler("N", &view, &view, 16))
+	// the new node never shows up in the cluster view
+	view = fakeClusterNodes(fakeNodeLine("A", seed.Addr(), "myself,master", "-", "0-15"))
+	a := NewAdminWithOptions([]string{seed.Addr()}, AdminOptions{JoinTimeout: 200 * time.Millisecond}).(*Admin)
+	a.hashMaxSlots = 15
+
+	start := time.Now()
+	err := a.ScaleUpTo(context.Background(), []string{joining.Addr()}, 2)
+	if err == nil || !strings.Contains(err.Error(), "didn't join the cluster after 200ms") {
+		t.Fatalf("expected the join to time out after the JoinTimeout option, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected ScaleUpTo to honour the JoinTimeout option, returned after %s", elapsed)
+	}
+}
+
+func TestAdminSimulateRemoval(t *testing.T) {
+	var view string
+	fake := newFakeRedis(t, clusterNodesHandler(&view))
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-3999"),
+		fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "4000-9999"),
+		fakeNodeLine("C", "10.0.0.3:6379", "master", "-", "10000-16383"),
+		fakeNodeLine("R1", "10.0.0.4:6379", "slave", "A"),
+		fakeNodeLine("R2", "10.0.0.5:6379", "slave", "A"),
+		fakeNodeLine("R3", "10.0.0.6:6379", "slave", "B"),
+	)
+	a := newTestAdmin(fake.Addr())
+
+	plan, err := a.SimulateRemoval(context.Background(), "A")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// B catches up with C, then they receive the slots in turn
+	if want := map[string]int{"B": 2192, "C": 1808}; !reflect.DeepEqual(plan.SlotsPerTarget, want) {
+		t.Errorf("SlotsPerTarget = %v, want %v", plan.SlotsPerTarget, want)
+	}
+	if len(plan.Migrations) != 4000 || plan.Migrations[0] != (SlotMigration{Slot: 0, From: "A", To: "B"}) {
+		t.Errorf("expected the 4000 slots of A to be migrated from slot 0, got %d migrations", len(plan.Migrations))
+	}
+	if want := map[string]string{"R1": "C", "R2": "B"}; !reflect.DeepEqual(plan.ReplicaReassignments, want) {
+		t.Errorf("ReplicaReassignments = %v, want %v", plan.ReplicaReassignments, want)
+	}
+	if calls := len(fake.Calls("CLUSTER SETSLOT")) + len(fake.Calls("CLUSTER REPLICATE")); calls != 0 {
+		t.Errorf("expected the simulation not to change the cluster")
+	}
+
+	plan, err = a.SimulateRemoval(context.Background(), "R3")
+	if err != nil || len(plan.Migrations) != 0 || len(plan.ReplicaReassignments) != 0 {
+		t.Errorf("expected an empty plan for a replica, got %+v, %v", plan, err)
+	}
+}
+
+func TestAdminAddNode(t *testing.T) {
+	var before, after string
+	seed := newFakeRedis(t, meetHandler("A", &before, &after, 16384))
+	before = fakeClusterNodes(fakeNodeLine("A", seed.Addr(), "myself,master", "-", "0-16383"))
+	after = fakeClusterNodes(
+		fakeNodeLine("A", seed.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("N", "127.0.0.1:7000", "master", "-"),
+	)
+	a := NewAdminWithOptions([]string{seed.Addr()}, AdminOptions{JoinTimeout: 200 * time.Millisecond}).(*Admin)
+
+	if err := a.AddNode(context.Background(), "127.0.0.1:7000"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calls := seed.Calls("CLUSTER MEET")
+	if len(calls) != 1 || calls[0][2] != "127.0.0.1" || calls[0][3] != "7000" {
+		t.Errorf("expected CLUSTER MEET 127.0.0.1 7000, got %v", calls)
+	}
+
+	// the node never leaves the handshake
+	after = fakeClusterNodes(
+		fakeNodeLine("A", seed.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("N", "127.0.0.1:7000", "master", "-"),
+		fakeNodeLine("0f1e", "127.0.0.1:7001", "master,handshake", "-"),
+	)
+	if err := a.AddNode(context.Background(), "127.0.0.1:7001"); err == nil || !strings.Contains(err.Error(), "didn't join the cluster") {
+		t.Errorf("expected a join timeout, got %v", err)
+	}
+}
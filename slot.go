@@ -135,6 +135,28 @@ func DecodeSlotRange(str string) ([]Slot, *ImportingSlot, *MigratingSlot, error)
 	return slots, nil, nil, err
 }
 
+// EncodeSlotRange returns the compact representation of the slots, the inverse of DecodeSlotRange: consecutive
+// slots are collapsed into ranges separated by a space, ex: "0-100 200 300-305". The slots are sorted first,
+// without modifying the provided slice, and an empty string is returned if there is no slot.
+func EncodeSlotRange(slots []Slot) string {
+	return strings.Join(encodeSlotRanges(slots), " ")
+}
+
+// encodeSlotRanges returns the sorted ranges of the slots, without modifying them, ex: ["0-100", "200"]
+func encodeSlotRanges(slots []Slot) []string {
+	sorted := make([]Slot, len(slots))
+	copy(sorted, slots)
+	ranges := []string{}
+	for _, r := range SlotRangesFromSlots(sorted) {
+		if r.Min == r.Max {
+			ranges = append(ranges, r.Min.String())
+		} else {
+			ranges = append(ranges, r.String())
+		}
+	}
+	return ranges
+}
+
 // SlotRangesFromSlots return a slice of slot ranges from a slice of slots
 func SlotRangesFromSlots(slots []Slot) []SlotRange {
 	ranges := []SlotRange{}
@@ -133,6 +133,31 @@ func TestSlotRangesFromSlots(t *testing.T) {
 	}
 }
 
+func TestEncodeSlotRange(t *testing.T) {
+	testTable := []struct {
+		slots   []Slot
+		encoded string
+	}{
+		{[]Slot{305, 0, 200, 301, 302, 303, 304, 300}, "0 200 300-305"},
+		{append(BuildSlotSlice(0, 100), 200), "0-100 200"},
+		{[]Slot{7, 7, 8}, "7-8"}, // duplicates
+		{[]Slot{}, ""},
+		{nil, ""},
+	}
+
+	for i, tt := range testTable {
+		if encoded := EncodeSlotRange(tt.slots); encoded != tt.encoded {
+			t.Errorf("[case %d]expected result to be '%s', got '%s'", i, tt.encoded, encoded)
+		}
+	}
+
+	slots := []Slot{3, 1, 2}
+	EncodeSlotRange(slots)
+	if !reflect.DeepEqual(slots, []Slot{3, 1, 2}) {
+		t.Errorf("expected the provided slots to be left unsorted, got %v", slots)
+	}
+}
+
 func TestRemoveSlots(t *testing.T) {
 	testTable := []struct {
 		sSlice1  []Slot
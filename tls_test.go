@@ -0,0 +1,99 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLoadTLSConfig(t *testing.T) {
+	config, err := LoadTLSConfig("testdata/tls.crt", "testdata/tls.key", "testdata/ca.crt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(config.Certificates) != 1 || config.RootCAs == nil {
+		t.Errorf("expected a client certificate and root CAs, got %+v", config)
+	}
+
+	if _, err := LoadTLSConfig("testdata/tls.crt", "testdata/missing.key", "testdata/ca.crt"); err == nil {
+		t.Errorf("expected an error for a missing key")
+	}
+	if _, err := LoadTLSConfig("testdata/tls.crt", "testdata/tls.key", "testdata/tls.key"); err == nil {
+		t.Errorf("expected an error for a CA file without certificate")
+	}
+}
+
+func TestAdminMutualTLS(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("testdata/tls.crt", "testdata/tls.key")
+	if err != nil {
+		t.Fatalf("unable to load certificate: %v", err)
+	}
+	ca, err := ioutil.ReadFile("testdata/ca.crt")
+	if err != nil {
+		t.Fatalf("unable to read CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+				for {
+					if _, err := readFakeCommand(r); err != nil {
+						return
+					}
+					writeFakeReply(w, fakeStatus("PONG"))
+					w.Flush()
+				}
+			}()
+		}
+	}()
+
+	config, err := LoadTLSConfig("testdata/tls.crt", "testdata/tls.key", "testdata/ca.crt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a := NewAdminWithOptions([]string{ln.Addr().String()}, AdminOptions{TLSConfig: config}).(*Admin)
+	defer a.CloseClient()
+	if err := a.rc.Ping(context.Background()).Err(); err != nil {
+		t.Errorf("unexpected error over mutual TLS: %v", err)
+	}
+
+	plain := NewAdmin([]string{ln.Addr().String()}, "").(*Admin)
+	defer plain.CloseClient()
+	if err := plain.rc.Ping(context.Background()).Err(); err == nil {
+		t.Errorf("expected an error without client certificate")
+	}
+}
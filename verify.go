@@ -0,0 +1,244 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// slotOwners returns the owner ID of each assigned slot
+func (n Nodes) slotOwners() map[Slot]string {
+	owners := make(map[Slot]string)
+	for _, node := range n {
+		if node.GetRole() != RedisMasterRole {
+			continue
+		}
+		for _, slot := range node.Slots {
+			owners[slot] = node.ID
+		}
+	}
+	return owners
+}
+
+// VerifySlotConsensus reads CLUSTER NODES from every master and returns a SlotConsensusError
+// if they disagree about the owner of any slot. It should be called before resharding, to
+// avoid acting during a configuration propagation window.
+func (a *Admin) VerifySlotConsensus(ctx context.Context) error {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+
+	views := make(map[string]map[Slot]string)
+	for _, master := range nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole }) {
+		c := a.nodeClient(master.IPPort())
+		view, err := getClusterNodes(ctx, c)
+		c.Close()
+		if err != nil {
+			return fmt.Errorf("unable to get cluster nodes view from master %s: %v", master.ID, err)
+		}
+		views[master.ID] = view.slotOwners()
+	}
+
+	conflicts := make(map[Slot]map[string]string)
+	for slot := Slot(0); slot <= a.hashMaxSlots; slot++ {
+		owners := make(map[string]string, len(views))
+		agree := true
+		first := true
+		var owner string
+		for id, view := range views {
+			owners[id] = view[slot]
+			if first {
+				owner, first = view[slot], false
+			} else if view[slot] != owner {
+				agree = false
+			}
+		}
+		if !agree {
+			conflicts[slot] = owners
+		}
+	}
+	if len(conflicts) > 0 {
+		return SlotConsensusError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// ExpectSize returns a ClusterSizeError if the cluster_size reported by CLUSTER INFO, the number of
+// masters serving at least one slot, differs from want. It detects a partially joined scale-up.
+func (a *Admin) ExpectSize(ctx context.Context, want int) error {
+	infos, err := getClusterInfos(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.Atoi((*infos)["cluster_size"])
+	if err != nil {
+		return fmt.Errorf("wrong format for cluster_size in CLUSTER INFO: %v", err)
+	}
+	if size != want {
+		return ClusterSizeError{Expected: want, Observed: size}
+	}
+	return nil
+}
+
+// VerifyCluster checks a cluster end-to-end, typically right after its creation: the cluster state is ok,
+// all the slots are covered, there are expectedMasters masters each having expectedReplicasPerMaster
+// replicas, no two masters share a config epoch, and no node is failing or in handshake. All the
+// violations found are returned in a single ClusterVerificationError.
+func (a *Admin) VerifyCluster(ctx context.Context, expectedMasters, expectedReplicasPerMaster int) error {
+	infos, err := getClusterInfos(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return err
+	}
+
+	violations := []string{}
+	if state := (*infos)["cluster_state"]; state != "ok" {
+		violations = append(violations, fmt.Sprintf("cluster state is %s", state))
+	}
+	if ok, total := (*infos)["cluster_slots_ok"], strconv.Itoa(int(a.hashMaxSlots)+1); ok != total {
+		violations = append(violations, fmt.Sprintf("%s slots ok out of %s", ok, total))
+	}
+
+	masters := nodes.FilterByFunc(func(n *Node) bool { return n.GetRole() == RedisMasterRole })
+	if len(masters) != expectedMasters {
+		violations = append(violations, fmt.Sprintf("%d masters, expected %d", len(masters), expectedMasters))
+	}
+	epochs := make(map[int64]string)
+	for _, master := range masters.SortNodes() {
+		replicas := nodes.CountByFunc(func(n *Node) bool { return n.MasterReferent == master.ID })
+		if replicas != expectedReplicasPerMaster {
+			violations = append(violations, fmt.Sprintf("master %s has %d replicas, expected %d", master.ID, replicas, expectedReplicasPerMaster))
+		}
+		if id, ok := epochs[master.ConfigEpoch]; ok {
+			violations = append(violations, fmt.Sprintf("masters %s and %s share config epoch %d", id, master.ID, master.ConfigEpoch))
+		} else {
+			epochs[master.ConfigEpoch] = master.ID
+		}
+	}
+	for _, node := range *nodes {
+		for _, status := range []string{NodeStatusFail, NodeStatusHandshake} {
+			if node.HasStatus(status) {
+				violations = append(violations, fmt.Sprintf("node %s is in %s", node.ID, status))
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return ClusterVerificationError{Violations: violations}
+	}
+	return nil
+}
+
+// ClusterState computes the cluster status from CLUSTER INFO and CLUSTER NODES, for health reporting: ClusterStatusOK
+// when the cluster state is ok with all the slots assigned and no failing node, ClusterStatusKO otherwise along with
+// the human readable reasons.
+func (a *Admin) ClusterState(ctx context.Context) (ClusterStatus, []string, error) {
+	infos, err := getClusterInfos(ctx, a.rc)
+	if err != nil {
+		return ClusterStatusKO, nil, err
+	}
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return ClusterStatusKO, nil, err
+	}
+
+	reasons := []string{}
+	if state := (*infos)["cluster_state"]; state != "ok" {
+		reasons = append(reasons, fmt.Sprintf("cluster state is %s", state))
+	}
+	assigned, err := strconv.Atoi((*infos)["cluster_slots_assigned"])
+	if err != nil {
+		return ClusterStatusKO, nil, fmt.Errorf("wrong format for cluster_slots_assigned in CLUSTER INFO: %v", err)
+	}
+	if unassigned := int(a.hashMaxSlots) + 1 - assigned; unassigned > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d slots unassigned", unassigned))
+	}
+	if failing := nodes.CountByFunc(func(n *Node) bool { return n.HasStatus(NodeStatusFail) }); failing > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d nodes in fail state", failing))
+	}
+
+	if len(reasons) > 0 {
+		return ClusterStatusKO, reasons, nil
+	}
+	return ClusterStatusOK, nil, nil
+}
+
+// routedAddrs returns the addresses of the nodes a cluster client routes commands to: the masters owning
+// slots and their non failing slaves, as CLUSTER SLOTS reports them
+func (n Nodes) routedAddrs() map[string]bool {
+	addrs := make(map[string]bool)
+	for _, node := range n {
+		switch {
+		case IsMasterWithSlot(node):
+			addrs[node.IPPort()] = true
+		case node.GetRole() == RedisSlaveRole && !node.HasStatus(NodeStatusFail):
+			if master, err := n.GetNodeByID(node.MasterReferent); err == nil && IsMasterWithSlot(master) {
+				addrs[node.IPPort()] = true
+			}
+		}
+	}
+	return addrs
+}
+
+// ViewsConsistent returns whether the nodes the cluster client routes to, once its state reloaded, match the
+// ones the single client's CLUSTER NODES reports. A divergence, usual right after a topology change, means
+// the cluster client isn't ready to be used yet.
+func (a *Admin) ViewsConsistent(ctx context.Context) (bool, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return false, err
+	}
+	expected := nodes.routedAddrs()
+
+	var mu sync.Mutex
+	routed := make(map[string]bool)
+	if err := a.rcc.ForEachShard(ctx, func(ctx context.Context, c *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		routed[c.Options().Addr] = true
+		return nil
+	}); err != nil {
+		return false, fmt.Errorf("unable to reload the cluster client state: %v", err)
+	}
+
+	diverging := []string{}
+	for addr := range expected {
+		if !routed[addr] {
+			diverging = append(diverging, addr)
+		}
+	}
+	for addr := range routed {
+		if !expected[addr] {
+			diverging = append(diverging, addr)
+		}
+	}
+	if len(diverging) > 0 {
+		sort.Strings(diverging)
+		logger.V(4).Infof("cluster client and single client views diverge on %v", diverging)
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// clusterNodesHandler returns a handler replying the provided CLUSTER NODES output
+func clusterNodesHandler(raw *string) fakeHandler {
+	return func(args []string) interface{} {
+		if fakeCmdName(args) == "CLUSTER NODES" {
+			return *raw
+		}
+		return fakeOK
+	}
+}
+
+func TestAdminVerifySlotConsensus(t *testing.T) {
+	var viewA, viewB, viewC string
+	a := newFakeRedis(t, clusterNodesHandler(&viewA))
+	b := newFakeRedis(t, clusterNodesHandler(&viewB))
+	c := newFakeRedis(t, clusterNodesHandler(&viewC))
+
+	agreed := fakeClusterNodes(
+		fakeNodeLine("A", a.Addr(), "myself,master", "-", "0-5460"),
+		fakeNodeLine("B", b.Addr(), "master", "-", "5461-10922"),
+		fakeNodeLine("C", c.Addr(), "master", "-", "10923-16383"),
+	)
+	viewA, viewB, viewC = agreed, agreed, agreed
+	admin := newTestAdmin(a.Addr())
+	if err := admin.VerifySlotConsensus(context.Background()); err != nil {
+		t.Fatalf("expected consensus, got error: %v", err)
+	}
+
+	// C didn't receive yet the migration of slots 5461-5462 from B to A
+	viewA = fakeClusterNodes(
+		fakeNodeLine("A", a.Addr(), "myself,master", "-", "0-5462"),
+		fakeNodeLine("B", b.Addr(), "master", "-", "5463-10922"),
+		fakeNodeLine("C", c.Addr(), "master", "-", "10923-16383"),
+	)
+	viewB = viewA
+	err := admin.VerifySlotConsensus(context.Background())
+	if !IsSlotConsensusError(err) {
+		t.Fatalf("expected a SlotConsensusError, got %v", err)
+	}
+	conflicts := err.(SlotConsensusError).Conflicts
+	if len(conflicts) != 2 || conflicts[5461]["C"] != "B" || conflicts[5462]["A"] != "A" {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+	if !strings.Contains(err.Error(), "slots 5461-5462: A sees A, B sees A, C sees B") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+// clusterInfo returns a CLUSTER INFO output
+func clusterInfo(state string, slotsAssigned, slotsFail, knownNodes, size int) string {
+	return fmt.Sprintf("cluster_state:%s\r\ncluster_slots_assigned:%d\r\ncluster_slots_ok:%d\r\ncluster_slots_pfail:0\r\n"+
+		"cluster_slots_fail:%d\r\ncluster_known_nodes:%d\r\ncluster_size:%d\r\ncluster_current_epoch:6\r\ncluster_my_epoch:2\r\n",
+		state, slotsAssigned, slotsAssigned-slotsFail, slotsFail, knownNodes, size)
+}
+
+func TestAdminExpectSize(t *testing.T) {
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		if fakeCmdName(args) == "CLUSTER INFO" {
+			return clusterInfo("ok", 16384, 0, 6, 3)
+		}
+		return fakeError("ERR unexpected command")
+	})
+	a := newTestAdmin(fake.Addr())
+
+	if err := a.ExpectSize(context.Background(), 3); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := a.ExpectSize(context.Background(), 4)
+	if !IsClusterSizeError(err) {
+		t.Fatalf("expected a ClusterSizeError, got %v", err)
+	}
+	if e := err.(ClusterSizeError); e.Observed != 3 || e.Expected != 4 {
+		t.Errorf("unexpected error values: %+v", e)
+	}
+}
+
+// withEpoch returns the CLUSTER NODES line with the config epoch
+func withEpoch(line string, epoch int) string {
+	return strings.Replace(line, " 0 0 1 connected", fmt.Sprintf(" 0 0 %d connected", epoch), 1)
+}
+
+func TestAdminVerifyCluster(t *testing.T) {
+	var view, info string
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return view
+		case "CLUSTER INFO":
+			return info
+		}
+		return fakeOK
+	})
+	a := newTestAdmin(fake.Addr())
+	healthy := []string{
+		withEpoch(fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-8191"), 1),
+		withEpoch(fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "8192-16383"), 2),
+		withEpoch(fakeNodeLine("C", "10.0.0.3:6379", "slave", "A"), 1),
+		withEpoch(fakeNodeLine("D", "10.0.0.4:6379", "slave", "B"), 2),
+	}
+
+	view = fakeClusterNodes(healthy...)
+	info = clusterInfo("ok", 16384, 0, 4, 2)
+	if err := a.VerifyCluster(context.Background(), 2, 1); err != nil {
+		t.Errorf("unexpected error on a healthy cluster: %v", err)
+	}
+
+	view = fakeClusterNodes(
+		healthy[0],
+		withEpoch(fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "8192-16383"), 1),
+		healthy[2],
+		withEpoch(fakeNodeLine("D", "10.0.0.4:6379", "slave,fail", "A"), 1),
+		fakeNodeLine("E", "10.0.0.5:6379", "handshake", "-"),
+	)
+	info = clusterInfo("fail", 16384, 100, 5, 2)
+	err := a.VerifyCluster(context.Background(), 3, 1)
+	if !IsClusterVerificationError(err) {
+		t.Fatalf("expected a ClusterVerificationError, got %v", err)
+	}
+	want := []string{
+		"cluster state is fail",
+		"16284 slots ok out of 16384",
+		"2 masters, expected 3",
+		"master A has 2 replicas, expected 1",
+		"master B has 0 replicas, expected 1",
+		"masters A and B share config epoch 1",
+		"node D is in fail",
+		"node E is in handshake",
+	}
+	if got := err.(ClusterVerificationError).Violations; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Violations =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestAdminClusterState(t *testing.T) {
+	var view, info string
+	fake := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return view
+		case "CLUSTER INFO":
+			return info
+		}
+		return fakeOK
+	})
+	a := newTestAdmin(fake.Addr())
+
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "8192-16383"),
+	)
+	info = clusterInfo("ok", 16384, 0, 2, 2)
+	status, reasons, err := a.ClusterState(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != ClusterStatusOK || len(reasons) != 0 {
+		t.Errorf("expected a healthy cluster, got %s %v", status, reasons)
+	}
+
+	view = fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", "10.0.0.2:6379", "master,fail", "-", "8192-16380"),
+		fakeNodeLine("C", "10.0.0.3:6379", "slave,fail", "B"),
+	)
+	info = clusterInfo("fail", 16381, 8189, 3, 2)
+	status, reasons, err = a.ClusterState(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"cluster state is fail", "3 slots unassigned", "2 nodes in fail state"}
+	if status != ClusterStatusKO || strings.Join(reasons, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ClusterState() = %s %v, want %s %v", status, reasons, ClusterStatusKO, want)
+	}
+}
+
+func TestAdminViewsConsistent(t *testing.T) {
+	var view string
+	var slots []interface{}
+	seed := newFakeRedis(t, func(args []string) interface{} {
+		switch fakeCmdName(args) {
+		case "CLUSTER NODES":
+			return view
+		case "CLUSTER SLOTS":
+			return slots
+		}
+		return fakeOK
+	})
+	view = fakeClusterNodes(
+		fakeNodeLine("A", seed.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "8192-16383"),
+		fakeNodeLine("C", "10.0.0.3:6379", "slave", "A"),
+	)
+	slots = []interface{}{
+		fakeSlotRange(0, 8191, seed.Addr(), "10.0.0.3:6379"),
+		fakeSlotRange(8192, 16383, "10.0.0.2:6379"),
+	}
+	a := newTestAdmin(seed.Addr())
+
+	consistent, err := a.ViewsConsistent(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !consistent {
+		t.Errorf("expected the views to be consistent")
+	}
+
+	// D joined and took slots, but the node serving CLUSTER SLOTS doesn't know it yet
+	view = fakeClusterNodes(
+		fakeNodeLine("A", seed.Addr(), "myself,master", "-", "0-8191"),
+		fakeNodeLine("B", "10.0.0.2:6379", "master", "-", "8192-15999"),
+		fakeNodeLine("C", "10.0.0.3:6379", "slave", "A"),
+		fakeNodeLine("D", "10.0.0.4:6379", "master", "-", "16000-16383"),
+	)
+	consistent, err = a.ViewsConsistent(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if consistent {
+		t.Errorf("expected the views to diverge on D")
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// TopologyEvent represents a topology change detected between two polls
+type TopologyEvent struct {
+	NodesDiff
+	// Nodes the topology after the change
+	Nodes Nodes
+}
+
+// WatchTopology polls GetClusterNodes every interval and emits a TopologyEvent on the returned channel
+// each time the topology differs from the previous poll (see Nodes.Diff). The first snapshot is taken
+// before returning, an error is returned if it fails; later polling errors are logged and skipped.
+// The channel is closed when ctx is done.
+func (a *Admin) WatchTopology(ctx context.Context, interval time.Duration) (<-chan TopologyEvent, error) {
+	nodes, err := getClusterNodes(ctx, a.rc)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan TopologyEvent)
+	go func() {
+		defer close(events)
+		previous := *nodes
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current, err := getClusterNodes(ctx, a.rc)
+			if err != nil {
+				logger.V(2).Infof("unable to poll cluster topology: %v", err)
+				continue
+			}
+			diff := previous.Diff(*current)
+			if diff.IsEmpty() {
+				continue
+			}
+			previous = *current
+			select {
+			case <-ctx.Done():
+				return
+			case events <- TopologyEvent{NodesDiff: diff, Nodes: *current}:
+			}
+		}
+	}()
+	return events, nil
+}
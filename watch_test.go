@@ -0,0 +1,64 @@
+/*
+Copyright 2021 kubernetes-app Solutions.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdminWatchTopology(t *testing.T) {
+	fake := newFakeRedis(t, nil)
+	before := fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,master", "-", "0-16383"),
+		fakeNodeLine("B", "1.2.3.2:6379", "slave", "A"),
+	)
+	fake.SetHandler(clusterNodesHandler(&before))
+	a := newTestAdmin(fake.Addr())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := a.WatchTopology(ctx, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// B is promoted
+	after := fakeClusterNodes(
+		fakeNodeLine("A", fake.Addr(), "myself,slave", "B"),
+		fakeNodeLine("B", "1.2.3.2:6379", "master", "-", "0-16383"),
+	)
+	fake.SetHandler(clusterNodesHandler(&after))
+
+	select {
+	case event := <-events:
+		if len(event.Changed) != 2 || event.Changed[1].ID != "B" || event.Changed[1].After.GetRole() != RedisMasterRole {
+			t.Errorf("unexpected event: %+v", event.NodesDiff)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event received after a role change")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Errorf("expected no more events once the topology is stable")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the events channel to be closed when the context is done")
+	}
+}